@@ -1,29 +1,45 @@
 package kadnet
 
 import (
+	"context"
 	"github.com/alabianca/gokad"
-	"github.com/alabianca/kadnet/buffers"
+	"github.com/alabianca/kadnet/identity"
 	"github.com/alabianca/kadnet/kadconn"
+	"github.com/alabianca/kadnet/kadrand"
 	"github.com/alabianca/kadnet/messages"
+	"github.com/alabianca/kadnet/mutable"
 	"github.com/alabianca/kadnet/request"
 	"github.com/alabianca/kadnet/response"
+	"github.com/alabianca/kadnet/transaction"
 	"net"
+	"time"
 )
 
 type Client struct {
-	ID               gokad.ID
-	Writer           kadconn.KadWriter
-	NodeReplyBuffer  buffers.Buffer
-	PingReplyBuffer  buffers.Buffer
-	StoreReplyBuffer buffers.Buffer
-	ValueReplyBuffer buffers.Buffer
+	ID     gokad.ID
+	Writer kadconn.KadWriter
+	// Transactions correlates the responses to the requests sent by the client
+	Transactions *transaction.Table
+	// Identity proves ID in ping messages. Pings carry no proof if it is nil
+	Identity *identity.Identity
+	// Random produces the RandomIDs of requests. It defaults to kadrand.Crypto() if it is nil
+	Random kadrand.RandomSource
 }
 
 func (c *Client) FindNode(contact gokad.Contact, lookupID gokad.ID) (*response.Response, error) {
+	return c.FindNodeContext(context.Background(), contact, lookupID)
+}
+
+// FindNodeContext is like FindNode but the returned response honours ctx.
+func (c *Client) FindNodeContext(ctx context.Context, contact gokad.Contact, lookupID gokad.ID) (*response.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	fnr := messages.FindNodeRequest{
 		SenderID: c.ID.String(),
 		Payload:  lookupID.String(),
-		RandomID: gokad.GenerateRandomID().String(),
+		RandomID: c.randomID(),
 	}
 
 	b, err := fnr.Bytes()
@@ -32,9 +48,7 @@ func (c *Client) FindNode(contact gokad.Contact, lookupID gokad.ID) (*response.R
 	}
 
 	req := request.New(contact, b)
-	c.do(req)
-
-	res := response.New(contact, fnr.RandomID, c.NodeReplyBuffer)
+	res := c.do(ctx, req, fnr.RandomID)
 	// When the response is successfully read, send the appropriate implicit PingReply
 	res.SendPingReplyFunc = c.implicitPingReplyFunc(req.Address())
 
@@ -42,9 +56,25 @@ func (c *Client) FindNode(contact gokad.Contact, lookupID gokad.ID) (*response.R
 }
 
 func (c *Client) Ping(contact gokad.Contact) (*response.Response, error) {
+	return c.PingContext(context.Background(), contact)
+}
+
+// PingContext is like Ping but the returned response honours ctx.
+func (c *Client) PingContext(ctx context.Context, contact gokad.Contact) (*response.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	ping := messages.PingRequest{
 		SenderID: c.ID.String(),
-		RandomID: gokad.GenerateRandomID().String(),
+		RandomID: c.randomID(),
+	}
+	if c.Identity != nil {
+		signable, err := ping.Signable()
+		if err != nil {
+			return nil, err
+		}
+		ping.Proof = c.Identity.Prove(signable)
 	}
 
 	b, err := ping.Bytes()
@@ -53,21 +83,30 @@ func (c *Client) Ping(contact gokad.Contact) (*response.Response, error) {
 	}
 
 	req := request.New(contact, b)
-	c.do(req)
-
-	res := response.New(contact, ping.RandomID, c.PingReplyBuffer)
+	res := c.do(ctx, req, ping.RandomID)
 	res.SendPingReplyFunc = c.implicitPingReplyFunc(req.Address())
 
 	return res, nil
 }
 
-func (c *Client) Store(contact gokad.Contact, key gokad.ID, value gokad.Value) (*response.Response, error) {
+// Store asks contact to keep value for key during ttl. A ttl of 0 lets contact use its default expiry
+func (c *Client) Store(contact gokad.Contact, key gokad.ID, value gokad.Value, ttl time.Duration) (*response.Response, error) {
+	return c.StoreContext(context.Background(), contact, key, value, ttl)
+}
+
+// StoreContext is like Store but the returned response honours ctx.
+func (c *Client) StoreContext(ctx context.Context, contact gokad.Contact, key gokad.ID, value gokad.Value, ttl time.Duration) (*response.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	store := messages.StoreRequest{
 		SenderID: c.ID.String(),
-		RandomID: gokad.GenerateRandomID().String(),
+		RandomID: c.randomID(),
 		Payload: messages.StoreRequestPayload{
 			Key:   key,
 			Value: value,
+			TTL:   ttl,
 		},
 	}
 
@@ -77,19 +116,26 @@ func (c *Client) Store(contact gokad.Contact, key gokad.ID, value gokad.Value) (
 	}
 
 	req := request.New(contact, b)
-	c.do(req)
-
-	res := response.New(contact, "", c.StoreReplyBuffer)
+	res := c.do(ctx, req, store.RandomID)
 	res.SendPingReplyFunc = c.implicitPingReplyFunc(req.Address())
 
 	return res, nil
 }
 
 func (c *Client) FindValue(contact gokad.Contact, hash string) (*response.Response, error) {
+	return c.FindValueContext(context.Background(), contact, hash)
+}
+
+// FindValueContext is like FindValue but the returned response honours ctx.
+func (c *Client) FindValueContext(ctx context.Context, contact gokad.Contact, hash string) (*response.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	fv := messages.FindValueRequest{
 		SenderID:     c.ID.String(),
 		Payload:      hash,
-		RandomID:     gokad.GenerateRandomID().String(),
+		RandomID:     c.randomID(),
 	}
 
 	b, err := fv.Bytes()
@@ -98,9 +144,129 @@ func (c *Client) FindValue(contact gokad.Contact, hash string) (*response.Respon
 	}
 
 	req := request.New(contact, b)
-	c.do(req)
+	res := c.do(ctx, req, fv.RandomID)
+	res.SendPingReplyFunc = c.implicitPingReplyFunc(req.Address())
+
+	return res, nil
+}
+
+// StoreData asks contact to keep the opaque value data for key during ttl. A ttl of 0 lets contact use its default expiry
+func (c *Client) StoreData(contact gokad.Contact, key gokad.ID, data []byte, ttl time.Duration) (*response.Response, error) {
+	return c.StoreDataContext(context.Background(), contact, key, data, ttl)
+}
+
+// StoreDataContext is like StoreData but the returned response honours ctx.
+func (c *Client) StoreDataContext(ctx context.Context, contact gokad.Contact, key gokad.ID, data []byte, ttl time.Duration) (*response.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	store := messages.StoreDataRequest{
+		SenderID: c.ID.String(),
+		RandomID: c.randomID(),
+		Payload: messages.StoreDataRequestPayload{
+			Key:  key,
+			Data: data,
+			TTL:  ttl,
+		},
+	}
+
+	b, err := store.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	req := request.New(contact, b)
+	res := c.do(ctx, req, store.RandomID)
+	res.SendPingReplyFunc = c.implicitPingReplyFunc(req.Address())
+
+	return res, nil
+}
+
+func (c *Client) FindData(contact gokad.Contact, hash string) (*response.Response, error) {
+	return c.FindDataContext(context.Background(), contact, hash)
+}
+
+// FindDataContext is like FindData but the returned response honours ctx.
+func (c *Client) FindDataContext(ctx context.Context, contact gokad.Contact, hash string) (*response.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	fd := messages.FindDataRequest{
+		SenderID: c.ID.String(),
+		Payload:  hash,
+		RandomID: c.randomID(),
+	}
+
+	b, err := fd.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	req := request.New(contact, b)
+	res := c.do(ctx, req, fd.RandomID)
+	res.SendPingReplyFunc = c.implicitPingReplyFunc(req.Address())
+
+	return res, nil
+}
+
+// StoreMutable asks contact to keep the signed record during ttl. A ttl of 0 lets contact use its default expiry
+func (c *Client) StoreMutable(contact gokad.Contact, record mutable.Record, ttl time.Duration) (*response.Response, error) {
+	return c.StoreMutableContext(context.Background(), contact, record, ttl)
+}
+
+// StoreMutableContext is like StoreMutable but the returned response honours ctx.
+func (c *Client) StoreMutableContext(ctx context.Context, contact gokad.Contact, record mutable.Record, ttl time.Duration) (*response.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	store := messages.StoreMutableRequest{
+		SenderID: c.ID.String(),
+		RandomID: c.randomID(),
+		Payload: messages.StoreMutableRequestPayload{
+			Key:    record.Key(),
+			Record: record,
+			TTL:    ttl,
+		},
+	}
+
+	b, err := store.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	req := request.New(contact, b)
+	res := c.do(ctx, req, store.RandomID)
+	res.SendPingReplyFunc = c.implicitPingReplyFunc(req.Address())
+
+	return res, nil
+}
+
+func (c *Client) FindMutable(contact gokad.Contact, hash string) (*response.Response, error) {
+	return c.FindMutableContext(context.Background(), contact, hash)
+}
+
+// FindMutableContext is like FindMutable but the returned response honours ctx.
+func (c *Client) FindMutableContext(ctx context.Context, contact gokad.Contact, hash string) (*response.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	fm := messages.FindMutableRequest{
+		SenderID: c.ID.String(),
+		Payload:  hash,
+		RandomID: c.randomID(),
+	}
 
-	res := response.New(contact, fv.RandomID, c.ValueReplyBuffer)
+	b, err := fm.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	req := request.New(contact, b)
+	res := c.do(ctx, req, fm.RandomID)
 	res.SendPingReplyFunc = c.implicitPingReplyFunc(req.Address())
 
 	return res, nil
@@ -110,14 +276,44 @@ func (c *Client) implicitPingReplyFunc(address net.Addr) func(echoRandomID strin
 	return func(echoRandomID string) {
 		pingRes := messages.Implicit()
 		pingRes.SenderID = c.ID.String()
-		pingRes.RandomID = gokad.GenerateRandomID().String()
+		pingRes.RandomID = c.randomID()
 		pingRes.EchoRandomID = echoRandomID
+		if c.Identity != nil {
+			signable, err := pingRes.Signable()
+			if err != nil {
+				return
+			}
+			pingRes.Proof = c.Identity.Prove(signable)
+		}
 		if b, err := pingRes.Bytes(); err == nil {
 			c.Writer.Write(b, address)
 		}
 	}
 }
 
-func (c *Client) do(req *request.Request) {
-	go c.Writer.Write(req.Body, req.Address())
+func (c *Client) randomID() string {
+	if c.Random == nil {
+		return kadrand.Crypto().ID().String()
+	}
+
+	return c.Random.ID().String()
+}
+
+// do registers a transaction for the request's RandomID before the request is sent,
+// so the response cannot arrive before anyone waits for it
+func (c *Client) do(ctx context.Context, req *request.Request, randomID string) *response.Response {
+	var sender gokad.ID
+	if len(req.Contact.ID) > 0 {
+		sender = req.Contact.ID
+	}
+	deadline, _ := ctx.Deadline()
+	tx := c.Transactions.Register(randomID, sender, deadline)
+	go func() {
+		// a contact of an address family the node cannot reach fails right away
+		if _, err := c.Writer.Write(req.Body, req.Address()); err != nil {
+			tx.Fail(err)
+		}
+	}()
+
+	return response.New(req.Contact, tx).WithContext(ctx)
 }
@@ -1,20 +1,128 @@
 package kadnet
 
 import (
+	"bytes"
 	"github.com/alabianca/gokad"
-	"net"
+	"github.com/alabianca/kadnet/identity"
+	"github.com/alabianca/kadnet/kadclock"
+	"github.com/alabianca/kadnet/messages"
 	"sync"
+	"time"
 )
 
+// maxReplacements is the size of the replacement cache of every k-bucket
+const maxReplacements = 20
+
+// maxProving is the number of contacts that may wait for or be in an identity ping at the same time
+const maxProving = 64
+
+// bucketCheck asks for the least recently seen contact of a full k-bucket to be pinged
+type bucketCheck struct {
+	index int
+	lrs   gokad.Contact
+	// size is the number of contacts the bucket held when it was full
+	size int
+}
+
 type dhtProxy struct {
 	dht *gokad.DHT
 	mtx sync.Mutex
+	// lastUsed holds the time each k-bucket was last used by an insert or a lookup in its range
+	lastUsed [gokad.MaxRoutingTableSize]time.Time
+	// replacements holds the contacts that did not fit into a full k-bucket. The most recently seen is last
+	replacements map[int][]gokad.Contact
+	// checking holds the k-buckets whose least recently seen contact is currently being pinged
+	checking map[int]bool
+	// deferred holds the k-buckets whose liveness check did not fit into checks. It is requested again on the next insert
+	deferred map[int]bool
+	checks   chan bucketCheck
+	// addresses holds the addresses of the contacts in the routing table, one per address family.
+	// The routing table keeps a single address per id, so this is where a dual-stack node is known by both
+	addresses map[string]map[messages.AddressFamily]gokad.Contact
+	evicted   uint64
+	events    *eventBus
+	clock     kadclock.Clock
+	// proofRequired is set if contacts must prove their id before they are inserted. Their ids solve a puzzle of difficulty
+	proofRequired bool
+	difficulty    int
+	// proofs queues the contacts that are pinged to prove their id before they are inserted
+	proofs chan gokad.Contact
+	// proving holds the ids of the contacts in proofs or in an identity ping
+	proving map[string]bool
+}
+
+func newDhtProxy(dht *gokad.DHT, events *eventBus, clock kadclock.Clock) *dhtProxy {
+	proxy := &dhtProxy{
+		dht:          dht,
+		mtx:          sync.Mutex{},
+		events:       events,
+		clock:        clock,
+		replacements: make(map[int][]gokad.Contact),
+		checking:     make(map[int]bool),
+		deferred:     make(map[int]bool),
+		checks:       make(chan bucketCheck, 32),
+		addresses:    make(map[string]map[messages.AddressFamily]gokad.Contact),
+		proofs:       make(chan gokad.Contact, maxProving),
+		proving:      make(map[string]bool),
+	}
+
+	now := clock.Now()
+	for i := range proxy.lastUsed {
+		proxy.lastUsed[i] = now
+	}
+
+	return proxy
+}
+
+// requireProof stops contacts learned from lookups from being inserted. Only contacts that proved an id
+// solving a puzzle of difficulty in the ping handshake are inserted then
+func (proxy *dhtProxy) requireProof(difficulty int) {
+	proxy.mtx.Lock()
+	defer proxy.mtx.Unlock()
+	proxy.proofRequired = true
+	proxy.difficulty = difficulty
+}
+
+// acceptsUnproven reports if c may be inserted without proving its id.
+// If proofs are required it reports if c is worth contacting at all
+func (proxy *dhtProxy) acceptsUnproven(c gokad.Contact) (insert bool, contact bool) {
+	proxy.mtx.Lock()
+	defer proxy.mtx.Unlock()
+	if !proxy.proofRequired {
+		return true, true
+	}
+
+	return false, identity.Solves(c.ID, proxy.difficulty)
+}
+
+// requestProof queues c to be pinged, so it is inserted once it proved its id. Contacts that are known or
+// already being proven are left out. If too many are being proven c is dropped until it is learned again.
+// It does nothing if proofs are not required
+func (proxy *dhtProxy) requestProof(c gokad.Contact) {
+	proxy.mtx.Lock()
+	defer proxy.mtx.Unlock()
+	if !proxy.proofRequired || !identity.Solves(c.ID, proxy.difficulty) || bytes.Equal(c.ID, proxy.dht.ID) {
+		return
+	}
+	if proxy.proving[string(c.ID)] || len(proxy.proving) >= maxProving || proxy.contains(c.ID) {
+		return
+	}
+
+	select {
+	case proxy.proofs <- c:
+		proxy.proving[string(c.ID)] = true
+	default:
+	}
 }
 
-func newDhtProxy(dht *gokad.DHT) *dhtProxy {
-	return &dhtProxy{
-		dht: dht,
-		mtx: sync.Mutex{},
+// proven ends the proof of c. c is inserted if it proved its id
+func (proxy *dhtProxy) proven(c gokad.Contact, ok bool) {
+	proxy.mtx.Lock()
+	delete(proxy.proving, string(c.ID))
+	proxy.mtx.Unlock()
+
+	if ok {
+		proxy.insert(c)
 	}
 }
 
@@ -24,16 +132,255 @@ func (proxy *dhtProxy) getOwnID() []byte {
 	return id
 }
 
+// insert adds c to its k-bucket. If the bucket is full, c is kept in the bucket's replacement cache
+// and a liveness check of the bucket's least recently seen contact is requested on proxy.checks.
 func (proxy *dhtProxy) insert(c gokad.Contact) (gokad.Contact, int, error) {
 	proxy.mtx.Lock()
 	defer proxy.mtx.Unlock()
-	return proxy.dht.RoutingTable().Add(c)
+	known := proxy.contains(c.ID)
+	contact, index, err := proxy.dht.RoutingTable().Add(c)
+	if index < 0 || index >= gokad.MaxRoutingTableSize {
+		return contact, index, err
+	}
+
+	if err == nil {
+		proxy.lastUsed[index] = proxy.clock.Now()
+		proxy.remember(c)
+		if !known {
+			proxy.events.publish(Event{Type: ContactAdded, Contact: c, Bucket: index})
+		}
+		if proxy.deferred[index] {
+			if lrs, size, ok := proxy.leastRecentlySeen(index); ok {
+				proxy.requestCheck(bucketCheck{index: index, lrs: lrs, size: size})
+			}
+		}
+		return contact, index, err
+	}
+
+	// the bucket is full and contact is its least recently seen contact
+	if contact.ID != nil && !bytes.Equal(contact.ID, c.ID) {
+		proxy.addReplacement(index, c)
+		if _, size, ok := proxy.leastRecentlySeen(index); ok {
+			proxy.requestCheck(bucketCheck{index: index, lrs: contact, size: size})
+		}
+	}
+
+	return contact, index, err
+}
+
+// requestCheck queues check unless its k-bucket is already being checked. If the queue is full
+// the k-bucket is checked on its next insert. The caller must hold proxy.mtx
+func (proxy *dhtProxy) requestCheck(check bucketCheck) {
+	if proxy.checking[check.index] {
+		return
+	}
+
+	select {
+	case proxy.checks <- check:
+		proxy.checking[check.index] = true
+		delete(proxy.deferred, check.index)
+	default:
+		proxy.deferred[check.index] = true
+	}
+}
+
+// leastRecentlySeen returns the least recently seen contact of the k-bucket at index and the number of contacts in it.
+// The caller must hold proxy.mtx
+func (proxy *dhtProxy) leastRecentlySeen(index int) (gokad.Contact, int, bool) {
+	bucket, ok := proxy.dht.RoutingTable().Bucket(index)
+	if !ok {
+		return gokad.Contact{}, 0, false
+	}
+
+	var lrs gokad.Contact
+	var size int
+	bucket.Walk(func(c gokad.Contact) bool {
+		if size == 0 {
+			lrs = c
+		}
+		size++
+		return false
+	})
+
+	return lrs, size, size > 0
 }
 
-func (proxy *dhtProxy) store(key gokad.ID, ip net.IP, port int) {
+func (proxy *dhtProxy) addReplacement(index int, c gokad.Contact) {
+	cache := proxy.replacements[index]
+	for i, r := range cache {
+		if bytes.Equal(r.ID, c.ID) {
+			cache = append(cache[:i], cache[i+1:]...)
+			break
+		}
+	}
+
+	cache = append(cache, c)
+	if len(cache) > maxReplacements {
+		cache = cache[1:]
+	}
+
+	proxy.replacements[index] = cache
+}
+
+// replacementCache returns a copy of the replacement cache of the k-bucket at index
+func (proxy *dhtProxy) replacementCache(index int) []gokad.Contact {
 	proxy.mtx.Lock()
 	defer proxy.mtx.Unlock()
-	proxy.dht.Store(key, ip, port)
+	out := make([]gokad.Contact, len(proxy.replacements[index]))
+	copy(out, proxy.replacements[index])
+	return out
+}
+
+// alive is called once the least recently seen contact of a full k-bucket answered its liveness check.
+// It stays in the bucket and becomes the most recently seen contact
+func (proxy *dhtProxy) alive(check bucketCheck) {
+	proxy.mtx.Lock()
+	defer proxy.mtx.Unlock()
+	delete(proxy.checking, check.index)
+	proxy.dht.RoutingTable().Add(check.lrs)
+}
+
+// evict removes the least recently seen contact of a full k-bucket after it failed its liveness check.
+// The most recently seen contact of the bucket's replacement cache takes its place.
+// Nothing is evicted if the contact was seen again while it was pinged or the bucket is not full anymore
+func (proxy *dhtProxy) evict(check bucketCheck) (gokad.Contact, bool) {
+	proxy.mtx.Lock()
+	defer proxy.mtx.Unlock()
+	delete(proxy.checking, check.index)
+
+	bucket, ok := proxy.dht.RoutingTable().Bucket(check.index)
+	if !ok {
+		return gokad.Contact{}, false
+	}
+
+	lrs, size, ok := proxy.leastRecentlySeen(check.index)
+	if ok && !bytes.Equal(lrs.ID, check.lrs.ID) {
+		return gokad.Contact{}, false
+	}
+
+	// a bucket that is not full anymore has room for a replacement without evicting anyone
+	if ok && size >= check.size {
+		bucket.Remove(check.lrs.ID)
+		delete(proxy.addresses, check.lrs.ID.String())
+		proxy.evicted++
+		proxy.events.publish(Event{Type: ContactEvicted, Contact: check.lrs, Bucket: check.index})
+	}
+
+	cache := proxy.replacements[check.index]
+	if len(cache) == 0 {
+		return gokad.Contact{}, false
+	}
+
+	replacement := cache[len(cache)-1]
+	proxy.replacements[check.index] = cache[:len(cache)-1]
+	if _, _, err := proxy.dht.RoutingTable().Add(replacement); err != nil {
+		return gokad.Contact{}, false
+	}
+	proxy.lastUsed[check.index] = proxy.clock.Now()
+	proxy.remember(replacement)
+	proxy.events.publish(Event{Type: ContactAdded, Contact: replacement, Bucket: check.index})
+
+	return replacement, true
+}
+
+// remember keeps the address of c as the address of its node in the address family of c.
+// The caller must hold proxy.mtx
+func (proxy *dhtProxy) remember(c gokad.Contact) {
+	family := messages.FamilyOf(c.IP)
+	if family == 0 {
+		return
+	}
+
+	addrs, ok := proxy.addresses[c.ID.String()]
+	if !ok {
+		addrs = make(map[messages.AddressFamily]gokad.Contact)
+		proxy.addresses[c.ID.String()] = addrs
+	}
+	addrs[family] = c
+}
+
+// withAddresses returns every contact of cs followed by the addresses its node has in other address families.
+// The caller must hold proxy.mtx
+func (proxy *dhtProxy) withAddresses(cs []gokad.Contact) []gokad.Contact {
+	out := make([]gokad.Contact, 0, len(cs))
+	for _, c := range cs {
+		out = append(out, c)
+		for _, family := range []messages.AddressFamily{messages.IPv4, messages.IPv6} {
+			if other, ok := proxy.addresses[c.ID.String()][family]; ok && family != messages.FamilyOf(c.IP) {
+				out = append(out, other)
+			}
+		}
+	}
+
+	return out
+}
+
+// addressesOf is like withAddresses but takes proxy.mtx
+func (proxy *dhtProxy) addressesOf(cs []gokad.Contact) []gokad.Contact {
+	proxy.mtx.Lock()
+	defer proxy.mtx.Unlock()
+	return proxy.withAddresses(cs)
+}
+
+// contains reports whether the routing table holds a contact with id. The caller must hold proxy.mtx
+func (proxy *dhtProxy) contains(id gokad.ID) bool {
+	index := bucketIndex(proxy.dht.ID, id)
+	if index < 0 {
+		return false
+	}
+
+	bucket, ok := proxy.dht.RoutingTable().Bucket(index)
+	if !ok {
+		return false
+	}
+
+	var found bool
+	bucket.Walk(func(c gokad.Contact) bool {
+		found = bytes.Equal(c.ID, id)
+		return found
+	})
+
+	return found
+}
+
+// evictions returns the number of contacts evicted from the routing table
+func (proxy *dhtProxy) evictions() uint64 {
+	proxy.mtx.Lock()
+	defer proxy.mtx.Unlock()
+	return proxy.evicted
+}
+
+// touch marks the k-bucket whose range covers id as used
+func (proxy *dhtProxy) touch(id gokad.ID) {
+	index := bucketIndex(proxy.dht.ID, id)
+	if index < 0 {
+		return
+	}
+
+	proxy.mtx.Lock()
+	defer proxy.mtx.Unlock()
+	proxy.lastUsed[index] = proxy.clock.Now()
+}
+
+// staleBuckets returns the indices of all k-buckets from the lowest non-empty one upwards
+// that have not been used for longer than idle
+func (proxy *dhtProxy) staleBuckets(idle time.Duration) []int {
+	proxy.mtx.Lock()
+	defer proxy.mtx.Unlock()
+	lowest, ok := proxy.lowestNonEmptyBucketLocked()
+	if !ok {
+		return nil
+	}
+
+	deadline := proxy.clock.Now().Add(-idle)
+	out := make([]int, 0)
+	for i := lowest; i < gokad.MaxRoutingTableSize; i++ {
+		if proxy.lastUsed[i].Before(deadline) {
+			out = append(out, i)
+		}
+	}
+
+	return out
 }
 
 func (proxy *dhtProxy) getAlphaNodes(alpha int, id gokad.ID) []gokad.Contact {
@@ -42,20 +389,36 @@ func (proxy *dhtProxy) getAlphaNodes(alpha int, id gokad.ID) []gokad.Contact {
 	return proxy.dht.GetAlphaNodes(alpha, id)
 }
 
+// findNode returns the k closest contacts to id. Nodes known in more than one address family are returned once per family
 func (proxy *dhtProxy) findNode(id gokad.ID) []gokad.Contact {
 	proxy.mtx.Lock()
 	defer proxy.mtx.Unlock()
 
-	return proxy.dht.FindNode(id)
+	return proxy.withAddresses(proxy.dht.FindNode(id))
 }
 
-func (proxy *dhtProxy) findValue(key gokad.ID) ([]gokad.Contact, gokad.Value) {
+// walk calls f for every contact of the routing table. f is called on a copy taken under proxy.mtx,
+// so it may use the proxy itself
+func (proxy *dhtProxy) walk(f func(bucketIndex int, c gokad.Contact)) {
+	type entry struct {
+		index   int
+		contact gokad.Contact
+	}
+
 	proxy.mtx.Lock()
-	defer proxy.mtx.Unlock()
-	return proxy.dht.FindValue(key)
+	entries := make([]entry, 0)
+	proxy.walkLocked(func(bucketIndex int, c gokad.Contact) {
+		entries = append(entries, entry{index: bucketIndex, contact: c})
+	})
+	proxy.mtx.Unlock()
+
+	for _, e := range entries {
+		f(e.index, e.contact)
+	}
 }
 
-func (proxy *dhtProxy) walk(f func(bucketIndex int, c gokad.Contact)) {
+// walkLocked is like walk but calls f while the routing table is read. The caller must hold proxy.mtx
+func (proxy *dhtProxy) walkLocked(f func(bucketIndex int, c gokad.Contact)) {
 	routing := proxy.dht.RoutingTable()
 	for i := 0; i < gokad.MaxRoutingTableSize; i++ {
 		bucket, ok := routing.Bucket(i)
@@ -67,3 +430,50 @@ func (proxy *dhtProxy) walk(f func(bucketIndex int, c gokad.Contact)) {
 		}
 	}
 }
+
+// lowestNonEmptyBucket returns the index of the closest k-bucket that holds at least one contact
+func (proxy *dhtProxy) lowestNonEmptyBucket() (int, bool) {
+	proxy.mtx.Lock()
+	defer proxy.mtx.Unlock()
+	return proxy.lowestNonEmptyBucketLocked()
+}
+
+// lowestNonEmptyBucketLocked is like lowestNonEmptyBucket. The caller must hold proxy.mtx
+func (proxy *dhtProxy) lowestNonEmptyBucketLocked() (int, bool) {
+	lowest := -1
+	proxy.walkLocked(func(bucketIndex int, c gokad.Contact) {
+		if lowest == -1 || bucketIndex < lowest {
+			lowest = bucketIndex
+		}
+	})
+
+	return lowest, lowest != -1
+}
+
+// stats returns the number of non-empty k-buckets and the total number of contacts in the routing table
+func (proxy *dhtProxy) stats() (buckets int, contacts int) {
+	proxy.mtx.Lock()
+	defer proxy.mtx.Unlock()
+	seen := make(map[int]bool)
+	proxy.walkLocked(func(bucketIndex int, c gokad.Contact) {
+		seen[bucketIndex] = true
+		contacts++
+	})
+
+	return len(seen), contacts
+}
+
+// bucketIndex returns the index of the k-bucket id falls into as seen from own.
+// It is the position of the highest bit set in the distance between the two. -1 is returned if own equals id
+func bucketIndex(own gokad.ID, id gokad.ID) int {
+	distance := own.DistanceTo(id)
+	for i := 0; i < gokad.SIZE; i++ {
+		for bit := 7; bit >= 0; bit-- {
+			if distance[i]&(1<<uint(bit)) != 0 {
+				return (gokad.SIZE-1-i)*8 + bit
+			}
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,125 @@
+package kadnet
+
+import (
+	"github.com/alabianca/gokad"
+	"github.com/alabianca/kadnet/kadclock"
+	"github.com/alabianca/kadnet/messages"
+	"sync"
+	"time"
+)
+
+type EventType int
+
+const (
+	// ContactAdded is published when a new contact is inserted into a k-bucket
+	ContactAdded EventType = iota + 1
+	// ContactEvicted is published when a contact is removed from a k-bucket after failing its liveness check
+	ContactEvicted
+	// RequestReceived is published for every request received from another node
+	RequestReceived
+	// ValueStored is published when another node stored a value at this node
+	ValueStored
+	// LookupStarted is published when a node or value lookup starts
+	LookupStarted
+	// LookupFinished is published when a node or value lookup returns
+	LookupFinished
+	// ListenError is published when Listen fails
+	ListenError
+	// Listening is published when Listen is ready to send and receive messages
+	Listening
+)
+
+func (t EventType) String() string {
+	switch t {
+	case ContactAdded:
+		return "ContactAdded"
+	case ContactEvicted:
+		return "ContactEvicted"
+	case RequestReceived:
+		return "RequestReceived"
+	case ValueStored:
+		return "ValueStored"
+	case LookupStarted:
+		return "LookupStarted"
+	case LookupFinished:
+		return "LookupFinished"
+	case ListenError:
+		return "ListenError"
+	case Listening:
+		return "Listening"
+	default:
+		return "Unknown"
+	}
+}
+
+// Event describes something the node did. Only the fields that apply to the event's Type are set
+type Event struct {
+	Type EventType
+	Time time.Time
+	// Contact is the contact that was added or evicted, the sender of a request or the node that stored a value
+	Contact gokad.Contact
+	// Bucket is the index of the k-bucket a contact was added to or evicted from
+	Bucket int
+	// Request is the message type of a received request
+	Request messages.MessageType
+	// Key is the key of a stored value or the target of a lookup
+	Key gokad.ID
+	// Found is the number of contacts a finished lookup returned
+	Found int
+	// Rounds is the number of rounds of FIND_X_RPC's a finished lookup took
+	Rounds int
+	// Err is the error a lookup finished with or the error returned by Listen
+	Err error
+}
+
+// eventBus fans out events to all subscribers.
+// Publishing never blocks. Events are dropped for subscribers that do not keep up
+type eventBus struct {
+	mtx         sync.Mutex
+	subscribers map[int]chan Event
+	next        int
+	// clock stamps the Time of every event
+	clock kadclock.Clock
+}
+
+func newEventBus(clock kadclock.Clock) *eventBus {
+	return &eventBus{subscribers: make(map[int]chan Event), clock: clock}
+}
+
+func (b *eventBus) subscribe(buffer int) (<-chan Event, func()) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	id := b.next
+	b.next++
+	events := make(chan Event, buffer)
+	b.subscribers[id] = events
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			b.mtx.Lock()
+			defer b.mtx.Unlock()
+			delete(b.subscribers, id)
+			close(events)
+		})
+	}
+
+	return events, unsubscribe
+}
+
+// publish sends e to all subscribers. It is safe to call on a nil bus
+func (b *eventBus) publish(e Event) {
+	if b == nil {
+		return
+	}
+
+	e.Time = b.clock.Now()
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	for _, events := range b.subscribers {
+		select {
+		case events <- e:
+		default:
+		}
+	}
+}
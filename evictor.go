@@ -0,0 +1,55 @@
+package kadnet
+
+import (
+	"context"
+	"sync"
+)
+
+// evictor implements the least recently seen eviction policy of full k-buckets.
+// Whenever a contact does not fit into its full k-bucket, the bucket's least recently seen contact
+// is pinged. It is evicted only if it fails to answer. Otherwise the newcomer stays in the bucket's replacement cache
+type evictor struct {
+	node *Node
+}
+
+func newEvictor(n *Node) *evictor {
+	return &evictor{node: n}
+}
+
+// Run pings the least recently seen contacts of full k-buckets until it receives on exit.
+// Pings that are in progress are cancelled before Run returns
+func (e *evictor) Run(exit <-chan chan error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for {
+		select {
+		case out := <-exit:
+			cancel()
+			wg.Wait()
+			out <- nil
+			return
+
+		case check := <-e.node.dht.checks:
+			wg.Add(1)
+			go func(check bucketCheck) {
+				defer wg.Done()
+				e.check(ctx, check)
+			}(check)
+		}
+	}
+}
+
+func (e *evictor) check(ctx context.Context, check bucketCheck) {
+	lrs := check.lrs
+	_, err := e.node.PingContext(ctx, lrs.IP, lrs.Port, lrs.ID)
+	if ctx.Err() != nil {
+		return
+	}
+
+	if err == nil {
+		e.node.dht.alive(check)
+		return
+	}
+
+	e.node.dht.evict(check)
+}
@@ -1,16 +1,38 @@
 package kadnet
 
 import (
+	"bytes"
 	"github.com/alabianca/gokad"
-	"github.com/alabianca/kadnet/buffers"
+	"github.com/alabianca/kadnet/identity"
+	"github.com/alabianca/kadnet/kadclock"
 	"github.com/alabianca/kadnet/kadconn"
+	"github.com/alabianca/kadnet/kadlog"
 	"github.com/alabianca/kadnet/kadmux"
+	"github.com/alabianca/kadnet/kadrand"
 	"github.com/alabianca/kadnet/messages"
 	"github.com/alabianca/kadnet/request"
-	"log"
+	"github.com/alabianca/kadnet/storage"
+	"github.com/alabianca/kadnet/transaction"
+	"sort"
+	"sync"
+	"time"
 )
 
-func onFindNode(proxy *dhtProxy) kadmux.RpcHandlerFunc {
+// observeRequests publishes a RequestReceived event for every request
+func observeRequests(events *eventBus) func(next kadmux.RpcHandler) kadmux.RpcHandler {
+	return func(next kadmux.RpcHandler) kadmux.RpcHandler {
+		fn := func(conn kadconn.KadWriter, req *request.Request) {
+			mux, _ := req.Body.MultiplexKey()
+			events.publish(Event{Type: RequestReceived, Contact: req.Contact, Request: mux})
+
+			next.Handle(conn, req)
+		}
+
+		return kadmux.RpcHandlerFunc(fn)
+	}
+}
+
+func onFindNode(proxy *dhtProxy, random kadrand.RandomSource, logger kadlog.Logger) kadmux.RpcHandlerFunc {
 	return func(conn kadconn.KadWriter, req *request.Request) {
 		randomId, _ := req.Body.RandomID()
 		payload, _ := req.Body.Payload()
@@ -21,41 +43,34 @@ func onFindNode(proxy *dhtProxy) kadmux.RpcHandlerFunc {
 			SenderID:     proxy.dht.ID.String(),
 			EchoRandomID: gokad.ID(randomId).String(),
 			Payload:      contacts,
-			RandomID:     gokad.GenerateRandomID().String(),
+			RandomID:     random.ID().String(),
 		}
 
 		bts, err := res.Bytes()
 		if err != nil {
-			log.Printf("Error %s\n", err)
+			logger.Log(kadlog.Error, "could not encode response", kadlog.F("type", "FindNodeResponse"), kadlog.F("error", err))
 			return
 		}
 
-		conn.Write(bts, req.Address())
+		reply(conn, bts, req, logger)
 	}
 }
 
-func onPingReplyImplicit(proxy *dhtProxy, buffer buffers.Buffer) kadmux.RpcHandlerFunc {
+// onPingReplyImplicit inserts the sender into the routing table if the PingReply answers a request we responded to.
+// If the node has an identity the sender must prove its id with a puzzle of difficulty first
+func onPingReplyImplicit(proxy *dhtProxy, expected *transaction.Table, ident *identity.Identity, difficulty int, logger kadlog.Logger) kadmux.RpcHandlerFunc {
 	return func(conn kadconn.KadWriter, req *request.Request) {
-		// find the pingReplyMessage in the buffer
-		id, err := req.Body.EchoRandomID()
-		if err != nil {
-			return
-		}
-		sid, err := req.Body.SenderID()
-		if err != nil {
+		if !expected.Deliver(req.Body) {
 			return
 		}
 
-		key := sid.String() + gokad.ID(id).String()
-		reader := buffer.NewReader(key)
-
-		var prm messages.PingResponse
-		if _, err := reader.Read(&prm); err != nil {
-			return
-		}
-
-		if prm.SenderID + prm.EchoRandomID != key {
-			return
+		if ident != nil {
+			var pr messages.PingResponse
+			messages.ToKademliaMessage(req.Body, &pr)
+			if err := verifyPingResponse(&pr, difficulty); err != nil {
+				logger.Log(kadlog.Warn, "rejected ping reply", kadlog.F("sender", pr.SenderID), kadlog.F("remote", req.Address()), kadlog.F("error", err))
+				return
+			}
 		}
 
 		// they match. Let's attempt to insert contact to our dht
@@ -64,28 +79,73 @@ func onPingReplyImplicit(proxy *dhtProxy, buffer buffers.Buffer) kadmux.RpcHandl
 	}
 }
 
-func onPingRequest(myID gokad.ID) kadmux.RpcHandlerFunc {
+// onPingRequest answers a ping. If the node has an identity the sender must prove its id with a puzzle
+// of difficulty and the answer proves the id of the node
+func onPingRequest(myID gokad.ID, ident *identity.Identity, difficulty int, random kadrand.RandomSource, logger kadlog.Logger) kadmux.RpcHandlerFunc {
 	return func(conn kadconn.KadWriter, req *request.Request) {
 		rid, err := req.Body.RandomID()
 		if err != nil {
 			return
 		}
 
+		if ident != nil {
+			var ping messages.PingRequest
+			messages.ToKademliaMessage(req.Body, &ping)
+			if err := verifyPingRequest(&ping, difficulty); err != nil {
+				logger.Log(kadlog.Warn, "rejected ping", kadlog.F("sender", ping.SenderID), kadlog.F("remote", req.Address()), kadlog.F("error", err))
+				return
+			}
+		}
+
 		res := messages.Explicit()
-		res.RandomID = gokad.GenerateRandomID().String()
+		res.RandomID = random.ID().String()
 		res.EchoRandomID = gokad.ID(rid).String()
 		res.SenderID = myID.String()
+		if ident != nil {
+			signable, err := res.Signable()
+			if err != nil {
+				return
+			}
+			res.Proof = ident.Prove(signable)
+		}
 
 		b, err := res.Bytes()
 		if err != nil {
 			return
 		}
 
-		conn.Write(b, req.Address())
+		reply(conn, b, req, logger)
+	}
+}
+
+func verifyPingRequest(ping *messages.PingRequest, difficulty int) error {
+	id, err := gokad.From(ping.SenderID)
+	if err != nil {
+		return err
+	}
+	signable, err := ping.Signable()
+	if err != nil {
+		return err
+	}
+
+	return ping.Proof.Verify(id, signable, difficulty)
+}
+
+func verifyPingResponse(pr *messages.PingResponse, difficulty int) error {
+	id, err := gokad.From(pr.SenderID)
+	if err != nil {
+		return err
+	}
+	signable, err := pr.Signable()
+	if err != nil {
+		return err
 	}
+
+	return pr.Proof.Verify(id, signable, difficulty)
 }
 
-func onStoreRequest(myID gokad.ID, proxy *dhtProxy) kadmux.RpcHandlerFunc {
+// onStoreRequest stores the value for at most maxTTL (tExpire)
+func onStoreRequest(myID gokad.ID, values storage.ValueStore, guard *sync.Mutex, maxTTL time.Duration, events *eventBus, clock kadclock.Clock, random kadrand.RandomSource, logger kadlog.Logger) kadmux.RpcHandlerFunc {
 	return func(conn kadconn.KadWriter, req *request.Request) {
 		var storeReq messages.StoreRequest
 		messages.ToKademliaMessage(req.Body, &storeReq)
@@ -97,13 +157,30 @@ func onStoreRequest(myID gokad.ID, proxy *dhtProxy) kadmux.RpcHandlerFunc {
 		key := storeReq.Payload.Key
 		ip := storeReq.Payload.Value.Host
 		port := storeReq.Payload.Value.Port
+		ttl := storeReq.Payload.TTL
+		if ttl <= 0 || ttl > maxTTL {
+			ttl = maxTTL
+		}
 
-		proxy.store(key, ip, port)
+		guard.Lock()
+		now := clock.Now()
+		err := values.Put(storage.Record{
+			Key:     key,
+			Value:   gokad.Value{Host: ip, Port: port},
+			Expires: now.Add(ttl),
+			Stored:  now,
+		})
+		guard.Unlock()
+		if err != nil {
+			logger.Log(kadlog.Error, "could not store value", kadlog.F("key", key), kadlog.F("sender", storeReq.SenderID), kadlog.F("error", err))
+			return
+		}
+		events.publish(Event{Type: ValueStored, Contact: req.Contact, Key: key})
 
 		res := messages.StoreResponse{
 			SenderID:     myID.String(),
 			EchoRandomID: storeReq.RandomID,
-			RandomID:     gokad.GenerateRandomID().String(),
+			RandomID:     random.ID().String(),
 		}
 
 		b, err := res.Bytes()
@@ -111,44 +188,316 @@ func onStoreRequest(myID gokad.ID, proxy *dhtProxy) kadmux.RpcHandlerFunc {
 			return
 		}
 
-		conn.Write(b, req.Address())
+		reply(conn, b, req, logger)
 
 	}
 }
 
-func onFindValue(proxy *dhtProxy) kadmux.RpcHandlerFunc {
+// onFindValue replies with up to k providers of the key. If the key is not stored it replies with the k closest contacts
+func onFindValue(proxy *dhtProxy, values storage.ValueStore, k int, clock kadclock.Clock, random kadrand.RandomSource, logger kadlog.Logger) kadmux.RpcHandlerFunc {
 	return func(conn kadconn.KadWriter, req *request.Request) {
 		randomId, _ := req.Body.RandomID()
 		payload, _ := req.Body.Payload()
 
-		contacts, val := proxy.findValue(gokad.ID(payload))
+		key := gokad.ID(payload)
+		records, err := values.Get(key)
+		if err != nil {
+			logger.Log(kadlog.Error, "could not read value", kadlog.F("key", key), kadlog.F("error", err))
+			return
+		}
+		providers := liveProviders(values, records, k, clock.Now(), random)
 
 		var fvr *messages.FindValueResponse
-		if len(contacts) > 0 {
+		if len(providers) == 0 {
 			fvr = messages.FindValueResponseNOK()
-			fvr.Payload.Contacts = contacts
+			fvr.Payload.Contacts = proxy.findNode(key)
 		} else {
 			fvr = messages.FindValueResponseOK()
-			fvr.Payload.Contacts = []gokad.Contact{
-				{
-					ID: gokad.GenerateRandomID(), // just generate a random id here. We are not using it
-					IP:   val.Host,
-					Port: val.Port,
-				},
-			}
+			fvr.Payload.Contacts = providers
 			fvr.Payload.Key = gokad.ID(payload).String()
 		}
 
 		fvr.EchoRandomID = gokad.ID(randomId).String()
-		fvr.RandomID = gokad.GenerateRandomID().String()
+		fvr.RandomID = random.ID().String()
 		fvr.SenderID = proxy.dht.ID.String()
 
 		b, err := fvr.Bytes()
 		if err != nil {
-			log.Printf("Error onFindValue %s\n", err)
+			logger.Log(kadlog.Error, "could not encode response", kadlog.F("type", "FindValueResponse"), kadlog.F("error", err))
+			return
+		}
+
+		reply(conn, b, req, logger)
+	}
+}
+
+// onStoreDataRequest stores an opaque value of at most maxSize bytes for at most maxTTL (tExpire).
+// Signed mutable records are never replaced by unsigned data
+func onStoreDataRequest(myID gokad.ID, values storage.ValueStore, guard *sync.Mutex, maxTTL time.Duration, maxSize int, events *eventBus, clock kadclock.Clock, random kadrand.RandomSource, logger kadlog.Logger) kadmux.RpcHandlerFunc {
+	return func(conn kadconn.KadWriter, req *request.Request) {
+		var storeReq messages.StoreDataRequest
+		messages.ToKademliaMessage(req.Body, &storeReq)
+
+		if storeReq.SenderID == "" {
+			return
+		}
+
+		key := storeReq.Payload.Key
+		if len(storeReq.Payload.Data) > maxSize {
+			logger.Log(kadlog.Warn, "value too large", kadlog.F("key", key), kadlog.F("sender", storeReq.SenderID), kadlog.F("size", len(storeReq.Payload.Data)))
+			return
+		}
+
+		ttl := storeReq.Payload.TTL
+		if ttl <= 0 || ttl > maxTTL {
+			ttl = maxTTL
+		}
+
+		guard.Lock()
+		held, found, err := liveData(values, key, clock.Now())
+		if err == nil && found && held.Mutable != nil {
+			guard.Unlock()
+			logger.Log(kadlog.Info, "refused to replace a mutable record", kadlog.F("key", key), kadlog.F("sender", storeReq.SenderID))
+			return
+		}
+
+		now := clock.Now()
+		if err == nil {
+			err = values.Put(storage.Record{
+				Key:     key,
+				Data:    storeReq.Payload.Data,
+				Expires: now.Add(ttl),
+				Stored:  now,
+			})
+		}
+		guard.Unlock()
+		if err != nil {
+			logger.Log(kadlog.Error, "could not store value", kadlog.F("key", key), kadlog.F("sender", storeReq.SenderID), kadlog.F("error", err))
+			return
+		}
+		events.publish(Event{Type: ValueStored, Contact: req.Contact, Key: key})
+
+		acknowledgeStore(conn, req, myID, storeReq.RandomID, random, logger)
+	}
+}
+
+// onStoreMutableRequest stores a signed mutable record for at most maxTTL (tExpire).
+// Records with an invalid signature, and records that do not supersede the one already held are rejected
+func onStoreMutableRequest(myID gokad.ID, values storage.ValueStore, guard *sync.Mutex, maxTTL time.Duration, maxSize int, events *eventBus, clock kadclock.Clock, random kadrand.RandomSource, logger kadlog.Logger) kadmux.RpcHandlerFunc {
+	return func(conn kadconn.KadWriter, req *request.Request) {
+		var storeReq messages.StoreMutableRequest
+		messages.ToKademliaMessage(req.Body, &storeReq)
+
+		if storeReq.SenderID == "" {
+			return
+		}
+
+		key := storeReq.Payload.Key
+		record := storeReq.Payload.Record
+		if err := record.Verify(); err != nil || !bytes.Equal(record.Key(), key) {
+			logger.Log(kadlog.Warn, "rejected mutable record with an invalid signature", kadlog.F("key", key), kadlog.F("sender", storeReq.SenderID))
+			return
+		}
+		if len(record.Data) > maxSize {
+			logger.Log(kadlog.Warn, "value too large", kadlog.F("key", key), kadlog.F("sender", storeReq.SenderID), kadlog.F("size", len(record.Data)))
+			return
+		}
+
+		ttl := storeReq.Payload.TTL
+		if ttl <= 0 || ttl > maxTTL {
+			ttl = maxTTL
+		}
+
+		guard.Lock()
+		held, found, err := liveData(values, key, clock.Now())
+		if err == nil && found && held.Mutable != nil && !record.Supersedes(*held.Mutable) {
+			guard.Unlock()
+			logger.Log(kadlog.Info, "rejected stale mutable record", kadlog.F("key", key), kadlog.F("sender", storeReq.SenderID), kadlog.F("seq", record.Seq), kadlog.F("held_seq", held.Mutable.Seq))
+			return
+		}
+
+		now := clock.Now()
+		if err == nil {
+			err = values.Put(storage.Record{
+				Key:     key,
+				Data:    record.Data,
+				Mutable: &record,
+				Expires: now.Add(ttl),
+				Stored:  now,
+			})
+		}
+		guard.Unlock()
+		if err != nil {
+			logger.Log(kadlog.Error, "could not store value", kadlog.F("key", key), kadlog.F("sender", storeReq.SenderID), kadlog.F("error", err))
+			return
+		}
+		events.publish(Event{Type: ValueStored, Contact: req.Contact, Key: key})
+
+		acknowledgeStore(conn, req, myID, storeReq.RandomID, random, logger)
+	}
+}
+
+// acknowledgeStore replies to a store request with the RandomID randomID
+func acknowledgeStore(conn kadconn.KadWriter, req *request.Request, myID gokad.ID, randomID string, random kadrand.RandomSource, logger kadlog.Logger) {
+	res := messages.StoreResponse{
+		SenderID:     myID.String(),
+		EchoRandomID: randomID,
+		RandomID:     random.ID().String(),
+	}
+
+	b, err := res.Bytes()
+	if err != nil {
+		return
+	}
+
+	reply(conn, b, req, logger)
+}
+
+// onFindData replies with the opaque value stored for the key. If there is none it replies with the k closest contacts
+func onFindData(proxy *dhtProxy, values storage.ValueStore, guard *sync.Mutex, clock kadclock.Clock, random kadrand.RandomSource, logger kadlog.Logger) kadmux.RpcHandlerFunc {
+	return func(conn kadconn.KadWriter, req *request.Request) {
+		randomId, _ := req.Body.RandomID()
+		payload, _ := req.Body.Payload()
+
+		key := gokad.ID(payload)
+		guard.Lock()
+		record, found, err := liveData(values, key, clock.Now())
+		guard.Unlock()
+		if err != nil {
+			logger.Log(kadlog.Error, "could not read value", kadlog.F("key", key), kadlog.F("error", err))
+			return
+		}
+
+		// mutable records are only sent with their signature
+		var fdr *messages.FindDataResponse
+		if !found || record.Mutable != nil {
+			fdr = messages.FindDataResponseNOK()
+			fdr.Payload.Contacts = proxy.findNode(key)
+		} else {
+			fdr = messages.FindDataResponseOK()
+			fdr.Payload.Key = key.String()
+			fdr.Payload.Data = record.Data
+		}
+
+		fdr.EchoRandomID = gokad.ID(randomId).String()
+		fdr.RandomID = random.ID().String()
+		fdr.SenderID = proxy.dht.ID.String()
+
+		b, err := fdr.Bytes()
+		if err != nil {
+			logger.Log(kadlog.Error, "could not encode response", kadlog.F("type", "FindDataResponse"), kadlog.F("error", err))
 			return
 		}
 
-		conn.Write(b, req.Address())
+		reply(conn, b, req, logger)
+	}
+}
+
+// onFindMutable replies with the signed mutable record stored for the key. If there is none it replies with the k closest contacts
+func onFindMutable(proxy *dhtProxy, values storage.ValueStore, guard *sync.Mutex, clock kadclock.Clock, random kadrand.RandomSource, logger kadlog.Logger) kadmux.RpcHandlerFunc {
+	return func(conn kadconn.KadWriter, req *request.Request) {
+		randomId, _ := req.Body.RandomID()
+		payload, _ := req.Body.Payload()
+
+		key := gokad.ID(payload)
+		guard.Lock()
+		record, found, err := liveData(values, key, clock.Now())
+		guard.Unlock()
+		if err != nil {
+			logger.Log(kadlog.Error, "could not read value", kadlog.F("key", key), kadlog.F("error", err))
+			return
+		}
+
+		var fmr *messages.FindMutableResponse
+		if !found || record.Mutable == nil {
+			fmr = messages.FindMutableResponseNOK()
+			fmr.Payload.Contacts = proxy.findNode(key)
+		} else {
+			fmr = messages.FindMutableResponseOK()
+			fmr.Payload.Key = key.String()
+			fmr.Payload.Record = *record.Mutable
+		}
+
+		fmr.EchoRandomID = gokad.ID(randomId).String()
+		fmr.RandomID = random.ID().String()
+		fmr.SenderID = proxy.dht.ID.String()
+
+		b, err := fmr.Bytes()
+		if err != nil {
+			logger.Log(kadlog.Error, "could not encode response", kadlog.F("type", "FindMutableResponse"), kadlog.F("error", err))
+			return
+		}
+
+		reply(conn, b, req, logger)
+	}
+}
+
+// liveData returns the data record stored for key. It is evicted if it expired at now
+func liveData(values storage.ValueStore, key gokad.ID, now time.Time) (storage.Record, bool, error) {
+	records, err := values.Get(key)
+	if err != nil {
+		return storage.Record{}, false, err
+	}
+
+	for _, r := range records {
+		if !r.IsData() {
+			continue
+		}
+		if r.Expired(now) {
+			removed, err := values.RemoveIfExpired(key, r.Provider(), now)
+			if err != nil {
+				return storage.Record{}, false, err
+			}
+			// the record was replaced since it was read
+			if !removed {
+				return liveData(values, key, now)
+			}
+			return storage.Record{}, false, nil
+		}
+
+		return r, true, nil
+	}
+
+	return storage.Record{}, false, nil
+}
+
+// liveProviders evicts the provider records expired at now and returns up to k of the others as contacts.
+// The providers that were stored most recently come first
+func liveProviders(values storage.ValueStore, records []storage.Record, k int, now time.Time, random kadrand.RandomSource) []gokad.Contact {
+	live := make([]storage.Record, 0, len(records))
+	for _, r := range records {
+		if r.IsData() {
+			continue
+		}
+		if r.Expired(now) {
+			values.RemoveIfExpired(r.Key, r.Provider(), now)
+			continue
+		}
+		live = append(live, r)
+	}
+
+	sort.Slice(live, func(i, j int) bool {
+		return live[i].Stored.After(live[j].Stored)
+	})
+	if len(live) > k {
+		live = live[:k]
+	}
+
+	out := make([]gokad.Contact, len(live))
+	for i, r := range live {
+		out[i] = gokad.Contact{
+			ID:   random.ID(), // just generate a random id here. We are not using it
+			IP:   r.Value.Host,
+			Port: r.Value.Port,
+		}
+	}
+
+	return out
+}
+
+// reply writes the response b to the sender of req
+func reply(conn kadconn.KadWriter, b []byte, req *request.Request, logger kadlog.Logger) {
+	if _, err := conn.Write(b, req.Address()); err != nil {
+		logger.Log(kadlog.Warn, "could not write response", kadlog.F("remote", req.Address()), kadlog.F("error", err))
 	}
 }
@@ -0,0 +1,149 @@
+package identity
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha1"
+	"errors"
+	"github.com/alabianca/gokad"
+	"math/bits"
+)
+
+const (
+	ErrInvalidProof     = "invalid identity proof"
+	ErrPuzzleNotSolved  = "id does not solve the puzzle"
+	ErrDifficultyTooBig = "difficulty is too big"
+	// ProofSize is the size of an encoded Proof
+	ProofSize = ed25519.PublicKeySize + ed25519.SignatureSize
+)
+
+// Identities follow S/Kademlia. The id of a node is the SHA-1 of its public key, so it cannot be picked freely.
+// An id is only accepted if the SHA-1 of the id has difficulty leading zero bits. Finding such an id takes
+// 2^difficulty key generations on average, which makes it expensive to place many nodes next to a key.
+//
+// Encoded proof
+// <- 32 Bytes  <- 64 Bytes
+//  PublicKey    Signature
+
+// Identity is the key a node id is derived from
+type Identity struct {
+	key ed25519.PrivateKey
+}
+
+// Proof shows that the sender of a message holds the key of its id
+type Proof struct {
+	PublicKey ed25519.PublicKey
+	Signature []byte
+}
+
+// New returns the identity of key
+func New(key ed25519.PrivateKey) Identity {
+	return Identity{key: key}
+}
+
+// Generate creates keys until the id of one solves the puzzle of difficulty
+func Generate(difficulty int) (Identity, error) {
+	if difficulty > sha1.Size*8 {
+		return Identity{}, errors.New(ErrDifficultyTooBig)
+	}
+
+	for {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return Identity{}, err
+		}
+
+		if Solves(IDOf(pub), difficulty) {
+			return New(priv), nil
+		}
+	}
+}
+
+// IDOf returns the id derived from pub
+func IDOf(pub ed25519.PublicKey) gokad.ID {
+	h := sha1.Sum(pub)
+	return gokad.ID(h[:])
+}
+
+// Solves reports if the SHA-1 of id has at least difficulty leading zero bits
+func Solves(id gokad.ID, difficulty int) bool {
+	h := sha1.Sum(id)
+	var zeros int
+	for _, b := range h {
+		zeros += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+
+	return zeros >= difficulty
+}
+
+func (i Identity) ID() gokad.ID {
+	return IDOf(i.PublicKey())
+}
+
+func (i Identity) PublicKey() ed25519.PublicKey {
+	return i.key.Public().(ed25519.PublicKey)
+}
+
+func (i Identity) PrivateKey() ed25519.PrivateKey {
+	return i.key
+}
+
+// Prove signs msg. The proof is only valid for msg
+func (i Identity) Prove(msg []byte) Proof {
+	return Proof{
+		PublicKey: i.PublicKey(),
+		Signature: ed25519.Sign(i.key, msg),
+	}
+}
+
+// IsZero reports if p holds no proof
+func (p Proof) IsZero() bool {
+	return len(p.PublicKey) == 0 && len(p.Signature) == 0
+}
+
+// Verify checks that p was created for msg by the holder of the key of id and that id solves the puzzle of difficulty
+func (p Proof) Verify(id gokad.ID, msg []byte, difficulty int) error {
+	if len(p.PublicKey) != ed25519.PublicKeySize || len(p.Signature) != ed25519.SignatureSize {
+		return errors.New(ErrInvalidProof)
+	}
+	if !bytes.Equal(IDOf(p.PublicKey), id) {
+		return errors.New(ErrInvalidProof)
+	}
+	if !Solves(id, difficulty) {
+		return errors.New(ErrPuzzleNotSolved)
+	}
+	if !ed25519.Verify(p.PublicKey, msg, p.Signature) {
+		return errors.New(ErrInvalidProof)
+	}
+
+	return nil
+}
+
+// Encode returns p in its encoded form. A zero proof is encoded as nothing
+func (p Proof) Encode() []byte {
+	if p.IsZero() {
+		return nil
+	}
+
+	out := make([]byte, 0, ProofSize)
+	out = append(out, p.PublicKey...)
+	out = append(out, p.Signature...)
+
+	return out
+}
+
+// Decode reads a proof from b
+func Decode(b []byte) (Proof, error) {
+	if len(b) != ProofSize {
+		return Proof{}, errors.New(ErrInvalidProof)
+	}
+
+	return Proof{
+		PublicKey: append(ed25519.PublicKey(nil), b[:ed25519.PublicKeySize]...),
+		Signature: append([]byte(nil), b[ed25519.PublicKeySize:]...),
+	}, nil
+}
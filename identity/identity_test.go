@@ -0,0 +1,58 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	id, err := Generate(8)
+	if err != nil {
+		t.Fatalf("Expected err to be nil, but got %s\n", err)
+	}
+
+	if !Solves(id.ID(), 8) {
+		t.Fatalf("Expected id %s to solve a puzzle of difficulty 8\n", id.ID())
+	}
+
+	if _, err := Generate(161); err == nil || err.Error() != ErrDifficultyTooBig {
+		t.Fatalf("Expected %s, but got %v\n", ErrDifficultyTooBig, err)
+	}
+}
+
+func TestProof_Verify(t *testing.T) {
+	id, _ := Generate(4)
+	msg := []byte("ping")
+	proof := id.Prove(msg)
+
+	if err := proof.Verify(id.ID(), msg, 4); err != nil {
+		t.Fatalf("Expected proof to be valid, but got %s\n", err)
+	}
+
+	decoded, err := Decode(proof.Encode())
+	if err != nil || decoded.Verify(id.ID(), msg, 4) != nil {
+		t.Fatalf("Expected a decoded proof to be valid, but got %v\n", err)
+	}
+
+	if err := proof.Verify(id.ID(), []byte("pong"), 4); err == nil || err.Error() != ErrInvalidProof {
+		t.Fatalf("Expected %s for another message, but got %v\n", ErrInvalidProof, err)
+	}
+
+	// a key that is not the one of the id
+	_, other, _ := ed25519.GenerateKey(nil)
+	if err := New(other).Prove(msg).Verify(id.ID(), msg, 4); err == nil || err.Error() != ErrInvalidProof {
+		t.Fatalf("Expected %s for a foreign key, but got %v\n", ErrInvalidProof, err)
+	}
+
+	// an id that does not solve the puzzle
+	var weak Identity
+	for {
+		_, priv, _ := ed25519.GenerateKey(nil)
+		if weak = New(priv); !Solves(weak.ID(), 1) {
+			break
+		}
+	}
+	if err := weak.Prove(msg).Verify(weak.ID(), msg, 1); err == nil || err.Error() != ErrPuzzleNotSolved {
+		t.Fatalf("Expected %s, but got %v\n", ErrPuzzleNotSolved, err)
+	}
+}
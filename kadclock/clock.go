@@ -0,0 +1,343 @@
+package kadclock
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time of a node. Real is the wall clock. A Manual clock only moves when it is
+// advanced, so tests and simulations control every timeout. Implementations must be safe for concurrent use
+type Clock interface {
+	Now() time.Time
+	// NewTimer returns a Timer that fires once d passed on the clock
+	NewTimer(d time.Duration) Timer
+	// NewTicker returns a Ticker that fires every d. Ticks are dropped for slow receivers
+	NewTicker(d time.Duration) Ticker
+	// AfterFunc calls f once d passed on the clock
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+type Timer interface {
+	C() <-chan time.Time
+	// Stop prevents the timer from firing. It returns false if the timer already fired or was stopped
+	Stop() bool
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real returns the wall clock
+func Real() Clock {
+	return realClock{}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return realTimer{time.AfterFunc(d, f)}
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t realTimer) Stop() bool {
+	return t.t.Stop()
+}
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t realTicker) Stop() {
+	t.t.Stop()
+}
+
+// Manual is a Clock that only moves when Advance or Set is called. Timers and tickers that are due fire
+// in the order of their due time. Functions of AfterFunc are called by Advance and Set, so they must not block
+type Manual struct {
+	mtx     sync.Mutex
+	now     time.Time
+	waiters []*waiter
+}
+
+// waiter is a pending timer, ticker or AfterFunc of a Manual clock
+type waiter struct {
+	clock  *Manual
+	at     time.Time
+	period time.Duration
+	c      chan time.Time
+	f      func()
+}
+
+func NewManual(now time.Time) *Manual {
+	return &Manual{now: now}
+}
+
+func (m *Manual) Now() time.Time {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.now
+}
+
+func (m *Manual) NewTimer(d time.Duration) Timer {
+	return m.add(&waiter{clock: m, c: make(chan time.Time, 1)}, d)
+}
+
+func (m *Manual) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+
+	return manualTicker{m.add(&waiter{clock: m, c: make(chan time.Time, 1), period: d}, d)}
+}
+
+func (m *Manual) AfterFunc(d time.Duration, f func()) Timer {
+	return m.add(&waiter{clock: m, f: f}, d)
+}
+
+// Advance moves the clock forward by d and fires everything that is due
+func (m *Manual) Advance(d time.Duration) {
+	m.Set(m.Now().Add(d))
+}
+
+// Set moves the clock to t and fires everything that is due. The clock never moves back
+func (m *Manual) Set(t time.Time) {
+	for {
+		m.mtx.Lock()
+		if len(m.waiters) == 0 || m.waiters[0].at.After(t) {
+			if t.After(m.now) {
+				m.now = t
+			}
+			m.mtx.Unlock()
+			return
+		}
+
+		m.fireFirst()
+	}
+}
+
+// Step moves the clock to the next timer, ticker or AfterFunc and fires only that one.
+// Of several due at the same time, each Step fires one. It returns false if nothing is pending
+func (m *Manual) Step() bool {
+	m.mtx.Lock()
+	if len(m.waiters) == 0 {
+		m.mtx.Unlock()
+		return false
+	}
+
+	m.fireFirst()
+	return true
+}
+
+// fireFirst fires the first waiter. m.mtx must be held and is unlocked before the waiter fires
+func (m *Manual) fireFirst() {
+	w := m.waiters[0]
+	m.waiters = m.waiters[1:]
+	if w.at.After(m.now) {
+		m.now = w.at
+	}
+	if w.period > 0 {
+		w.at = w.at.Add(w.period)
+		m.insert(w)
+	}
+	now := m.now
+	m.mtx.Unlock()
+
+	w.fire(now)
+}
+
+// Next returns the time the next timer, ticker or AfterFunc is due. It returns false if nothing is pending
+func (m *Manual) Next() (time.Time, bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if len(m.waiters) == 0 {
+		return time.Time{}, false
+	}
+
+	return m.waiters[0].at, true
+}
+
+// Pending returns the number of timers, tickers and AfterFuncs that did not fire yet.
+// Tests use it to wait until the code under test waits for the clock
+func (m *Manual) Pending() int {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return len(m.waiters)
+}
+
+func (m *Manual) add(w *waiter, d time.Duration) *waiter {
+	m.mtx.Lock()
+	w.at = m.now.Add(d)
+	if d > 0 {
+		m.insert(w)
+		m.mtx.Unlock()
+		return w
+	}
+
+	now := m.now
+	m.mtx.Unlock()
+	w.fire(now)
+	return w
+}
+
+// insert keeps m.waiters sorted by due time. Waiters due at the same time keep the order they were added in.
+// m.mtx must be held
+func (m *Manual) insert(w *waiter) {
+	i := sort.Search(len(m.waiters), func(i int) bool {
+		return m.waiters[i].at.After(w.at)
+	})
+	m.waiters = append(m.waiters, nil)
+	copy(m.waiters[i+1:], m.waiters[i:])
+	m.waiters[i] = w
+}
+
+func (m *Manual) remove(w *waiter) bool {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	for i, other := range m.waiters {
+		if other == w {
+			m.waiters = append(m.waiters[:i], m.waiters[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+func (w *waiter) fire(now time.Time) {
+	if w.f != nil {
+		w.f()
+		return
+	}
+
+	select {
+	case w.c <- now:
+	default:
+	}
+}
+
+func (w *waiter) C() <-chan time.Time {
+	return w.c
+}
+
+func (w *waiter) Stop() bool {
+	return w.clock.remove(w)
+}
+
+type manualTicker struct {
+	w *waiter
+}
+
+func (t manualTicker) C() <-chan time.Time {
+	return t.w.c
+}
+
+func (t manualTicker) Stop() {
+	t.w.clock.remove(t.w)
+}
+
+// WithDeadline is like context.WithDeadline but the deadline is reached on clock
+func WithDeadline(parent context.Context, clock Clock, deadline time.Time) (context.Context, context.CancelFunc) {
+	if _, ok := clock.(realClock); ok {
+		return context.WithDeadline(parent, deadline)
+	}
+	if d, ok := parent.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	ctx := &deadlineCtx{parent: parent, deadline: deadline, done: make(chan struct{})}
+	timer := clock.AfterFunc(deadline.Sub(clock.Now()), func() {
+		ctx.cancel(context.DeadlineExceeded)
+	})
+	ctx.mtx.Lock()
+	ctx.timer = timer
+	ctx.mtx.Unlock()
+	if parent.Done() != nil {
+		go func() {
+			select {
+			case <-parent.Done():
+				ctx.cancel(parent.Err())
+			case <-ctx.done:
+			}
+		}()
+	}
+
+	return ctx, func() {
+		ctx.cancel(context.Canceled)
+	}
+}
+
+// WithTimeout is like context.WithTimeout but the timeout passes on clock
+func WithTimeout(parent context.Context, clock Clock, timeout time.Duration) (context.Context, context.CancelFunc) {
+	return WithDeadline(parent, clock, clock.Now().Add(timeout))
+}
+
+// deadlineCtx is a context that is done once a timer of a Clock fired or its parent is done
+type deadlineCtx struct {
+	parent   context.Context
+	deadline time.Time
+	timer    Timer
+	done     chan struct{}
+	mtx      sync.Mutex
+	err      error
+}
+
+func (c *deadlineCtx) Deadline() (time.Time, bool) {
+	return c.deadline, true
+}
+
+func (c *deadlineCtx) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *deadlineCtx) Err() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.err
+}
+
+func (c *deadlineCtx) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+func (c *deadlineCtx) cancel(err error) {
+	c.mtx.Lock()
+	if c.err != nil {
+		c.mtx.Unlock()
+		return
+	}
+
+	c.err = err
+	close(c.done)
+	timer := c.timer
+	c.mtx.Unlock()
+
+	if timer != nil {
+		timer.Stop()
+	}
+}
@@ -0,0 +1,93 @@
+package kadclock
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestManual_Timers(t *testing.T) {
+	clock := NewManual(time.Unix(0, 0))
+	timer := clock.NewTimer(time.Second)
+	ticker := clock.NewTicker(time.Second * 2)
+	var called []time.Time
+	clock.AfterFunc(time.Second*3, func() {
+		called = append(called, clock.Now())
+	})
+
+	clock.Advance(time.Millisecond * 999)
+	select {
+	case <-timer.C():
+		t.Fatalf("Expected the timer not to fire before its time\n")
+	default:
+	}
+
+	clock.Advance(time.Millisecond)
+	if now := <-timer.C(); !now.Equal(time.Unix(1, 0)) {
+		t.Fatalf("Expected the timer to fire at %s, but got %s\n", time.Unix(1, 0), now)
+	}
+	if timer.Stop() {
+		t.Fatalf("Expected Stop to report a fired timer\n")
+	}
+
+	clock.Advance(time.Second * 3)
+	if now := <-ticker.C(); !now.Equal(time.Unix(2, 0)) {
+		t.Fatalf("Expected the ticker to fire at %s, but got %s\n", time.Unix(2, 0), now)
+	}
+	if len(called) != 1 || !called[0].Equal(time.Unix(3, 0)) {
+		t.Fatalf("Expected the func to be called once at %s, but got %v\n", time.Unix(3, 0), called)
+	}
+
+	// the ticker is due again at 6s
+	next, ok := clock.Next()
+	if !ok || !next.Equal(time.Unix(6, 0)) {
+		t.Fatalf("Expected the next tick at %s, but got %s\n", time.Unix(6, 0), next)
+	}
+	ticker.Stop()
+	if clock.Pending() != 0 {
+		t.Fatalf("Expected nothing to be pending after the ticker stopped, but got %d\n", clock.Pending())
+	}
+}
+
+func TestManual_Step(t *testing.T) {
+	clock := NewManual(time.Unix(0, 0))
+	var called []int
+	clock.AfterFunc(time.Second, func() { called = append(called, 1) })
+	clock.AfterFunc(time.Second, func() { called = append(called, 2) })
+
+	if !clock.Step() || len(called) != 1 || !clock.Now().Equal(time.Unix(1, 0)) {
+		t.Fatalf("Expected one func to be called at %s, but got %v at %s\n", time.Unix(1, 0), called, clock.Now())
+	}
+	if !clock.Step() || len(called) != 2 || called[1] != 2 {
+		t.Fatalf("Expected the second func to be called by the next Step, but got %v\n", called)
+	}
+	if clock.Step() {
+		t.Fatalf("Expected Step to report that nothing is pending\n")
+	}
+}
+
+func TestWithDeadline(t *testing.T) {
+	clock := NewManual(time.Unix(0, 0))
+	ctx, cancel := WithTimeout(context.Background(), clock, time.Second)
+	defer cancel()
+
+	if d, _ := ctx.Deadline(); !d.Equal(time.Unix(1, 0)) {
+		t.Fatalf("Expected the deadline %s, but got %s\n", time.Unix(1, 0), d)
+	}
+
+	clock.Advance(time.Second)
+	<-ctx.Done()
+	if ctx.Err() != context.DeadlineExceeded {
+		t.Fatalf("Expected %s, but got %v\n", context.DeadlineExceeded, ctx.Err())
+	}
+
+	// a parent that is done first ends the context with its error
+	parent, cancelParent := context.WithCancel(context.Background())
+	ctx, cancel = WithTimeout(parent, clock, time.Second)
+	defer cancel()
+	cancelParent()
+	<-ctx.Done()
+	if ctx.Err() != context.Canceled {
+		t.Fatalf("Expected %s, but got %v\n", context.Canceled, ctx.Err())
+	}
+}
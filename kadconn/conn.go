@@ -27,6 +27,7 @@ func New(pc net.PacketConn) KadConn {
 	c := conn{
 		mtx: sync.Mutex{},
 		pc:  pc,
+		buf: make([]byte, messages.MaxMessageSize),
 	}
 
 	return &c
@@ -35,6 +36,8 @@ func New(pc net.PacketConn) KadConn {
 type conn struct {
 	mtx sync.Mutex
 	pc  net.PacketConn
+	// buf is the read buffer of Next. Messages are only read by a single receiver
+	buf []byte
 }
 
 func (c *conn) Close() error {
@@ -48,13 +51,13 @@ func (c *conn) Write(p []byte, addr net.Addr) (int, error) {
 }
 
 func (c *conn) Next() (messages.Message, net.Addr, error) {
-	buf := make([]byte, messages.FindNodeResSize) // this is the largest possible message
-	n, r, err := c.read(buf)
+	n, r, err := c.read(c.buf)
 	if err != nil {
 		return nil, r, err
 	}
 
-	m, err := messages.Process(buf[:n])
+	// the buffer is reused, so the message gets a copy of the bytes read
+	m, err := messages.Process(append([]byte(nil), c.buf[:n]...))
 
 	return m, r, err
 }
@@ -0,0 +1,727 @@
+package kadconn
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"github.com/alabianca/gokad"
+	"github.com/alabianca/kadnet/kadclock"
+	"github.com/alabianca/kadnet/messages"
+	"net"
+	"sync"
+	"time"
+)
+
+const ErrNotSecure = "message is not sealed"
+const ErrHandshakeFailed = "handshake failed"
+const ErrUnknownSession = "unknown session"
+const ErrPeerNotAuthorized = "peer not authorized"
+const ErrMalformedPacket = "malformed packet"
+const ErrReplayed = "replayed message"
+const ErrSenderMismatch = "sender id does not belong to the peer key"
+const ErrTooManyHandshakes = "too many handshakes"
+
+// Packets of a secure connection. The first byte never collides with a message type
+//
+// handshakeInit
+// <- 1 Byte <- 32 Bytes      <- 32 Bytes
+//  Type       Initiator Key   Ephemeral Key
+//
+// handshakeResp
+// <- 1 Byte <- 32 Bytes      <- 32 Bytes     <- 16 Bytes
+//  Type       Responder Key   Ephemeral Key   Confirmation
+//
+// sealed. The ciphertext of an empty message finishes the handshake.
+// The nonce is 4 zero bytes followed by a counter every message of a session increments
+// <- 1 Byte <- 8 Bytes   <- 12 Bytes  <- X Bytes
+//  Type       Session ID   Nonce        Ciphertext
+
+const (
+	handshakeInit = byte(0xF1)
+	handshakeResp = byte(0xF2)
+	sealed        = byte(0xF3)
+
+	keySize           = 32
+	sessionIDSize     = 8
+	nonceSize         = 12
+	confirmSize       = 16
+	handshakeInitSize = 1 + 2*keySize
+	handshakeRespSize = 1 + 2*keySize + confirmSize
+	sealedHeaderSize  = 1 + sessionIDSize + nonceSize
+
+	maxPending         = 64 // messages waiting for a handshake per peer
+	maxSessionsPerPeer = 4
+	replayWindowSize   = 64 // messages of a session that may arrive out of order
+
+	// restartInterval is how long a peer with an unknown session is not sent another handshakeInit.
+	// Spoofed packets cannot make this side send more than one init to the same address in that time
+	restartInterval = time.Second * 10
+	// initInterval is how long a source that sent a handshakeInit is not answered another one.
+	// A flood of spoofed inits from one address costs a single handshake per interval
+	initInterval = time.Second
+)
+
+// SecureConfig configures a connection created with NewSecure
+type SecureConfig struct {
+	// Key is the long-term X25519 key of the node. It must be set
+	Key *ecdh.PrivateKey
+	// Required drops every message that is not sealed. Otherwise plain messages are accepted
+	// and peers that sent them are answered in plain
+	Required bool
+	// HandshakeTimeout is how long messages wait for a handshake to complete. It defaults to 2 seconds.
+	// Messages still waiting are dropped if Required is set and sent in plain otherwise
+	HandshakeTimeout time.Duration
+	// Authorize decides if a peer with the long-term key key may establish a session. nil accepts every key
+	Authorize func(addr net.Addr, key *ecdh.PublicKey) bool
+	// AuthorizeSender decides if a peer with the long-term key key may send messages with the sender id id.
+	// key is nil for plain messages. Messages it refuses are dropped with ErrSenderMismatch.
+	// nil binds an id to the first key that sent a sealed message with it. Plain messages with a bound id are dropped
+	AuthorizeSender func(id gokad.ID, key *ecdh.PublicKey) bool
+	// Clock is the clock HandshakeTimeout passes on. It defaults to the wall clock
+	Clock kadclock.Clock
+	// MaxPeers is the number of peers state is kept for. The least recently seen peer is dropped to make room,
+	// peers without a confirmed session first. It also bounds the sender ids bound to keys. It defaults to 1024
+	MaxPeers int
+}
+
+// GenerateKey returns a new long-term X25519 key
+func GenerateKey() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+// NewSecure returns a KadConn that seals all messages with AES-256-GCM.
+// Keys are established per peer with a handshake of X25519 exchanges between the long-term and
+// ephemeral keys of both sides. Messages written to a peer without a session wait for the handshake to complete
+func NewSecure(pc net.PacketConn, config SecureConfig) KadConn {
+	if config.HandshakeTimeout <= 0 {
+		config.HandshakeTimeout = time.Second * 2
+	}
+	if config.Clock == nil {
+		config.Clock = kadclock.Real()
+	}
+	if config.MaxPeers <= 0 {
+		config.MaxPeers = 1024
+	}
+
+	return &secureConn{
+		pc:      pc,
+		config:  config,
+		peers:   make(map[string]*peer),
+		senders: make(map[string]*sender),
+		buf:     make([]byte, messages.MaxMessageSize+messages.SealOverhead),
+	}
+}
+
+type secureConn struct {
+	mtx    sync.Mutex
+	pc     net.PacketConn
+	config SecureConfig
+	peers  map[string]*peer
+	// senders binds sender ids to the key of the peer that used them first, if AuthorizeSender is nil
+	senders map[string]*sender
+	// buf is the read buffer of Next. Opened and plain messages are copied out of it
+	buf []byte
+}
+
+// sender is the key a sender id is bound to
+type sender struct {
+	key  *ecdh.PublicKey
+	seen time.Time
+}
+
+// peer is the state of the sessions with a remote address
+type peer struct {
+	// current seals outgoing messages
+	current *session
+	// sessions holds the sessions incoming messages are opened with, oldest first
+	sessions  []*session
+	handshake *handshake
+	// plain is set if the peer sent a plain message
+	plain bool
+	// seen is the last time the peer was written to or read from
+	seen time.Time
+	// restarted is the last time a handshake was started because the peer used an unknown session
+	restarted time.Time
+	// initiated is the last time the peer sent a handshakeInit that was answered
+	initiated time.Time
+}
+
+// session holds the keys established in a handshake. A responder only uses a session for
+// outgoing messages once it is confirmed by a sealed message of the initiator
+type session struct {
+	id        [sessionIDSize]byte
+	send      cipher.AEAD
+	recv      cipher.AEAD
+	confirmed bool
+	// remote is the long-term key of the peer
+	remote *ecdh.PublicKey
+	// sent is the counter of the last sealed message
+	sent   uint64
+	window replayWindow
+}
+
+// replayWindow holds the counters of the messages received on a session.
+// Counters older than the last replayWindowSize ones are rejected
+type replayWindow struct {
+	highest uint64
+	// seen has bit i set if the counter highest-i was received
+	seen uint64
+}
+
+// handshake is a handshake started by this side
+type handshake struct {
+	ephemeral *ecdh.PrivateKey
+	pending   [][]byte
+	timer     kadclock.Timer
+}
+
+func (c *secureConn) Close() error {
+	c.mtx.Lock()
+	for _, p := range c.peers {
+		if p.handshake != nil {
+			p.handshake.timer.Stop()
+		}
+	}
+	c.mtx.Unlock()
+
+	return c.pc.Close()
+}
+
+func (c *secureConn) Write(p []byte, addr net.Addr) (int, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	pr := c.peer(addr)
+	if pr.current != nil {
+		return len(p), c.seal(pr.current, p, addr)
+	}
+
+	if pr.plain && !c.config.Required {
+		return c.write(p, addr)
+	}
+
+	if pr.handshake == nil {
+		if err := c.startHandshake(pr, addr); err != nil {
+			return 0, err
+		}
+	}
+
+	if len(pr.handshake.pending) < maxPending {
+		pr.handshake.pending = append(pr.handshake.pending, append([]byte(nil), p...))
+	}
+
+	return len(p), nil
+}
+
+func (c *secureConn) Next() (messages.Message, net.Addr, error) {
+	buf := c.buf
+	for {
+		n, addr, err := c.pc.ReadFrom(buf)
+		if err != nil {
+			return nil, addr, err
+		}
+		if n == 0 {
+			return nil, addr, errors.New(ErrMalformedPacket)
+		}
+
+		var plain []byte
+		var key *ecdh.PublicKey
+		switch buf[0] {
+		case handshakeInit:
+			err = c.onHandshakeInit(buf[:n], addr)
+		case handshakeResp:
+			err = c.onHandshakeResp(buf[:n], addr)
+		case sealed:
+			plain, key, err = c.open(buf[:n], addr)
+		default:
+			plain, err = c.onPlain(buf[:n], addr)
+		}
+		if err != nil {
+			return nil, addr, err
+		}
+
+		// handshake packets and the empty message that finishes a handshake are not passed on
+		if len(plain) == 0 {
+			continue
+		}
+
+		m, err := messages.Process(plain)
+		if err != nil {
+			return m, addr, err
+		}
+		if !c.authorizeSender(m, key) {
+			return nil, addr, errors.New(ErrSenderMismatch)
+		}
+
+		return m, addr, nil
+	}
+}
+
+// authorizeSender reports whether the peer with the long-term key key may send m. key is nil for plain messages
+func (c *secureConn) authorizeSender(m messages.Message, key *ecdh.PublicKey) bool {
+	id, err := m.SenderID()
+	if err != nil {
+		return false
+	}
+	if c.config.AuthorizeSender != nil {
+		return c.config.AuthorizeSender(id, key)
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	s, ok := c.senders[string(id)]
+	if !ok {
+		if key == nil {
+			return true
+		}
+		if len(c.senders) >= c.config.MaxPeers {
+			c.evictOldestSender()
+		}
+		s = &sender{key: key}
+		c.senders[string(id)] = s
+	}
+
+	if key == nil || !key.Equal(s.key) {
+		return false
+	}
+	s.seen = c.config.Clock.Now()
+
+	return true
+}
+
+func (c *secureConn) onPlain(p []byte, addr net.Addr) ([]byte, error) {
+	if c.config.Required {
+		return nil, errors.New(ErrNotSecure)
+	}
+	if !messages.IsValid(messages.MessageType(p[0])) {
+		return nil, errors.New(ErrInvalidMessageType)
+	}
+
+	c.mtx.Lock()
+	c.peer(addr).plain = true
+	c.mtx.Unlock()
+
+	return append([]byte(nil), p...), nil
+}
+
+func (c *secureConn) onHandshakeInit(p []byte, addr net.Addr) error {
+	if len(p) != handshakeInitSize {
+		return errors.New(ErrMalformedPacket)
+	}
+
+	// the handshake is only worked on if the source did not just send another one
+	c.mtx.Lock()
+	pr, ok := c.peers[addr.String()]
+	limited := ok && !pr.initiated.IsZero() && c.config.Clock.Now().Sub(pr.initiated) < initInterval
+	c.mtx.Unlock()
+	if limited {
+		return errors.New(ErrTooManyHandshakes)
+	}
+
+	static, ephemeral, err := c.remoteKeys(p[1:], addr)
+	if err != nil {
+		return err
+	}
+
+	mine, err := GenerateKey()
+	if err != nil {
+		return err
+	}
+
+	es, err := c.config.Key.ECDH(ephemeral)
+	if err != nil {
+		return errors.New(ErrHandshakeFailed)
+	}
+	se, err := mine.ECDH(static)
+	if err != nil {
+		return errors.New(ErrHandshakeFailed)
+	}
+	ee, err := mine.ECDH(ephemeral)
+	if err != nil {
+		return errors.New(ErrHandshakeFailed)
+	}
+
+	transcript := concat(p[1:], c.config.Key.PublicKey().Bytes(), mine.PublicKey().Bytes())
+	s, confirm, err := newSession(concat(es, se, ee), transcript, false)
+	if err != nil {
+		return err
+	}
+	s.remote = static
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	pr = c.peer(addr)
+	pr.initiated = c.config.Clock.Now()
+	pr.add(s)
+
+	resp := concat([]byte{handshakeResp}, c.config.Key.PublicKey().Bytes(), mine.PublicKey().Bytes(), confirm)
+	_, err = c.write(resp, addr)
+	return err
+}
+
+func (c *secureConn) onHandshakeResp(p []byte, addr net.Addr) error {
+	if len(p) != handshakeRespSize {
+		return errors.New(ErrMalformedPacket)
+	}
+
+	static, ephemeral, err := c.remoteKeys(p[1:], addr)
+	if err != nil {
+		return err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	pr := c.peer(addr)
+	hs := pr.handshake
+	if hs == nil {
+		return errors.New(ErrHandshakeFailed)
+	}
+
+	es, err := hs.ephemeral.ECDH(static)
+	if err != nil {
+		return errors.New(ErrHandshakeFailed)
+	}
+	se, err := c.config.Key.ECDH(ephemeral)
+	if err != nil {
+		return errors.New(ErrHandshakeFailed)
+	}
+	ee, err := hs.ephemeral.ECDH(ephemeral)
+	if err != nil {
+		return errors.New(ErrHandshakeFailed)
+	}
+
+	transcript := concat(c.config.Key.PublicKey().Bytes(), hs.ephemeral.PublicKey().Bytes(), p[1:1+2*keySize])
+	s, confirm, err := newSession(concat(es, se, ee), transcript, true)
+	if err != nil {
+		return err
+	}
+
+	// a response that does not confirm the keys keeps the handshake waiting for the real one
+	if subtle.ConstantTimeCompare(confirm, p[1+2*keySize:]) != 1 {
+		return errors.New(ErrHandshakeFailed)
+	}
+
+	hs.timer.Stop()
+	pr.handshake = nil
+	s.remote = static
+	s.confirmed = true
+	pr.add(s)
+	pr.current = s
+
+	// the empty message confirms the session to the responder
+	if err := c.seal(s, nil, addr); err != nil {
+		return err
+	}
+	for _, m := range hs.pending {
+		if err := c.seal(s, m, addr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// open returns the message of the sealed packet p and the long-term key of the peer that sealed it
+func (c *secureConn) open(p []byte, addr net.Addr) ([]byte, *ecdh.PublicKey, error) {
+	if len(p) < sealedHeaderSize {
+		return nil, nil, errors.New(ErrMalformedPacket)
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	// no state is kept for a source that does not know a session, unless a handshake is started with it
+	var s *session
+	pr, ok := c.peers[addr.String()]
+	if ok {
+		s = pr.session(p[1 : 1+sessionIDSize])
+	}
+	if s == nil {
+		c.restart(addr)
+		return nil, nil, errors.New(ErrUnknownSession)
+	}
+	pr = c.peer(addr)
+
+	nonce := p[1+sessionIDSize : sealedHeaderSize]
+	if binary.BigEndian.Uint32(nonce) != 0 {
+		return nil, nil, errors.New(ErrMalformedPacket)
+	}
+	seq := binary.BigEndian.Uint64(nonce[4:])
+	if !s.window.check(seq) {
+		return nil, nil, errors.New(ErrReplayed)
+	}
+
+	plain, err := s.recv.Open(nil, nonce, p[sealedHeaderSize:], p[:sealedHeaderSize])
+	if err != nil {
+		return nil, nil, errors.New(ErrHandshakeFailed)
+	}
+	s.window.accept(seq)
+
+	if !s.confirmed {
+		s.confirmed = true
+		pr.current = s
+	}
+	pr.plain = false
+
+	return plain, s.remote, nil
+}
+
+// restart starts a handshake with a peer that holds a session this side lost. A new handshake replaces it.
+// A peer is sent at most one handshakeInit for unknown sessions per restartInterval. c.mtx must be held
+func (c *secureConn) restart(addr net.Addr) {
+	pr := c.peer(addr)
+	now := c.config.Clock.Now()
+	if pr.handshake != nil || (!pr.restarted.IsZero() && now.Sub(pr.restarted) < restartInterval) {
+		return
+	}
+
+	pr.restarted = now
+	c.startHandshake(pr, addr)
+}
+
+// startHandshake sends a handshakeInit to addr. Messages that wait for it are handled once HandshakeTimeout passed
+func (c *secureConn) startHandshake(pr *peer, addr net.Addr) error {
+	ephemeral, err := GenerateKey()
+	if err != nil {
+		return err
+	}
+
+	hs := &handshake{ephemeral: ephemeral}
+	hs.timer = c.config.Clock.AfterFunc(c.config.HandshakeTimeout, func() {
+		c.expire(addr, hs)
+	})
+	pr.handshake = hs
+
+	init := concat([]byte{handshakeInit}, c.config.Key.PublicKey().Bytes(), ephemeral.PublicKey().Bytes())
+	_, err = c.write(init, addr)
+	return err
+}
+
+// expire ends hs if it did not complete. Waiting messages are sent in plain unless sealed messages are required
+func (c *secureConn) expire(addr net.Addr, hs *handshake) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	pr := c.peers[addr.String()]
+	if pr == nil || pr.handshake != hs {
+		return
+	}
+	pr.handshake = nil
+
+	if c.config.Required {
+		return
+	}
+	for _, m := range hs.pending {
+		c.write(m, addr)
+	}
+}
+
+// remoteKeys parses the long-term and ephemeral key of a handshake packet and checks if the peer is authorized
+func (c *secureConn) remoteKeys(p []byte, addr net.Addr) (*ecdh.PublicKey, *ecdh.PublicKey, error) {
+	static, err := ecdh.X25519().NewPublicKey(p[:keySize])
+	if err != nil {
+		return nil, nil, errors.New(ErrMalformedPacket)
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(p[keySize : 2*keySize])
+	if err != nil {
+		return nil, nil, errors.New(ErrMalformedPacket)
+	}
+
+	if c.config.Authorize != nil && !c.config.Authorize(addr, static) {
+		return nil, nil, errors.New(ErrPeerNotAuthorized)
+	}
+
+	return static, ephemeral, nil
+}
+
+// seal sends p to addr sealed with the next counter of s. c.mtx must be held
+func (c *secureConn) seal(s *session, p []byte, addr net.Addr) error {
+	_, err := c.write(s.seal(p), addr)
+	return err
+}
+
+func (c *secureConn) write(p []byte, addr net.Addr) (int, error) {
+	return c.pc.WriteTo(p, addr)
+}
+
+// peer returns the state of addr and marks it as seen. c.mtx must be held
+func (c *secureConn) peer(addr net.Addr) *peer {
+	pr, ok := c.peers[addr.String()]
+	if !ok {
+		if len(c.peers) >= c.config.MaxPeers {
+			c.evictOldest()
+		}
+		pr = &peer{}
+		c.peers[addr.String()] = pr
+	}
+	pr.seen = c.config.Clock.Now()
+
+	return pr
+}
+
+// evictOldest drops the state of the least recently seen peer. Peers without a confirmed session go first,
+// so handshakeInits from spoofed sources only push out each other. c.mtx must be held
+func (c *secureConn) evictOldest() {
+	var oldest string
+	for _, unconfirmed := range []bool{true, false} {
+		for key, pr := range c.peers {
+			if unconfirmed && pr.current != nil {
+				continue
+			}
+			if oldest == "" || pr.seen.Before(c.peers[oldest].seen) {
+				oldest = key
+			}
+		}
+		if oldest != "" {
+			break
+		}
+	}
+
+	if hs := c.peers[oldest].handshake; hs != nil {
+		hs.timer.Stop()
+	}
+	delete(c.peers, oldest)
+}
+
+// evictOldestSender unbinds the sender id that was used least recently. c.mtx must be held
+func (c *secureConn) evictOldestSender() {
+	var oldest string
+	for id, s := range c.senders {
+		if oldest == "" || s.seen.Before(c.senders[oldest].seen) {
+			oldest = id
+		}
+	}
+
+	delete(c.senders, oldest)
+}
+
+// add keeps s. If there are too many sessions the oldest one that is not current is dropped
+func (p *peer) add(s *session) {
+	if len(p.sessions) == maxSessionsPerPeer {
+		for i, old := range p.sessions {
+			if old != p.current {
+				p.sessions = append(p.sessions[:i], p.sessions[i+1:]...)
+				break
+			}
+		}
+	}
+
+	p.sessions = append(p.sessions, s)
+}
+
+// seal returns the sealed packet of p. The nonce is the next counter, so it is never reused with the same key
+func (s *session) seal(p []byte) []byte {
+	s.sent++
+	out := make([]byte, sealedHeaderSize, sealedHeaderSize+len(p)+s.send.Overhead())
+	out[0] = sealed
+	copy(out[1:], s.id[:])
+	binary.BigEndian.PutUint64(out[1+sessionIDSize+4:], s.sent)
+
+	return s.send.Seal(out, out[1+sessionIDSize:], p, out[:sealedHeaderSize])
+}
+
+// check reports whether a message with the counter seq was not received yet and is recent enough to tell
+func (w *replayWindow) check(seq uint64) bool {
+	if seq == 0 {
+		return false
+	}
+	if seq > w.highest {
+		return true
+	}
+
+	age := w.highest - seq
+	return age < replayWindowSize && w.seen&(1<<age) == 0
+}
+
+// accept records seq as received. Only counters of messages that opened are recorded
+func (w *replayWindow) accept(seq uint64) {
+	if seq <= w.highest {
+		w.seen |= 1 << (w.highest - seq)
+		return
+	}
+
+	if shift := seq - w.highest; shift < replayWindowSize {
+		w.seen <<= shift
+	} else {
+		w.seen = 0
+	}
+	w.seen |= 1
+	w.highest = seq
+}
+
+func (p *peer) session(id []byte) *session {
+	for _, s := range p.sessions {
+		if subtle.ConstantTimeCompare(s.id[:], id) == 1 {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// newSession derives the keys of a session from the concatenated X25519 results secret and the public keys
+// of both sides. It returns the confirmation the responder sends with its handshakeResp
+func newSession(secret, transcript []byte, initiator bool) (*session, []byte, error) {
+	// HKDF with SHA-256. No key is longer than a single block of output
+	prk := hmac.New(sha256.New, transcript)
+	prk.Write(secret)
+	key := prk.Sum(nil)
+	derive := func(info string, size int) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(info))
+		mac.Write([]byte{1})
+		return mac.Sum(nil)[:size]
+	}
+
+	i2r := derive("kadnet initiator to responder", keySize)
+	r2i := derive("kadnet responder to initiator", keySize)
+	id := derive("kadnet session id", sessionIDSize)
+	confirm := derive("kadnet confirm", confirmSize)
+
+	send, recv := i2r, r2i
+	if !initiator {
+		send, recv = r2i, i2r
+	}
+
+	s := session{}
+	copy(s.id[:], id)
+	var err error
+	if s.send, err = newAEAD(send); err != nil {
+		return nil, nil, err
+	}
+	if s.recv, err = newAEAD(recv); err != nil {
+		return nil, nil, err
+	}
+
+	return &s, confirm, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func concat(parts ...[]byte) []byte {
+	var size int
+	for _, p := range parts {
+		size += len(p)
+	}
+
+	out := make([]byte, 0, size)
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+
+	return out
+}
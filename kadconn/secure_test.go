@@ -0,0 +1,331 @@
+package kadconn
+
+import (
+	"errors"
+	"github.com/alabianca/gokad"
+	"github.com/alabianca/kadnet/kadclock"
+	"github.com/alabianca/kadnet/messages"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSecureConn_RoundTrip(t *testing.T) {
+	a := listenSecure(t, "127.0.0.1:0", false)
+	defer a.Close()
+	b := listenSecure(t, "127.0.0.1:0", false)
+	defer b.Close()
+	fromA, fromB := receive(a), receive(b)
+
+	ping := pingRequest(t)
+	if _, err := a.Write(ping, b.pc.LocalAddr()); err != nil {
+		t.Fatalf("Expected err to be nil, but got %s\n", err)
+	}
+
+	res := next(t, fromB)
+	if res.err != nil || string(res.msg) != string(ping) || res.from.String() != a.pc.LocalAddr().String() {
+		t.Fatalf("Expected %v from %s, but got %v\n", ping, a.pc.LocalAddr(), res)
+	}
+
+	// the responder answers on the session the initiator established
+	b.Write(ping, res.from)
+	if res := next(t, fromA); res.err != nil || string(res.msg) != string(ping) {
+		t.Fatalf("Expected %v, but got %v\n", ping, res)
+	}
+}
+
+func TestSecureConn_Required(t *testing.T) {
+	secure := listenSecure(t, "127.0.0.1:0", true)
+	defer secure.Close()
+
+	pc, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	plain := New(pc)
+	defer plain.Close()
+
+	plain.Write(pingRequest(t), secure.pc.LocalAddr())
+	if res := next(t, receive(secure)); res.err == nil || res.err.Error() != ErrNotSecure {
+		t.Fatalf("Expected %s, but got %v\n", ErrNotSecure, res)
+	}
+}
+
+func TestSecureConn_PeerRestart(t *testing.T) {
+	a := listenSecure(t, "127.0.0.1:0", false)
+	defer a.Close()
+	b := listenSecure(t, "127.0.0.1:0", false)
+	addr := b.pc.LocalAddr()
+	receive(a)
+
+	ping := pingRequest(t)
+	a.Write(ping, addr)
+	next(t, receive(b))
+	b.Close()
+
+	// b comes back with a new key and without the session a still holds
+	b = listenSecure(t, addr.String(), false)
+	defer b.Close()
+	fromB := receive(b)
+
+	a.Write(ping, addr)
+	if res := next(t, fromB); res.err == nil || res.err.Error() != ErrUnknownSession {
+		t.Fatalf("Expected %s, but got %v\n", ErrUnknownSession, res)
+	}
+
+	// the handshake b started replaces the session of a
+	time.Sleep(time.Millisecond * 100)
+	a.Write(ping, addr)
+	if res := next(t, fromB); res.err != nil || string(res.msg) != string(ping) {
+		t.Fatalf("Expected %v, but got %v\n", ping, res)
+	}
+}
+
+func TestSecureConn_Replay(t *testing.T) {
+	a := listenSecure(t, "127.0.0.1:0", false)
+	defer a.Close()
+	b := listenSecure(t, "127.0.0.1:0", false)
+	defer b.Close()
+	fromB := receive(b)
+	receive(a)
+
+	ping := pingRequest(t)
+	a.Write(ping, b.pc.LocalAddr())
+	next(t, fromB)
+
+	a.mtx.Lock()
+	packet := a.peers[b.pc.LocalAddr().String()].current.seal(ping)
+	a.mtx.Unlock()
+
+	// the same packet is only accepted once
+	a.pc.WriteTo(packet, b.pc.LocalAddr())
+	if res := next(t, fromB); res.err != nil || string(res.msg) != string(ping) {
+		t.Fatalf("Expected %v, but got %v\n", ping, res)
+	}
+	a.pc.WriteTo(packet, b.pc.LocalAddr())
+	if res := next(t, fromB); res.err == nil || res.err.Error() != ErrReplayed {
+		t.Fatalf("Expected %s, but got %v\n", ErrReplayed, res)
+	}
+}
+
+func TestReplayWindow(t *testing.T) {
+	var w replayWindow
+	for _, seq := range []uint64{1, 3, 2, 100} {
+		if !w.check(seq) {
+			t.Fatalf("Expected %d to be accepted\n", seq)
+		}
+		w.accept(seq)
+	}
+
+	// 0 is never sent, 2 and 100 were received and 36 is out of the window
+	for _, seq := range []uint64{0, 2, 100, 36} {
+		if w.check(seq) {
+			t.Fatalf("Expected %d to be rejected\n", seq)
+		}
+	}
+	if !w.check(37) || !w.check(99) {
+		t.Fatalf("Expected counters within the window to be accepted\n")
+	}
+}
+
+func TestSecureConn_MaxPeers(t *testing.T) {
+	pc, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	key, _ := GenerateKey()
+	secure := NewSecure(pc, SecureConfig{Key: key, MaxPeers: 2}).(*secureConn)
+	defer secure.Close()
+	fromSecure := receive(secure)
+
+	for i := 0; i < 3; i++ {
+		plain, _ := net.ListenPacket("udp", "127.0.0.1:0")
+		defer plain.Close()
+		plain.WriteTo(pingRequest(t), pc.LocalAddr())
+		next(t, fromSecure)
+	}
+
+	secure.mtx.Lock()
+	defer secure.mtx.Unlock()
+	if len(secure.peers) != 2 {
+		t.Fatalf("Expected state for 2 peers, but got %d\n", len(secure.peers))
+	}
+}
+
+func TestSecureConn_UnknownSession(t *testing.T) {
+	pc, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	key, _ := GenerateKey()
+	clock := kadclock.NewManual(time.Now())
+	secure := NewSecure(pc, SecureConfig{Key: key, Clock: clock}).(*secureConn)
+	defer secure.Close()
+	fromSecure := receive(secure)
+	spoofed, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	defer spoofed.Close()
+
+	// a packet with an unknown session asks for a handshake
+	packet := make([]byte, sealedHeaderSize+16)
+	packet[0] = sealed
+	send := func() int {
+		spoofed.WriteTo(packet, pc.LocalAddr())
+		next(t, fromSecure)
+		return handshakeInits(spoofed)
+	}
+
+	if n := send(); n != 1 {
+		t.Fatalf("Expected a handshakeInit, but got %d\n", n)
+	}
+
+	// the handshake timed out, but the address is not sent another init yet
+	clock.Advance(secure.config.HandshakeTimeout)
+	if n := send(); n != 0 {
+		t.Fatalf("Expected no handshakeInit, but got %d\n", n)
+	}
+
+	clock.Advance(restartInterval)
+	if n := send(); n != 1 {
+		t.Fatalf("Expected a handshakeInit, but got %d\n", n)
+	}
+}
+
+func TestSecureConn_SenderMismatch(t *testing.T) {
+	a := listenSecure(t, "127.0.0.1:0", false)
+	defer a.Close()
+	b := listenSecure(t, "127.0.0.1:0", false)
+	defer b.Close()
+	c := listenSecure(t, "127.0.0.1:0", false)
+	defer c.Close()
+	fromA := receive(a)
+	receive(b)
+	receive(c)
+
+	// the id of the first ping is bound to the key of b
+	ping := pingRequest(t)
+	b.Write(ping, a.pc.LocalAddr())
+	if res := next(t, fromA); res.err != nil {
+		t.Fatalf("Expected err to be nil, but got %s\n", res.err)
+	}
+
+	c.Write(ping, a.pc.LocalAddr())
+	if res := next(t, fromA); res.err == nil || res.err.Error() != ErrSenderMismatch {
+		t.Fatalf("Expected %s, but got %v\n", ErrSenderMismatch, res)
+	}
+
+	pc, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	plain := New(pc)
+	defer plain.Close()
+	plain.Write(ping, a.pc.LocalAddr())
+	if res := next(t, fromA); res.err == nil || res.err.Error() != ErrSenderMismatch {
+		t.Fatalf("Expected %s, but got %v\n", ErrSenderMismatch, res)
+	}
+}
+
+func TestSecureConn_HandshakeFlood(t *testing.T) {
+	pc, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	key, _ := GenerateKey()
+	secure := NewSecure(pc, SecureConfig{Key: key, MaxPeers: 2}).(*secureConn)
+	defer secure.Close()
+	fromSecure := receive(secure)
+	legit := listenSecure(t, "127.0.0.1:0", false)
+	defer legit.Close()
+	receive(legit)
+
+	legit.Write(pingRequest(t), pc.LocalAddr())
+	next(t, fromSecure)
+
+	// inits from one source are answered once per initInterval
+	spoofed, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	defer spoofed.Close()
+	static, _ := GenerateKey()
+	ephemeral, _ := GenerateKey()
+	init := concat([]byte{handshakeInit}, static.PublicKey().Bytes(), ephemeral.PublicKey().Bytes())
+	spoofed.WriteTo(init, pc.LocalAddr())
+	spoofed.WriteTo(init, pc.LocalAddr())
+	if res := next(t, fromSecure); res.err == nil || res.err.Error() != ErrTooManyHandshakes {
+		t.Fatalf("Expected %s, but got %v\n", ErrTooManyHandshakes, res)
+	}
+
+	// inits of other sources do not push out the confirmed session
+	buf := make([]byte, 1024)
+	for i := 0; i < 3; i++ {
+		other, _ := net.ListenPacket("udp", "127.0.0.1:0")
+		defer other.Close()
+		other.WriteTo(init, pc.LocalAddr())
+		other.SetReadDeadline(time.Now().Add(time.Second))
+		if n, _, _ := other.ReadFrom(buf); n == 0 || buf[0] != handshakeResp {
+			t.Fatalf("Expected a handshakeResp\n")
+		}
+	}
+
+	secure.mtx.Lock()
+	defer secure.mtx.Unlock()
+	if pr, ok := secure.peers[legit.pc.LocalAddr().String()]; !ok || pr.current == nil {
+		t.Fatalf("Expected the session with %s to survive the inits\n", legit.pc.LocalAddr())
+	}
+}
+
+type received struct {
+	msg  messages.Message
+	from net.Addr
+	err  error
+}
+
+// receive reads from c until it is closed
+func receive(c KadConn) chan received {
+	out := make(chan received, 10)
+	go func() {
+		for {
+			msg, from, err := c.Next()
+			if err != nil && errors.Is(err, net.ErrClosed) {
+				return
+			}
+			out <- received{msg, from, err}
+		}
+	}()
+
+	return out
+}
+
+func next(t *testing.T, in chan received) received {
+	select {
+	case res := <-in:
+		return res
+	case <-time.After(time.Second):
+		t.Fatalf("Expected to receive a message\n")
+	}
+
+	return received{}
+}
+
+// handshakeInits counts the handshakeInit packets pc receives until it is quiet for a while
+func handshakeInits(pc net.PacketConn) int {
+	var inits int
+	buf := make([]byte, 1024)
+	for {
+		pc.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			return inits
+		}
+		if n > 0 && buf[0] == handshakeInit {
+			inits++
+		}
+	}
+}
+
+func listenSecure(t *testing.T, addr string, required bool) *secureConn {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		t.Fatalf("Could not listen %s\n", err)
+	}
+
+	key, _ := GenerateKey()
+	return NewSecure(pc, SecureConfig{Key: key, Required: required}).(*secureConn)
+}
+
+func pingRequest(t *testing.T) []byte {
+	ping := messages.PingRequest{
+		SenderID: gokad.GenerateRandomID().String(),
+		RandomID: gokad.GenerateRandomID().String(),
+	}
+
+	b, err := ping.Bytes()
+	if err != nil {
+		t.Fatalf("Could not encode ping %s\n", err)
+	}
+
+	return b
+}
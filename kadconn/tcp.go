@@ -0,0 +1,386 @@
+package kadconn
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/alabianca/kadnet/kadclock"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const ErrFrameTooLarge = "frame too large"
+const ErrBadHello = "bad hello"
+
+// maxFrameSize is the size of the largest frame. Every message and sealed packet fits into one
+const maxFrameSize = 1 << 16
+
+// TCP adapts streams to packets. Every message is sent as a frame prefixed with its length.
+// The node that dials a connection first sends a hello with the port it listens on, so both sides
+// know the other one by its listening address. Connections are pooled per peer and used in both directions,
+// so a peer that can only dial out is still answered. The port in a hello is not verified, so a connection
+// that was accepted never takes the place of a pooled one. Its frames are still read, but writes keep going
+// to the pooled connection. Only a connection this node dialed itself replaces one.
+//
+// Frame
+// <- 4 Bytes <- Length Bytes
+//  Length     Message
+//
+// Hello
+// <- 4 Bytes <- 2 Bytes
+//  "KADT"     Listening Port
+
+var helloMagic = []byte("KADT")
+
+// TCPConfig holds the settings of the TCP transport
+type TCPConfig struct {
+	// DialTimeout is how long a write waits for a connection to a new peer. It defaults to 3 seconds
+	DialTimeout time.Duration
+	// IdleTimeout closes connections that did not carry a frame for that long. It defaults to 2 minutes
+	IdleTimeout time.Duration
+	// MaxConns is the size of the pool, including the accepted connections that are only read from.
+	// The least recently used connection is closed to make room. It defaults to 256
+	MaxConns int
+	// Clock is the clock IdleTimeout and read deadlines pass on. It defaults to the wall clock.
+	// The timeouts of the sockets themselves always use the wall clock
+	Clock kadclock.Clock
+}
+
+// TCP returns a stream transport for networks that block UDP
+func TCP(config TCPConfig) Transport {
+	return TransportFunc(func(addr string) (net.PacketConn, error) {
+		return ListenTCP(addr, config)
+	})
+}
+
+// ListenTCP returns a packet connection that sends and receives frames over pooled TCP connections
+func ListenTCP(addr string, config TCPConfig) (net.PacketConn, error) {
+	if config.DialTimeout <= 0 {
+		config.DialTimeout = time.Second * 3
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = time.Minute * 2
+	}
+	if config.MaxConns <= 0 {
+		config.MaxConns = 256
+	}
+	if config.Clock == nil {
+		config.Clock = kadclock.Real()
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &tcpConn{
+		listener: l,
+		port:     l.Addr().(*net.TCPAddr).Port,
+		config:   config,
+		pool:     make(map[string]*stream),
+		shadows:  make(map[string]*stream),
+		inbox:    make(chan packet, 1024),
+		closed:   make(chan struct{}),
+		deadline: deadline{clock: config.Clock},
+	}
+	go t.accept()
+	go t.expire()
+
+	return t, nil
+}
+
+type tcpConn struct {
+	listener net.Listener
+	port     int
+	config   TCPConfig
+	mtx      sync.Mutex
+	// pool holds a connection for every peer, keyed by the address the peer listens on
+	pool map[string]*stream
+	// shadows holds the connection of a peer that is only read from, because another one is pooled
+	shadows   map[string]*stream
+	inbox     chan packet
+	closed    chan struct{}
+	closeOnce sync.Once
+	deadline  deadline
+}
+
+// stream is a pooled connection to a peer
+type stream struct {
+	conn net.Conn
+	peer net.Addr
+	mtx  sync.Mutex // serializes writes
+	// used is the last time a frame was sent or received. Guarded by the mtx of the tcpConn
+	used time.Time
+}
+
+func (t *tcpConn) accept() {
+	for {
+		c, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			peer, err := t.readHello(c)
+			if err != nil {
+				c.Close()
+				return
+			}
+			t.serve(t.add(c, peer, false))
+		}()
+	}
+}
+
+// readHello reads the listening port of the peer that dialed c
+func (t *tcpConn) readHello(c net.Conn) (net.Addr, error) {
+	c.SetReadDeadline(time.Now().Add(t.config.DialTimeout))
+	defer c.SetReadDeadline(time.Time{})
+
+	hello := make([]byte, len(helloMagic)+2)
+	if _, err := io.ReadFull(c, hello); err != nil {
+		return nil, err
+	}
+	if string(hello[:len(helloMagic)]) != string(helloMagic) {
+		return nil, errors.New(ErrBadHello)
+	}
+
+	remote := c.RemoteAddr().(*net.TCPAddr)
+	return &net.TCPAddr{IP: remote.IP, Port: int(binary.BigEndian.Uint16(hello[len(helloMagic):]))}, nil
+}
+
+// add puts c into the pool. A connection this node dialed takes the place of the one that is pooled for
+// the same peer, which keeps serving its reads until it is closed. An accepted connection is only read from
+// if there is a pooled one already, because anyone on the same host can claim the port of the peer
+func (t *tcpConn) add(c net.Conn, peer net.Addr, dialed bool) *stream {
+	s := &stream{conn: c, peer: peer, used: t.config.Clock.Now()}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	key := peer.String()
+	if pooled, ok := t.pool[key]; !ok {
+		t.pool[key] = s
+	} else if dialed {
+		t.pool[key] = s
+		t.shadow(pooled)
+	} else {
+		t.shadow(s)
+	}
+	if len(t.pool)+len(t.shadows) > t.config.MaxConns {
+		t.evictOldest()
+	}
+
+	return s
+}
+
+// shadow keeps s to be read from. The connection it replaces is closed, so there is at most one per peer.
+// t.mtx must be held
+func (t *tcpConn) shadow(s *stream) {
+	if old, ok := t.shadows[s.peer.String()]; ok {
+		old.conn.Close()
+	}
+	t.shadows[s.peer.String()] = s
+}
+
+// evictOldest closes the least recently used connection. t.mtx must be held
+func (t *tcpConn) evictOldest() {
+	var oldest *stream
+	var from map[string]*stream
+	for _, streams := range []map[string]*stream{t.pool, t.shadows} {
+		for _, s := range streams {
+			if oldest == nil || s.used.Before(oldest.used) {
+				oldest, from = s, streams
+			}
+		}
+	}
+
+	delete(from, oldest.peer.String())
+	oldest.conn.Close()
+}
+
+// serve reads the frames of s until it is closed
+func (t *tcpConn) serve(s *stream) {
+	defer t.remove(s)
+	size := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(s.conn, size); err != nil {
+			return
+		}
+		n := binary.BigEndian.Uint32(size)
+		if n > maxFrameSize {
+			return
+		}
+
+		p := make([]byte, n)
+		if _, err := io.ReadFull(s.conn, p); err != nil {
+			return
+		}
+		t.touch(s)
+
+		select {
+		case t.inbox <- packet{p: p, from: s.peer}:
+		case <-t.closed:
+			return
+		}
+	}
+}
+
+func (t *tcpConn) touch(s *stream) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	s.used = t.config.Clock.Now()
+}
+
+func (t *tcpConn) remove(s *stream) {
+	s.conn.Close()
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	for _, streams := range []map[string]*stream{t.pool, t.shadows} {
+		if streams[s.peer.String()] == s {
+			delete(streams, s.peer.String())
+		}
+	}
+}
+
+// expire closes the connections that were idle for longer than IdleTimeout
+func (t *tcpConn) expire() {
+	ticker := t.config.Clock.NewTicker(t.config.IdleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.closed:
+			return
+		case now := <-ticker.C():
+			t.mtx.Lock()
+			for _, streams := range []map[string]*stream{t.pool, t.shadows} {
+				for key, s := range streams {
+					if now.Sub(s.used) > t.config.IdleTimeout {
+						delete(streams, key)
+						s.conn.Close()
+					}
+				}
+			}
+			t.mtx.Unlock()
+		}
+	}
+}
+
+// stream returns the pooled connection to addr. If there is none it dials one
+func (t *tcpConn) stream(addr net.Addr) (*stream, error) {
+	t.mtx.Lock()
+	s, ok := t.pool[addr.String()]
+	t.mtx.Unlock()
+	if ok {
+		return s, nil
+	}
+
+	c, err := net.DialTimeout("tcp", addr.String(), t.config.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	hello := make([]byte, len(helloMagic)+2)
+	copy(hello, helloMagic)
+	binary.BigEndian.PutUint16(hello[len(helloMagic):], uint16(t.port))
+	if _, err := c.Write(hello); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	peer, err := net.ResolveTCPAddr("tcp", addr.String())
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	s = t.add(c, peer, true)
+	go t.serve(s)
+	return s, nil
+}
+
+func (t *tcpConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	expired, stop := t.deadline.wait()
+	defer stop()
+	select {
+	case pkt := <-t.inbox:
+		return copy(p, pkt.p), pkt.from, nil
+	case <-t.closed:
+		return 0, nil, net.ErrClosed
+	case <-expired:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+// WriteTo sends p as a single frame to the peer listening at addr.
+// A write on a pooled connection the peer closed is retried once on a new connection
+func (t *tcpConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if len(p) > maxFrameSize {
+		return 0, errors.New(ErrFrameTooLarge)
+	}
+	select {
+	case <-t.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+
+	frame := make([]byte, 4+len(p))
+	binary.BigEndian.PutUint32(frame, uint32(len(p)))
+	copy(frame[4:], p)
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		var s *stream
+		if s, err = t.stream(addr); err != nil {
+			return 0, err
+		}
+
+		s.mtx.Lock()
+		s.conn.SetWriteDeadline(time.Now().Add(t.config.DialTimeout))
+		_, err = s.conn.Write(frame)
+		s.mtx.Unlock()
+		if err == nil {
+			t.touch(s)
+			return len(p), nil
+		}
+		t.remove(s)
+	}
+
+	return 0, err
+}
+
+func (t *tcpConn) Close() error {
+	var err error
+	t.closeOnce.Do(func() {
+		close(t.closed)
+		err = t.listener.Close()
+		t.mtx.Lock()
+		defer t.mtx.Unlock()
+		for _, streams := range []map[string]*stream{t.pool, t.shadows} {
+			for key, s := range streams {
+				delete(streams, key)
+				s.conn.Close()
+			}
+		}
+	})
+
+	return err
+}
+
+func (t *tcpConn) LocalAddr() net.Addr {
+	return t.listener.Addr()
+}
+
+func (t *tcpConn) SetDeadline(d time.Time) error {
+	return t.SetReadDeadline(d)
+}
+
+func (t *tcpConn) SetReadDeadline(d time.Time) error {
+	t.deadline.set(d)
+	return nil
+}
+
+// SetWriteDeadline is a no-op. Every write has a deadline of DialTimeout
+func (t *tcpConn) SetWriteDeadline(d time.Time) error {
+	return nil
+}
@@ -0,0 +1,280 @@
+package kadconn
+
+import (
+	"encoding/binary"
+	"github.com/alabianca/kadnet/kadclock"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTCP_RoundTrip(t *testing.T) {
+	a := listen(t, TCP(TCPConfig{}), "127.0.0.1:0")
+	defer a.Close()
+	b := listen(t, TCP(TCPConfig{}), "127.0.0.1:0")
+	defer b.Close()
+	fromA, fromB := receive(New(a)), receive(New(b))
+
+	ping := pingRequest(t)
+	if _, err := New(a).Write(ping, b.LocalAddr()); err != nil {
+		t.Fatalf("Expected err to be nil, but got %s\n", err)
+	}
+
+	// the sender is known by the address it listens on, not by the port it dialed from
+	res := next(t, fromB)
+	if res.err != nil || string(res.msg) != string(ping) || res.from.String() != a.LocalAddr().String() {
+		t.Fatalf("Expected %v from %s, but got %v\n", ping, a.LocalAddr(), res)
+	}
+
+	// the reply goes over the pooled connection
+	New(b).Write(ping, res.from)
+	if res := next(t, fromA); res.err != nil || string(res.msg) != string(ping) {
+		t.Fatalf("Expected %v, but got %v\n", ping, res)
+	}
+	if pooled(a) != 1 || pooled(b) != 1 {
+		t.Fatalf("Expected a single connection on each side, but got %d and %d\n", pooled(a), pooled(b))
+	}
+}
+
+func TestTCP_Redial(t *testing.T) {
+	a := listen(t, TCP(TCPConfig{}), "127.0.0.1:0")
+	defer a.Close()
+	b := listen(t, TCP(TCPConfig{}), "127.0.0.1:0")
+	defer b.Close()
+	fromB := receive(New(b))
+
+	ping := pingRequest(t)
+	New(a).Write(ping, b.LocalAddr())
+	next(t, fromB)
+
+	// b drops the connection, so a has to dial again once it noticed
+	b.(*tcpConn).mtx.Lock()
+	var streams []*stream
+	for _, s := range b.(*tcpConn).pool {
+		streams = append(streams, s)
+	}
+	b.(*tcpConn).mtx.Unlock()
+	for _, s := range streams {
+		b.(*tcpConn).remove(s)
+	}
+	for deadline := time.Now().Add(time.Second); pooled(a) > 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the closed connection to leave the pool\n")
+		}
+	}
+	if _, err := New(a).Write(ping, b.LocalAddr()); err != nil {
+		t.Fatalf("Expected err to be nil, but got %s\n", err)
+	}
+	if res := next(t, fromB); res.err != nil || string(res.msg) != string(ping) {
+		t.Fatalf("Expected %v, but got %v\n", ping, res)
+	}
+}
+
+func TestTCP_IdleTimeout(t *testing.T) {
+	start := time.Now()
+	clock := kadclock.NewManual(start)
+	a := listen(t, TCP(TCPConfig{IdleTimeout: time.Minute, Clock: clock}), "127.0.0.1:0")
+	defer a.Close()
+	b := listen(t, TCP(TCPConfig{}), "127.0.0.1:0")
+	defer b.Close()
+	fromB := receive(New(b))
+
+	New(a).Write(pingRequest(t), b.LocalAddr())
+	next(t, fromB)
+
+	// the connection is checked every half of IdleTimeout
+	for deadline := time.Now().Add(time.Second); pooled(a) > 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the idle connection to be closed\n")
+		}
+		clock.Advance(time.Second * 30)
+	}
+	if now := clock.Now(); now.Sub(start) <= time.Minute {
+		t.Fatalf("Expected the connection to be closed after a minute, but it was closed after %s\n", now.Sub(start))
+	}
+}
+
+func TestTCP_ClaimedPort(t *testing.T) {
+	a := listen(t, TCP(TCPConfig{}), "127.0.0.1:0")
+	defer a.Close()
+	b := listen(t, TCP(TCPConfig{}), "127.0.0.1:0")
+	defer b.Close()
+	fromA, fromB := receive(New(a)), receive(New(b))
+
+	ping := pingRequest(t)
+	New(a).Write(ping, b.LocalAddr())
+	next(t, fromB)
+
+	// another connection from the same host claims the port of a
+	c, err := net.Dial("tcp", b.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Could not dial %s\n", err)
+	}
+	defer c.Close()
+	frame := make([]byte, len(helloMagic)+2+4+len(ping))
+	copy(frame, helloMagic)
+	binary.BigEndian.PutUint16(frame[len(helloMagic):], uint16(a.LocalAddr().(*net.TCPAddr).Port))
+	binary.BigEndian.PutUint32(frame[len(helloMagic)+2:], uint32(len(ping)))
+	copy(frame[len(helloMagic)+6:], ping)
+	c.Write(frame)
+	if res := next(t, fromB); res.err != nil || res.from.String() != a.LocalAddr().String() {
+		t.Fatalf("Expected the frame to be read, but got %v\n", res)
+	}
+
+	// replies still go to a
+	New(b).Write(ping, a.LocalAddr())
+	if res := next(t, fromA); res.err != nil || string(res.msg) != string(ping) {
+		t.Fatalf("Expected %v, but got %v\n", ping, res)
+	}
+	c.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
+	if n, _ := c.Read(make([]byte, 64)); n != 0 {
+		t.Fatalf("Expected the claiming connection to get nothing, but got %d bytes\n", n)
+	}
+}
+
+func TestNegotiated(t *testing.T) {
+	both := listen(t, Negotiated(NegotiatedConfig{}, UDP(), TCP(TCPConfig{})), "127.0.0.1:0")
+	defer both.Close()
+	udp := listen(t, UDP(), "127.0.0.1:0")
+	defer udp.Close()
+	tcp := listen(t, TCP(TCPConfig{}), "127.0.0.1:0")
+	defer tcp.Close()
+	fromBoth, fromUDP, fromTCP := receive(New(both)), receive(New(udp)), receive(New(tcp))
+
+	// both transports listen on the same port
+	ping := pingRequest(t)
+	New(udp).Write(ping, both.LocalAddr())
+	New(tcp).Write(ping, both.LocalAddr())
+
+	for i := 0; i < 2; i++ {
+		res := next(t, fromBoth)
+		if res.err != nil {
+			t.Fatalf("Expected err to be nil, but got %s\n", res.err)
+		}
+		New(both).Write(ping, res.from)
+	}
+
+	// every peer is answered on the transport it used
+	if res := next(t, fromUDP); res.err != nil || string(res.msg) != string(ping) {
+		t.Fatalf("Expected %v over UDP, but got %v\n", ping, res)
+	}
+	if res := next(t, fromTCP); res.err != nil || string(res.msg) != string(ping) {
+		t.Fatalf("Expected %v over TCP, but got %v\n", ping, res)
+	}
+}
+
+func TestNegotiated_Fallback(t *testing.T) {
+	both := listen(t, Negotiated(NegotiatedConfig{Fallback: time.Millisecond * 50}, UDP(), TCP(TCPConfig{})), "127.0.0.1:0")
+	defer both.Close()
+	tcp := listen(t, TCP(TCPConfig{}), "127.0.0.1:0")
+	defer tcp.Close()
+	fromBoth, fromTCP := receive(New(both)), receive(New(tcp))
+
+	// the peer never reached both, so the ping goes out on UDP first and on TCP once it is not answered
+	ping := pingRequest(t)
+	addr := tcp.LocalAddr().(*net.TCPAddr)
+	sent := time.Now()
+	if _, err := New(both).Write(ping, &net.UDPAddr{IP: addr.IP, Port: addr.Port}); err != nil {
+		t.Fatalf("Expected err to be nil, but got %s\n", err)
+	}
+	res := next(t, fromTCP)
+	if res.err != nil || string(res.msg) != string(ping) {
+		t.Fatalf("Expected %v over TCP, but got %v\n", ping, res)
+	}
+	if time.Since(sent) < time.Millisecond*50 {
+		t.Fatalf("Expected the ping to wait for the fallback, but it took %s\n", time.Since(sent))
+	}
+
+	// once the peer answered it is sent to on TCP right away
+	New(tcp).Write(ping, res.from)
+	next(t, fromBoth)
+	if i, ok := routed(both, tcp.LocalAddr()); !ok || i != 1 {
+		t.Fatalf("Expected the peer to be sent to on TCP, but got transport %d\n", i)
+	}
+}
+
+func TestNegotiated_Spoofed(t *testing.T) {
+	clock := kadclock.NewManual(time.Now())
+	both := listen(t, Negotiated(NegotiatedConfig{PeerTimeout: time.Minute, Clock: clock}, UDP(), TCP(TCPConfig{})), "127.0.0.1:0")
+	defer both.Close()
+	tcp := listen(t, TCP(TCPConfig{}), "127.0.0.1:0")
+	defer tcp.Close()
+	fromBoth := receive(New(both))
+
+	ping := pingRequest(t)
+	New(tcp).Write(ping, both.LocalAddr())
+	next(t, fromBoth)
+
+	// a datagram from the address of the peer does not move it off TCP while it uses TCP
+	addr := tcp.LocalAddr().(*net.TCPAddr)
+	spoofed := listen(t, UDP(), (&net.UDPAddr{IP: addr.IP, Port: addr.Port}).String())
+	defer spoofed.Close()
+	New(spoofed).Write(ping, both.LocalAddr())
+	next(t, fromBoth)
+	if i, ok := routed(both, tcp.LocalAddr()); !ok || i != 1 {
+		t.Fatalf("Expected the peer to stay on TCP, but got transport %d\n", i)
+	}
+
+	// once it stopped using TCP it moves
+	clock.Advance(time.Minute * 2)
+	New(spoofed).Write(ping, both.LocalAddr())
+	next(t, fromBoth)
+	if i, ok := routed(both, tcp.LocalAddr()); !ok || i != 0 {
+		t.Fatalf("Expected the peer to move to UDP, but got transport %d\n", i)
+	}
+}
+
+func TestNegotiated_MaxPeers(t *testing.T) {
+	both := listen(t, Negotiated(NegotiatedConfig{MaxPeers: 2}, UDP(), TCP(TCPConfig{})), "127.0.0.1:0")
+	defer both.Close()
+	fromBoth := receive(New(both))
+
+	var peers []net.PacketConn
+	for i := 0; i < 4; i++ {
+		udp := listen(t, UDP(), "127.0.0.1:0")
+		defer udp.Close()
+		New(udp).Write(pingRequest(t), both.LocalAddr())
+		next(t, fromBoth)
+		peers = append(peers, udp)
+	}
+
+	m := both.(*negotiatedConn)
+	m.mtx.Lock()
+	n := len(m.peers)
+	m.mtx.Unlock()
+	if n != 2 {
+		t.Fatalf("Expected 2 peers, but got %d\n", n)
+	}
+	if _, ok := routed(both, peers[3].LocalAddr()); !ok {
+		t.Fatalf("Expected the latest peer to be kept\n")
+	}
+}
+
+// routed returns the transport addr is sent to and whether it reached pc
+func routed(pc net.PacketConn, addr net.Addr) (int, bool) {
+	m := pc.(*negotiatedConn)
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	r, ok := m.peers[addr.String()]
+	if !ok {
+		return -1, false
+	}
+
+	return r.transport, r.reached
+}
+
+func listen(t *testing.T, transport Transport, addr string) net.PacketConn {
+	pc, err := transport.ListenPacket(addr)
+	if err != nil {
+		t.Fatalf("Could not listen %s\n", err)
+	}
+
+	return pc
+}
+
+func pooled(pc net.PacketConn) int {
+	t := pc.(*tcpConn)
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return len(t.pool)
+}
@@ -0,0 +1,324 @@
+package kadconn
+
+import (
+	"errors"
+	"github.com/alabianca/kadnet/kadclock"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const ErrNoTransport = "no transport"
+
+// Transport opens the packet connection a node sends and receives messages on.
+// Stream transports like TCP are adapted to net.PacketConn, so New and NewSecure work on top of every transport
+type Transport interface {
+	ListenPacket(addr string) (net.PacketConn, error)
+}
+
+// TransportFunc lets ordinary functions be used as a Transport
+type TransportFunc func(addr string) (net.PacketConn, error)
+
+func (f TransportFunc) ListenPacket(addr string) (net.PacketConn, error) {
+	return f(addr)
+}
+
+// UDP returns the datagram transport. It is the default transport of a node
+func UDP() Transport {
+	return TransportFunc(func(addr string) (net.PacketConn, error) {
+		return net.ListenPacket("udp", addr)
+	})
+}
+
+// NegotiatedConfig holds the settings of Negotiated
+type NegotiatedConfig struct {
+	// Fallback is how long a message to a peer that never reached this node waits for the peer to answer.
+	// Then it is sent again on the next transport. It defaults to 1 second
+	Fallback time.Duration
+	// Clock is the clock Fallback and read deadlines pass on. It defaults to the wall clock
+	Clock kadclock.Clock
+	// MaxPeers is the number of peers a transport is remembered for. The least recently seen peer is dropped
+	// to make room. It defaults to 1024
+	MaxPeers int
+	// PeerTimeout is how long a peer keeps the transport it reached this node on once it stops using it.
+	// Until then packets on an earlier transport do not move it back, so spoofed datagrams cannot pin a peer
+	// that uses TCP to UDP. It defaults to 2 minutes
+	PeerTimeout time.Duration
+}
+
+// Negotiated listens on all transports at the same address. Every peer is answered on the transport it last
+// reached this node on, but it is only moved back to an earlier one once it stopped using its own. Peers that never did are tried on one transport after the other, starting with the first.
+// A node that cannot use UDP prefers TCP, and nodes with Negotiated(UDP(), TCP(...)) answer it on TCP
+func Negotiated(config NegotiatedConfig, transports ...Transport) Transport {
+	if config.Fallback <= 0 {
+		config.Fallback = time.Second
+	}
+	if config.Clock == nil {
+		config.Clock = kadclock.Real()
+	}
+	if config.MaxPeers <= 0 {
+		config.MaxPeers = 1024
+	}
+	if config.PeerTimeout <= 0 {
+		config.PeerTimeout = time.Minute * 2
+	}
+
+	return TransportFunc(func(addr string) (net.PacketConn, error) {
+		if len(transports) == 0 {
+			return nil, errors.New(ErrNoTransport)
+		}
+
+		m := &negotiatedConn{
+			config:   config,
+			peers:    make(map[string]*route),
+			inbox:    make(chan packet, 64),
+			closed:   make(chan struct{}),
+			deadline: deadline{clock: config.Clock},
+		}
+		for _, t := range transports {
+			pc, err := t.ListenPacket(addr)
+			if err != nil {
+				m.Close()
+				return nil, err
+			}
+			m.conns = append(m.conns, pc)
+			// the other transports listen on the port the first one got
+			if addr != pc.LocalAddr().String() {
+				if _, port, err := net.SplitHostPort(pc.LocalAddr().String()); err == nil {
+					host, _, _ := net.SplitHostPort(addr)
+					addr = net.JoinHostPort(host, port)
+				}
+			}
+		}
+		for i, pc := range m.conns {
+			go m.receive(i, pc)
+		}
+
+		return m, nil
+	})
+}
+
+type packet struct {
+	p    []byte
+	from net.Addr
+	err  error
+}
+
+// negotiatedConn is the packet connection of Negotiated
+type negotiatedConn struct {
+	conns  []net.PacketConn
+	config NegotiatedConfig
+	mtx    sync.Mutex
+	// peers maps the address of a peer to the transport it is sent to
+	peers     map[string]*route
+	inbox     chan packet
+	closed    chan struct{}
+	closeOnce sync.Once
+	deadline  deadline
+}
+
+// route is the transport a peer is sent to
+type route struct {
+	// transport is the index of the transport
+	transport int
+	// reached is set once the peer reached this node on transport. Until then transport is only tried
+	reached bool
+	// seen is the last time the peer reached this node on transport, or was first tried
+	seen time.Time
+}
+
+func (m *negotiatedConn) receive(i int, pc net.PacketConn) {
+	buf := make([]byte, maxFrameSize)
+	for {
+		n, from, err := pc.ReadFrom(buf)
+		if err == nil {
+			m.reached(from, i)
+		}
+
+		select {
+		case m.inbox <- packet{p: append([]byte(nil), buf[:n]...), from: from, err: err}:
+		case <-m.closed:
+			return
+		}
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+	}
+}
+
+// reached records that addr reached this node on transport i. A peer is moved to an earlier transport only
+// if it did not use its own for PeerTimeout
+func (m *negotiatedConn) reached(addr net.Addr, i int) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	now := m.config.Clock.Now()
+	r := m.route(addr)
+	if r.reached && i < r.transport && now.Sub(r.seen) <= m.config.PeerTimeout {
+		return
+	}
+	r.transport, r.reached, r.seen = i, true, now
+}
+
+// route returns the route to addr. A new route starts on the first transport. m.mtx must be held
+func (m *negotiatedConn) route(addr net.Addr) *route {
+	r, ok := m.peers[addr.String()]
+	if !ok {
+		if len(m.peers) >= m.config.MaxPeers {
+			m.evictOldest()
+		}
+		r = &route{seen: m.config.Clock.Now()}
+		m.peers[addr.String()] = r
+	}
+
+	return r
+}
+
+// evictOldest drops the route of the least recently seen peer. m.mtx must be held
+func (m *negotiatedConn) evictOldest() {
+	var oldest string
+	for key, r := range m.peers {
+		if oldest == "" || r.seen.Before(m.peers[oldest].seen) {
+			oldest = key
+		}
+	}
+
+	delete(m.peers, oldest)
+}
+
+func (m *negotiatedConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	expired, stop := m.deadline.wait()
+	defer stop()
+	select {
+	case pkt := <-m.inbox:
+		return copy(p, pkt.p), pkt.from, pkt.err
+	case <-m.closed:
+		return 0, nil, net.ErrClosed
+	case <-expired:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (m *negotiatedConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	m.mtx.Lock()
+	var i int
+	var ok bool
+	if r, found := m.peers[addr.String()]; found {
+		i, ok = r.transport, r.reached
+	}
+	m.mtx.Unlock()
+
+	if ok {
+		return m.conns[i].WriteTo(p, addr)
+	}
+
+	return m.try(append([]byte(nil), p...), addr, i)
+}
+
+// try sends p on transport i to a peer that never reached this node. If the write fails, or the peer
+// does not answer within Fallback, p is sent again on the next transport
+func (m *negotiatedConn) try(p []byte, addr net.Addr, i int) (int, error) {
+	n, err := m.conns[i].WriteTo(p, addr)
+	if i == len(m.conns)-1 {
+		if err != nil {
+			// start over with the first transport next time
+			m.mtx.Lock()
+			if r, ok := m.peers[addr.String()]; ok && !r.reached {
+				delete(m.peers, addr.String())
+			}
+			m.mtx.Unlock()
+		}
+		return n, err
+	}
+
+	if err != nil {
+		m.advance(addr, i)
+		return m.try(p, addr, i+1)
+	}
+
+	m.config.Clock.AfterFunc(m.config.Fallback, func() {
+		select {
+		case <-m.closed:
+			return
+		default:
+		}
+		if m.advance(addr, i) {
+			m.try(p, addr, i+1)
+		}
+	})
+
+	return n, nil
+}
+
+// advance moves a peer that was sent to on transport i to the next transport.
+// It reports false if the peer reached this node in the meantime
+func (m *negotiatedConn) advance(addr net.Addr, i int) bool {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	r := m.route(addr)
+	if r.reached {
+		return false
+	}
+	if r.transport <= i {
+		r.transport = i + 1
+	}
+
+	return true
+}
+
+func (m *negotiatedConn) Close() error {
+	var err error
+	m.closeOnce.Do(func() {
+		close(m.closed)
+		for _, pc := range m.conns {
+			if e := pc.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	})
+
+	return err
+}
+
+func (m *negotiatedConn) LocalAddr() net.Addr {
+	return m.conns[0].LocalAddr()
+}
+
+func (m *negotiatedConn) SetDeadline(t time.Time) error {
+	return m.SetReadDeadline(t)
+}
+
+func (m *negotiatedConn) SetReadDeadline(t time.Time) error {
+	m.deadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline is a no-op. Writes go to the transport of the peer directly
+func (m *negotiatedConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// deadline is the read deadline of a packet connection that does not read from a socket itself
+type deadline struct {
+	clock kadclock.Clock
+	mtx   sync.Mutex
+	t     time.Time
+}
+
+func (d *deadline) set(t time.Time) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.t = t
+}
+
+// wait returns a channel that fires at the deadline and a func that releases it. It never fires without a deadline
+func (d *deadline) wait() (<-chan time.Time, func()) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.t.IsZero() {
+		return nil, func() {}
+	}
+
+	timer := d.clock.NewTimer(d.t.Sub(d.clock.Now()))
+	return timer.C(), func() { timer.Stop() }
+}
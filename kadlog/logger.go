@@ -0,0 +1,108 @@
+package kadlog
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+func (l Level) String() string {
+	switch l {
+	case Debug:
+		return "debug"
+	case Info:
+		return "info"
+	case Warn:
+		return "warn"
+	case Error:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// Field is a structured key value pair attached to a log entry
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Logger is implemented by anything that can record log entries.
+// Implementations must be safe for concurrent use
+type Logger interface {
+	Log(level Level, msg string, fields ...Field)
+}
+
+// LoggerFunc lets ordinary functions be used as a Logger. Useful to adapt other logging libraries
+type LoggerFunc func(level Level, msg string, fields ...Field)
+
+func (f LoggerFunc) Log(level Level, msg string, fields ...Field) {
+	f(level, msg, fields...)
+}
+
+// Nop returns a Logger that discards everything
+func Nop() Logger {
+	return LoggerFunc(func(level Level, msg string, fields ...Field) {})
+}
+
+// New returns a Logger that writes entries of at least level min to w, one line per entry
+// 2006-01-02T15:04:05Z07:00 level=info msg="some message" key=value
+func New(w io.Writer, min Level) Logger {
+	return &writerLogger{w: w, min: min}
+}
+
+type writerLogger struct {
+	mtx sync.Mutex
+	w   io.Writer
+	min Level
+}
+
+func (l *writerLogger) Log(level Level, msg string, fields ...Field) {
+	if level < l.min {
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s level=%s msg=%q", time.Now().Format(time.RFC3339), level, msg)
+	for _, f := range fields {
+		fmt.Fprintf(&b, " %s=%s", f.Key, value(f.Value))
+	}
+	b.WriteString("\n")
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	io.WriteString(l.w, b.String())
+}
+
+func value(v interface{}) string {
+	var s string
+	switch x := v.(type) {
+	case error:
+		s = x.Error()
+	case fmt.Stringer:
+		s = x.String()
+	default:
+		s = fmt.Sprint(x)
+	}
+
+	if s == "" || strings.ContainsAny(s, " \"=") {
+		return fmt.Sprintf("%q", s)
+	}
+
+	return s
+}
@@ -0,0 +1,44 @@
+package kadlog
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLogger_Level(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, Warn)
+
+	logger.Log(Debug, "debug")
+	logger.Log(Info, "info")
+	logger.Log(Warn, "warn")
+	logger.Log(Error, "error")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 entries, but got %d: %q\n", len(lines), buf.String())
+	}
+
+	if !strings.Contains(lines[0], "level=warn") || !strings.Contains(lines[1], "level=error") {
+		t.Fatalf("Expected a warn and an error entry, but got %q\n", buf.String())
+	}
+}
+
+func TestLogger_Fields(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, Debug)
+
+	logger.Log(Info, "request received", F("type", "PingRequest"), F("port", 5001), F("error", errors.New("a b")), F("empty", ""))
+
+	expected := `level=info msg="request received" type=PingRequest port=5001 error="a b" empty=""`
+	if !strings.Contains(buf.String(), expected) {
+		t.Fatalf("Expected entry to contain %s, but got %s\n", expected, buf.String())
+	}
+}
+
+func TestNop(t *testing.T) {
+	// must not panic
+	Nop().Log(Error, "nothing", F("key", "value"))
+}
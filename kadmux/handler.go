@@ -1,13 +1,14 @@
 package kadmux
 
 import (
-	"fmt"
 	"github.com/alabianca/gokad"
-	"github.com/alabianca/kadnet/buffers"
 	"github.com/alabianca/kadnet/kadconn"
+	"github.com/alabianca/kadnet/kadlog"
 	"github.com/alabianca/kadnet/messages"
 	"github.com/alabianca/kadnet/request"
+	"github.com/alabianca/kadnet/transaction"
 	"io"
+	"time"
 )
 
 type RpcHandlerFunc func(conn kadconn.KadWriter, req *request.Request)
@@ -20,12 +21,15 @@ type RpcHandler interface {
 	Handle(conn kadconn.KadWriter, req *request.Request)
 }
 
+// ExpectedPingReplyExpiry is how long the implicit PingReply to a request is expected
+const ExpectedPingReplyExpiry = time.Second * 50
+
 // On any request that is sent to use we also expect to receive a
 // PingResponse after we responded.
-// This middleware creates the PingReplyMessage we expect to receive
-// based on the request's EchoRandomId. We then store
-// the expected PingReplyMessage in the provided buffer.
-func ExpectPingReply(buffer buffers.Buffer) func(next RpcHandler) RpcHandler {
+// This middleware registers the PingReplyMessage we expect to receive
+// based on the request's RandomID in the provided table. The implicit
+// PingReply echoes it.
+func ExpectPingReply(expected *transaction.Table) func(next RpcHandler) RpcHandler {
 	return func(next RpcHandler) RpcHandler {
 		fn := func(conn kadconn.KadWriter, req *request.Request) {
 			mux, _ := req.Body.MultiplexKey()
@@ -34,17 +38,9 @@ func ExpectPingReply(buffer buffers.Buffer) func(next RpcHandler) RpcHandler {
 				return
 			}
 
-			writer := buffer.NewWriter()
 			sid, _ := req.Body.SenderID()
 			rid, _ := req.Body.RandomID()
-			pingReply := messages.PingResponse{
-				SenderID:     sid.String(),
-				EchoRandomID: gokad.ID(rid).String(),
-				RandomID:     gokad.GenerateRandomID().String(),
-			}
-
-			b, _ := pingReply.Bytes()
-			writer.Write(b)
+			expected.Register(gokad.ID(rid).String(), sid, expected.Now().Add(ExpectedPingReplyExpiry))
 
 			next.Handle(conn, req)
 		}
@@ -53,7 +49,13 @@ func ExpectPingReply(buffer buffers.Buffer) func(next RpcHandler) RpcHandler {
 	}
 }
 
+// Logging logs every request to w
 func Logging(w io.Writer) func(next RpcHandler) RpcHandler {
+	return LogRequests(kadlog.New(w, kadlog.Debug))
+}
+
+// LogRequests logs every request to logger at the debug level
+func LogRequests(logger kadlog.Logger) func(next RpcHandler) RpcHandler {
 	return func(next RpcHandler) RpcHandler {
 		fn := func(conn kadconn.KadWriter, req *request.Request) {
 			mux, _ := req.Body.MultiplexKey()
@@ -61,10 +63,14 @@ func Logging(w io.Writer) func(next RpcHandler) RpcHandler {
 			rid, _ := req.Body.RandomID()
 			eid, _ := req.Body.EchoRandomID()
 
-			fmt.Fprintf(
-				w,
-				"(%s): Sender: (%s) RandomID: (%s) EchoRandomID: (%s)\n",
-				messageType(mux), sid.String(), gokad.ID(rid).String(), gokad.ID(eid).String())
+			logger.Log(
+				kadlog.Debug,
+				"request received",
+				kadlog.F("type", messageType(mux)),
+				kadlog.F("sender", sid.String()),
+				kadlog.F("remote", req.Address()),
+				kadlog.F("random_id", gokad.ID(rid).String()),
+				kadlog.F("echo_random_id", gokad.ID(eid).String()))
 
 			next.Handle(conn, req)
 
@@ -92,6 +98,26 @@ func messageType(t messages.MessageType) string {
 		return "StoreRequest"
 	case messages.StoreRes:
 		return "StoreResponse"
+	case messages.PingResExplicit:
+		return "PingResponse[Explicit]"
+	case messages.FindValueResOK:
+		return "FindValueResponse[OK]"
+	case messages.StoreDataReq:
+		return "StoreDataRequest"
+	case messages.FindDataReq:
+		return "FindDataRequest"
+	case messages.FindDataRes:
+		return "FindDataResponse"
+	case messages.FindDataResOK:
+		return "FindDataResponse[OK]"
+	case messages.StoreMutableReq:
+		return "StoreMutableRequest"
+	case messages.FindMutableReq:
+		return "FindMutableRequest"
+	case messages.FindMutableRes:
+		return "FindMutableResponse"
+	case messages.FindMutableResOK:
+		return "FindMutableResponse[OK]"
 
 	default:
 		return "Not Found"
@@ -1,8 +1,11 @@
 package kadmux
 
 import (
-	"github.com/alabianca/kadnet/buffers"
+	"github.com/alabianca/kadnet/kadclock"
+	"github.com/alabianca/kadnet/kadlog"
+	"github.com/alabianca/kadnet/transaction"
 	"net"
+	"sync"
 
 	"github.com/alabianca/kadnet/kadconn"
 	"github.com/alabianca/kadnet/messages"
@@ -10,16 +13,14 @@ import (
 )
 
 const HandlerNotFoundErr = "Handler Not Found"
-const NodeReplyBufferID = "NodeReplyBuffer"
-const PingReplyBufferID = "PingReplyBuffer"
-const StoreReplyBufferID = "StoreReplyBuffer"
-const ValueReplyBufferID = "ValueReplyBuffer"
 
 type Mux interface {
 	Handle(c kadconn.KadConn) error
 	HandleFunc(m messages.MessageType, handler RpcHandlerFunc)
-	GetBuffer(keystring string) buffers.Buffer
+	Transactions() *transaction.Table
 	Use(middlewares ...func(handler RpcHandler) RpcHandler)
+	SetLogger(logger kadlog.Logger)
+	SetClock(clock kadclock.Clock)
 	Close()
 }
 
@@ -41,8 +42,12 @@ type kadMux struct {
 	stopReply       chan chan error
 	stopDispatcher  chan bool
 	exit            chan error
-	// buffers
-	buffers map[string]buffers.Buffer
+	// pending transactions incoming responses are delivered to
+	transactions *transaction.Table
+	logger       kadlog.Logger
+	// mtx guards the stop channels and closed, as Close may run concurrently to Handle
+	mtx    sync.Mutex
+	closed bool
 }
 
 func NewMux() Mux {
@@ -56,12 +61,8 @@ func NewMux() Mux {
 		onRequest:       make(chan *request.Request),
 		onResponse:      make(chan messages.Message),
 		exit:            make(chan error),
-		buffers: map[string]buffers.Buffer{
-			NodeReplyBufferID:  buffers.NewNodeReplyBuffer(),
-			PingReplyBufferID:  buffers.NewPingReplyBuffer(),
-			StoreReplyBufferID: buffers.NewStoreReplyBuffer(),
-			ValueReplyBufferID: buffers.NewNodeReplyBuffer(),
-		},
+		transactions:    transaction.NewTable(),
+		logger:          kadlog.Nop(),
 	}
 }
 
@@ -69,42 +70,59 @@ func (k *kadMux) Use(middlewares ...func(handler RpcHandler) RpcHandler) {
 	k.middlewares = append(k.middlewares, middlewares...)
 }
 
-func (k *kadMux) GetBuffer(key string) buffers.Buffer {
-	b, _ := k.buffers[key]
-	return b
+// SetLogger sets the logger read and buffering errors are reported to. It must be called before Handle
+func (k *kadMux) SetLogger(logger kadlog.Logger) {
+	k.logger = logger
 }
 
+// SetClock lets the deadlines of pending transactions pass on clock. It must be called before Handle
+// and before any transaction is registered
+func (k *kadMux) SetClock(clock kadclock.Clock) {
+	k.transactions = transaction.NewTableWithClock(clock)
+}
+
+// Transactions returns the table of pending requests responses are matched against
+func (k *kadMux) Transactions() *transaction.Table {
+	return k.transactions
+}
+
+// Close stops the threads started by Handle. A Handle that was not called yet returns right away
 func (k *kadMux) Close() {
-	if k.stopReply != nil && k.stopReceiver != nil {
+	k.mtx.Lock()
+	k.closed = true
+	stopReplyThread, stopReceiverThread := k.stopReply, k.stopReceiver
+	k.mtx.Unlock()
+
+	if stopReplyThread != nil && stopReceiverThread != nil {
 		stopReply := make(chan error)
 		stopRec := make(chan error)
-		k.stopReply <- stopReply
-		k.stopReceiver <- stopRec
+		stopReplyThread <- stopReply
+		stopReceiverThread <- stopRec
 
 		<-stopReply
 		<-stopRec
 		k.stopDispatcher <- true
 		k.exit <- nil
-		// close all buffers
-		for _, v := range k.buffers {
-			v.Close()
-		}
 	}
 }
 
 // Handle the connection and start the request dispatcher, receiverThread and replyThread
-// We also open all buffers that remain open through the lifetime of the mux
 func (k *kadMux) Handle(conn kadconn.KadConn) error {
+	k.mtx.Lock()
+	if k.closed {
+		k.mtx.Unlock()
+		return nil
+	}
 	k.conn = conn
 	k.startDispatcher(10) // @todo get max workers from somewhere else
 	receiver := NewReceiverThread(k.onResponse, k.onRequest, k.conn)
+	receiver.SetLogger(k.logger)
 	reply := NewReplyThread(k.onResponse, k.onRequest, k.conn)
+	reply.SetLogger(k.logger)
 
-	// store the buffers in the reply thread so we can buffer incoming responses
-	// requests are not buffered and are handled by the dispatcher
-	reply.SetBuffers(k.buffers)
-
-	k.buffers[PingReplyBufferID].Open()
+	// incoming responses are delivered to the pending transactions.
+	// requests are handled by the dispatcher
+	reply.SetTransactions(k.transactions)
 
 	k.stopReceiver = make(chan chan error)
 	k.stopReply = make(chan chan error)
@@ -112,6 +130,7 @@ func (k *kadMux) Handle(conn kadconn.KadConn) error {
 	go k.handleRequests()
 	go receiver.Run(k.stopReceiver)
 	go reply.Run(k.dispatchRequest, k.stopReply)
+	k.mtx.Unlock()
 
 	return <-k.exit
 }
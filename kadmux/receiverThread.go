@@ -3,6 +3,7 @@ package kadmux
 import (
 	"github.com/alabianca/gokad"
 	"github.com/alabianca/kadnet/kadconn"
+	"github.com/alabianca/kadnet/kadlog"
 
 	"github.com/alabianca/kadnet/messages"
 	"github.com/alabianca/kadnet/request"
@@ -21,6 +22,7 @@ type ReceiverThread struct {
 	fanoutReply   chan<- messages.Message
 	fanoutRequest chan<- *request.Request
 	conn          kadconn.KadReader
+	logger        kadlog.Logger
 }
 
 func NewReceiverThread(res chan<- messages.Message, req chan<- *request.Request, conn kadconn.KadReader) *ReceiverThread {
@@ -28,9 +30,14 @@ func NewReceiverThread(res chan<- messages.Message, req chan<- *request.Request,
 		fanoutReply:   res,
 		fanoutRequest: req,
 		conn:          conn,
+		logger:        kadlog.Nop(),
 	}
 }
 
+func (r *ReceiverThread) SetLogger(logger kadlog.Logger) {
+	r.logger = logger
+}
+
 func (r *ReceiverThread) Run(exit <-chan chan error) {
 	receivedMsgs := make([]*readResult, 0)
 	var readDone chan readResult // non-nil channel means we are currently doing IO
@@ -64,6 +71,7 @@ func (r *ReceiverThread) Run(exit <-chan chan error) {
 				}
 				nextRequest = request.New(contact, nextMessage)
 			} else if err != nil {
+				r.logger.Log(kadlog.Warn, "dropping message from unresolvable address", kadlog.F("remote", next.remote), kadlog.F("error", err))
 				receivedMsgs = receivedMsgs[1:]
 			}
 
@@ -83,6 +91,8 @@ func (r *ReceiverThread) Run(exit <-chan chan error) {
 			if result.err == nil {
 				// if no error buffer the message. Otherwise just drop it
 				receivedMsgs = append(receivedMsgs, &result)
+			} else {
+				r.logger.Log(kadlog.Warn, "read failed", kadlog.F("remote", result.remote), kadlog.F("error", result.err))
 			}
 
 		case <-startRead:
@@ -1,21 +1,21 @@
 package kadmux
 
 import (
-	"github.com/alabianca/kadnet/buffers"
+	"github.com/alabianca/gokad"
 	"github.com/alabianca/kadnet/kadconn"
+	"github.com/alabianca/kadnet/kadlog"
 	"github.com/alabianca/kadnet/messages"
 	"github.com/alabianca/kadnet/request"
+	"github.com/alabianca/kadnet/transaction"
 )
 
 type ReplyThread struct {
 	onResponse <-chan messages.Message
 	onRequest  <-chan *request.Request
 	writer     kadconn.KadWriter
-	// buffers
-	nodeReplyBuffer  *buffers.NodeReplyBuffer
-	pingReplyBuffer  *buffers.PingReplyBuffer
-	storeReplyBuffer *buffers.StoreReplyBuffer
-	valueReplyBuffer *buffers.NodeReplyBuffer
+	logger     kadlog.Logger
+	// pending transactions incoming responses are delivered to
+	transactions *transaction.Table
 }
 
 func NewReplyThread(res chan messages.Message, req <-chan *request.Request, writer kadconn.KadWriter) *ReplyThread {
@@ -23,22 +23,16 @@ func NewReplyThread(res chan messages.Message, req <-chan *request.Request, writ
 		onRequest:  req,
 		onResponse: res,
 		writer:     writer,
+		logger:     kadlog.Nop(),
 	}
 }
 
-func (r *ReplyThread) SetBuffers(b map[string]buffers.Buffer) {
-	for k, buf := range b {
-		switch k {
-		case ValueReplyBufferID:
-			r.valueReplyBuffer = buf.(*buffers.NodeReplyBuffer)
-		case PingReplyBufferID:
-			r.pingReplyBuffer = buf.(*buffers.PingReplyBuffer)
-		case StoreReplyBufferID:
-			r.storeReplyBuffer = buf.(*buffers.StoreReplyBuffer)
-		case NodeReplyBufferID:
-			r.nodeReplyBuffer = buf.(*buffers.NodeReplyBuffer)
-		}
-	}
+func (r *ReplyThread) SetLogger(logger kadlog.Logger) {
+	r.logger = logger
+}
+
+func (r *ReplyThread) SetTransactions(t *transaction.Table) {
+	r.transactions = t
 }
 
 func (r *ReplyThread) Run(newWork chan<- WorkRequest, exit <-chan chan error) {
@@ -55,7 +49,7 @@ func (r *ReplyThread) Run(newWork chan<- WorkRequest, exit <-chan chan error) {
 
 		select {
 		case msg := <-r.onResponse:
-			r.tempStoreMsg(msg)
+			r.deliver(msg)
 		case out := <-exit:
 			out <- nil
 			return
@@ -69,31 +63,21 @@ func (r *ReplyThread) Run(newWork chan<- WorkRequest, exit <-chan chan error) {
 	}
 }
 
-func (r *ReplyThread) tempStoreMsg(km messages.Message) {
-	key, _ := km.MultiplexKey()
-	buf := r.getBuffer(key)
-	if buf == nil {
+// deliver hands km to the transaction waiting for it. Responses nobody waits for are dropped
+func (r *ReplyThread) deliver(km messages.Message) {
+	if r.transactions == nil || r.transactions.Deliver(km) {
 		return
 	}
 
-	writer := buf.NewWriter()
-	writer.Write(km)
-}
-
-func (r *ReplyThread) getBuffer(key messages.MessageType) buffers.Buffer {
-	var buf buffers.Buffer
-	switch key {
-	case messages.FindValueResOK, messages.FindValueRes:
-		buf = r.valueReplyBuffer
-	case messages.StoreRes:
-		buf = r.storeReplyBuffer
-	case messages.PingResExplicit:
-		buf = r.pingReplyBuffer
-	case messages.FindNodeRes:
-		buf = r.nodeReplyBuffer
-	}
-
-	return buf
+	key, _ := km.MultiplexKey()
+	sid, _ := km.SenderID()
+	eid, _ := km.EchoRandomID()
+	r.logger.Log(
+		kadlog.Debug,
+		"dropping unmatched response",
+		kadlog.F("type", messageType(key)),
+		kadlog.F("sender", sid.String()),
+		kadlog.F("echo_random_id", gokad.ID(eid).String()))
 }
 
 func (r *ReplyThread) newWorkRequest(req *request.Request) WorkRequest {
@@ -0,0 +1,44 @@
+package kadrand
+
+import (
+	"github.com/alabianca/gokad"
+	"math/rand"
+	"sync"
+)
+
+// RandomSource produces the RandomIDs of messages and the random ids bucket refreshes look up.
+// Implementations must be safe for concurrent use
+type RandomSource interface {
+	ID() gokad.ID
+}
+
+// Crypto returns the default source. Its ids are unpredictable, which keeps others from forging responses
+func Crypto() RandomSource {
+	return cryptoSource{}
+}
+
+type cryptoSource struct{}
+
+func (cryptoSource) ID() gokad.ID {
+	return gokad.GenerateRandomID()
+}
+
+// NewSeeded returns a source that produces the same sequence of ids for the same seed.
+// Its ids are predictable, so it is meant for tests and simulations only
+func NewSeeded(seed int64) RandomSource {
+	return &seededSource{rand: rand.New(rand.NewSource(seed))}
+}
+
+type seededSource struct {
+	mtx  sync.Mutex
+	rand *rand.Rand
+}
+
+func (s *seededSource) ID() gokad.ID {
+	b := make([]byte, gokad.SIZE)
+	s.mtx.Lock()
+	s.rand.Read(b)
+	s.mtx.Unlock()
+
+	return gokad.GenerateID(b)
+}
@@ -1,11 +1,16 @@
 package kadnet
 
 import (
+	"context"
 	"errors"
 	"github.com/alabianca/gokad"
-	"github.com/alabianca/kadnet/buffers"
+	"github.com/alabianca/kadnet/kadclock"
 	"github.com/alabianca/kadnet/messages"
+	"github.com/alabianca/kadnet/mutable"
 	"github.com/alabianca/kadnet/response"
+	"github.com/alabianca/kadnet/transaction"
+	"net"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -24,8 +29,8 @@ var compareDistance = func(d1 gokad.Distance, d2 gokad.Distance) int {
 }
 
 type lookupStrategy interface {
-	round(nextNodes []*pendingNode, timeouts chan<-findXResult) chan findXResult
-	send(node *pendingNode) chan findXResult
+	round(ctx context.Context, nextNodes []*pendingNode, timeouts chan<- findXResult) chan findXResult
+	send(ctx context.Context, node *pendingNode) chan findXResult
 	messageTypeId() int
 	setLookupKey(key gokad.ID)
 }
@@ -55,6 +60,8 @@ func (p *pendingNode) SetQueried(x bool) {
 type findXResultPayload struct {
 	key string
 	contacts []gokad.Contact
+	data []byte
+	record *mutable.Record
 }
 
 type findXResult struct {
@@ -64,8 +71,32 @@ type findXResult struct {
 	err      error
 }
 
+// LookupOptions overrides the lookup settings of a node for a single lookup.
+// Zero values fall back to the node's K, Alpha and RoundTimeout
+type LookupOptions struct {
+	// K is the number of closest contacts the lookup converges on and returns
+	K int
+	// Alpha is the number of FIND_X_RPC's sent in parallel per round
+	Alpha int
+	// RoundTimeout is how long to wait for a reply before a contact is considered timed out for the round
+	RoundTimeout time.Duration
+	// Deadline is when the whole lookup gives up. The earlier of Deadline and the deadline of the context is used
+	Deadline time.Time
+	// MaxRounds stops the lookup after that many rounds. 0 means no limit
+	MaxRounds int
+	// Exclude holds ids of contacts that are never queried or returned
+	Exclude []gokad.ID
+	// DisjointPaths splits the lookup into that many shortlists that never share a contact, as in S/Kademlia.
+	// A malicious contact can then only mislead the path it is part of. 0 and 1 mean a single shortlist
+	DisjointPaths int
+	// Confirmations is how many paths of a disjoint lookup must find a value for it to count. It defaults to 2
+	Confirmations int
+	// AllAddresses returns a node once for every address family it is known by, so a dual-stack node
+	// is returned with its IPv4 and its IPv6 contact. Otherwise every node is returned once
+	AllAddresses bool
+}
+
 type lookup struct {
-	buffer       buffers.Buffer
 	concurrency  int
 	k            int
 	dht          *dhtProxy
@@ -73,6 +104,21 @@ type lookup struct {
 	roundTimeout time.Duration
 	strategy lookupStrategy
 	isNodeLookup bool
+	// isDataLookup looks for an opaque value with FIND_DATA_RPC's instead of providers
+	isDataLookup bool
+	// isMutableLookup looks for signed mutable records with FIND_MUTABLE_RPC's and asks all k closest nodes
+	isMutableLookup bool
+	events       *eventBus
+	// clock is the clock Deadline passes on. Round timeouts pass on the clock of the client's transactions
+	clock        kadclock.Clock
+	deadline     time.Time
+	maxRounds    int
+	exclude      map[string]bool
+	// paths is the number of disjoint shortlists. A value needs to be found on confirmations of them
+	paths         int
+	confirmations int
+	// allAddresses adds the contacts of other address families to the nodes a node lookup returns
+	allAddresses bool
 }
 
 type lookupConfig func(l *lookup)
@@ -82,16 +128,13 @@ func nodeLookup(config lookupConfig) (*lookup, error) {
 		concurrency:  3,
 		k:            20,
 		roundTimeout: time.Second * 3,
+		clock:        kadclock.Real(),
 	}
 
 	config(&lp)
 	if lp.dht == nil {
 		return nil, errors.New("dht not specified")
 	}
-	if lp.buffer == nil {
-		return nil, errors.New("node reply buffer not specified")
-	}
-
 	if lp.client == nil {
 		return nil, errors.New("client not specified")
 	}
@@ -99,16 +142,23 @@ func nodeLookup(config lookupConfig) (*lookup, error) {
 	var strategy lookupStrategy
 	if lp.isNodeLookup {
 		strategy = &findNodeStrategy{
-			nodeReplyBuffer: lp.buffer,
 			concurrency:     lp.concurrency,
 			k:               lp.k,
 			dht:             lp.dht,
 			client:          lp.client,
 			roundTimeout:    lp.roundTimeout,
 		}
+	} else if lp.isDataLookup || lp.isMutableLookup {
+		strategy = &findDataStrategy{
+			concurrency:  lp.concurrency,
+			k:            lp.k,
+			dht:          lp.dht,
+			client:       lp.client,
+			roundTimeout: lp.roundTimeout,
+			mutable:      lp.isMutableLookup,
+		}
 	} else {
 		strategy = &findValueStrategy{
-			valueReplyBuffer: lp.buffer,
 			concurrency:      lp.concurrency,
 			k:                lp.k,
 			dht:              lp.dht,
@@ -121,57 +171,245 @@ func nodeLookup(config lookupConfig) (*lookup, error) {
 	return &lp, nil
 }
 
-func (l *lookup) do(key gokad.ID) ([]gokad.Contact, error) {
-	buf := l.buffer
-	if buf == nil {
-		return nil, errors.New("cannot open Node Reply Buffer <nil>")
+// withOptions returns a copy of l with the overrides of opts applied
+func (l *lookup) withOptions(opts LookupOptions) (*lookup, error) {
+	return nodeLookup(func(lp *lookup) {
+		lp.concurrency = l.concurrency
+		lp.k = l.k
+		lp.dht = l.dht
+		lp.client = l.client
+		lp.roundTimeout = l.roundTimeout
+		lp.isNodeLookup = l.isNodeLookup
+		lp.isDataLookup = l.isDataLookup
+		lp.isMutableLookup = l.isMutableLookup
+		lp.events = l.events
+		lp.clock = l.clock
+		if opts.K > 0 {
+			lp.k = opts.K
+		}
+		if opts.Alpha > 0 {
+			lp.concurrency = opts.Alpha
+		}
+		if opts.RoundTimeout > 0 {
+			lp.roundTimeout = opts.RoundTimeout
+		}
+		lp.deadline = opts.Deadline
+		lp.maxRounds = opts.MaxRounds
+		lp.paths = opts.DisjointPaths
+		lp.confirmations = opts.Confirmations
+		lp.allAddresses = opts.AllAddresses
+		if len(opts.Exclude) > 0 {
+			lp.exclude = make(map[string]bool, len(opts.Exclude))
+			for _, id := range opts.Exclude {
+				lp.exclude[id.String()] = true
+			}
+		}
+	})
+}
+
+func (l *lookup) excluded(c gokad.Contact) bool {
+	return l.exclude[c.ID.String()]
+}
+
+// lookupResult holds what a lookup found. Data lookups only fill data, mutable lookups only fill records
+type lookupResult struct {
+	contacts []gokad.Contact
+	// data holds every value returned in the round the key was found in, once for every node that returned it
+	data [][]byte
+	// records holds every mutable record returned by the k closest nodes
+	records []mutable.Record
+	// rounds is the number of rounds the lookup took. Disjoint lookups report their longest path
+	rounds int
+}
+
+func (l *lookup) do(ctx context.Context, key gokad.ID) ([]gokad.Contact, error) {
+	res, err := l.find(ctx, key)
+	if l.isNodeLookup && l.allAddresses {
+		return l.dht.addressesOf(res.contacts), err
 	}
-	// the buffer must be opened
-	// once opened it is ready to receive answers to FIND_NODE_RPC's
-	buf.Open()
-	defer buf.Close()
 
-	concurrency := l.concurrency
-	closestNodes := newMap(compareDistance)
-	for _, c := range l.dht.getAlphaNodes(concurrency, key) {
-		closestNodes.Insert(key.DistanceTo(c.ID), &pendingNode{contact: c})
+	return res.contacts, err
+}
+
+// find is like do but returns everything the lookup found
+func (l *lookup) find(ctx context.Context, key gokad.ID) (lookupResult, error) {
+	l.events.publish(Event{Type: LookupStarted, Key: key})
+	res, err := l.run(ctx, key)
+	l.events.publish(Event{Type: LookupFinished, Key: key, Found: len(res.contacts) + len(res.data), Rounds: res.rounds, Err: err})
+
+	return res, err
+}
+
+func (l *lookup) run(ctx context.Context, key gokad.ID) (lookupResult, error) {
+	// every goroutine started by this lookup is bound to ctx.
+	// cancelling it on return releases pending reads and late replies.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if !l.deadline.IsZero() {
+		ctx, cancel = kadclock.WithDeadline(ctx, l.clock, l.deadline)
+		defer cancel()
+	}
+
+	// a lookup for key counts as a use of the k-bucket key falls into
+	l.dht.touch(key)
+
+	l.strategy.setLookupKey(key)
+
+	paths := l.paths
+	if paths < 1 {
+		paths = 1
+	}
+
+	// every path starts with alpha of the closest known contacts. They are dealt out like cards,
+	// so every path gets some of the closest ones
+	shortlists := make([]*treeMap, paths)
+	for i := range shortlists {
+		shortlists[i] = newMap(compareDistance)
+	}
+	var seeded int
+	for _, c := range l.dht.getAlphaNodes(l.concurrency*paths+len(l.exclude), key) {
+		if seeded == l.concurrency*paths {
+			break
+		}
+		if l.excluded(c) {
+			continue
+		}
+		shortlists[seeded%paths].Insert(key.DistanceTo(c.ID), &pendingNode{contact: c})
+		seeded++
+	}
+
+	if paths == 1 {
+		res, err := l.walk(ctx, key, shortlists[0], nil)
+		if err != nil {
+			return lookupResult{}, err
+		}
+
+		if res.found && !l.isNodeLookup {
+			return lookupResult{contacts: res.values, data: res.data, records: res.records, rounds: res.rounds}, nil
+		} else if (!l.isNodeLookup) {
+			return lookupResult{contacts: res.values, rounds: res.rounds}, errors.New("not found")
+		}
+
+		return lookupResult{contacts: getKClosestNodes(res.closest, l.k), rounds: res.rounds}, nil
+	}
+
+	owners := newClaims(key, shortlists)
+	results := make([]pathResult, paths)
+	errs := make([]error, paths)
+	var wg sync.WaitGroup
+	wg.Add(paths)
+	for i := range shortlists {
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = l.walk(ctx, key, shortlists[i], func(c gokad.Contact) bool {
+				return owners.claim(i, c)
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return lookupResult{}, err
+		}
+	}
+
+	res, err := l.combine(key, results)
+	for _, r := range results {
+		if r.rounds > res.rounds {
+			res.rounds = r.rounds
+		}
 	}
 
+	return res, err
+}
+
+// pathResult is what a single shortlist of a lookup found
+type pathResult struct {
+	found   bool
+	values  []gokad.Contact
+	data    [][]byte
+	records []mutable.Record
+	closest *treeMap
+	rounds  int
+}
+
+// walk runs rounds of FIND_X_RPC's over closestNodes until the lookup converged or found the key.
+// Contacts learned on the way are only added to closestNodes if claim allows it. nil allows every contact
+func (l *lookup) walk(ctx context.Context, key gokad.ID, closestNodes *treeMap, claim func(c gokad.Contact) bool) (pathResult, error) {
+	concurrency := l.concurrency
 	strategy := l.strategy
-	strategy.setLookupKey(key)
 	timedOutNodes := make(chan findXResult)
-	lateReplies := losers(timedOutNodes, strategy.messageTypeId())
+	lateReplies := losers(ctx, timedOutNodes, strategy.messageTypeId())
 	next := make([]*pendingNode, concurrency)
 	var foundValue bool
 	var value []gokad.Contact
+	var data [][]byte
+	var records []mutable.Record
+	var rounds int
 	for nextRound(closestNodes, concurrency, next, l.k) {
-		rc := strategy.round(trim(next), timedOutNodes)
+		if l.maxRounds > 0 && rounds == l.maxRounds {
+			break
+		}
+		rounds++
+		rc := strategy.round(ctx, trim(next), timedOutNodes)
 
 		var atLeastOneNewNode bool
-		for cs := range mergeLosersAndRound(lateReplies, rc) {
+		for cs := range mergeLosersAndRound(ctx, lateReplies, rc) {
 			if cs.err != nil {
 				continue
 			}
 
+			// several nodes of the round may hold providers for the key. all of them are collected
 			if cs.payload.key != "" {
 				foundValue = true
-				value = cs.payload.contacts
-				break
+				value = append(value, cs.payload.contacts...)
+				if cs.payload.data != nil {
+					data = append(data, cs.payload.data)
+				}
+				if cs.payload.record != nil {
+					records = append(records, *cs.payload.record)
+				}
+				continue
 			}
 
 			cs.node.SetAnswered(true)
+			// with identities, the node that answered is inserted once it proved its id
+			l.dht.requestProof(cs.node.contact)
 			for _, c := range cs.payload.contacts {
+				if l.excluded(c) {
+					continue
+				}
+				// with identities, ids that do not solve the puzzle are never contacted
+				insert, contact := l.dht.acceptsUnproven(c)
+				if !contact {
+					continue
+				}
+				// contacts of a disjoint lookup belong to the path that learned them first
 				distance := key.DistanceTo(c.ID)
-				if _, ok := closestNodes.Get(distance); !ok {
+				if _, ok := closestNodes.Get(distance); !ok && (claim == nil || claim(c)) {
 					atLeastOneNewNode = true
 					closestNodes.Insert(distance, &pendingNode{contact: c})
 				}
 				// contact details of any node that responded are attempted to be
-				// inserted into the dht
-				l.dht.insert(c)
+				// inserted into the dht. With identities they are pinged and inserted once they proved their id
+				if insert {
+					l.dht.insert(c)
+				} else {
+					l.dht.requestProof(c)
+				}
 			}
 		}
 
+		if err := ctx.Err(); err != nil {
+			return pathResult{}, err
+		}
+
+		// nodes may hold different sequences of a mutable record. the lookup goes on to ask all k closest
+		if foundValue && !l.isMutableLookup {
+			break
+		}
+
 		// if a round did not reveal at least one new node we take all K
 		// closest nodes not already queried and send them FIND_NODE_RPC's
 		if !atLeastOneNewNode {
@@ -183,18 +421,126 @@ func (l *lookup) do(key gokad.ID) ([]gokad.Contact, error) {
 		next = make([]*pendingNode, concurrency)
 	}
 
-	if foundValue && !l.isNodeLookup {
-		return value, nil
-	} else if (!l.isNodeLookup) {
-		return value, errors.New("not found")
+	return pathResult{found: foundValue, values: value, data: data, records: records, closest: closestNodes, rounds: rounds}, nil
+}
+
+// combine merges the results of the paths of a disjoint lookup. A node lookup returns the k closest contacts
+// of all paths. A value lookup only returns values that were found on at least l.confirmations paths
+func (l *lookup) combine(key gokad.ID, results []pathResult) (lookupResult, error) {
+	if l.isNodeLookup {
+		closest := newMap(compareDistance)
+		for _, r := range results {
+			for _, c := range getKClosestNodes(r.closest, l.k) {
+				closest.Insert(key.DistanceTo(c.ID), &pendingNode{contact: c})
+			}
+		}
+
+		return lookupResult{contacts: getKClosestNodes(closest, l.k)}, nil
+	}
+
+	need := l.confirmations
+	if need <= 0 {
+		need = 2
+	}
+	if need > len(results) {
+		need = len(results)
+	}
+
+	// a path counts once for every value, however many of its nodes returned it
+	counts := make(map[string]int)
+	for _, r := range results {
+		seen := make(map[string]bool)
+		r.each(func(k string, _ int) {
+			if !seen[k] {
+				seen[k] = true
+				counts[k]++
+			}
+		})
 	}
 
-	return getKClosestNodes(closestNodes, l.k), nil
+	var res lookupResult
+	added := make(map[string]bool)
+	for _, r := range results {
+		r.each(func(k string, i int) {
+			if counts[k] < need {
+				return
+			}
+			// every copy of a value is kept, so the caller can tell which one most nodes returned
+			if k[0] == 'd' {
+				res.data = append(res.data, r.data[i])
+				return
+			}
+			if added[k] {
+				return
+			}
+			added[k] = true
+			switch k[0] {
+			case 'c':
+				res.contacts = append(res.contacts, r.values[i])
+			case 'd':
+				res.data = append(res.data, r.data[i])
+			case 'r':
+				res.records = append(res.records, r.records[i])
+			}
+		})
+	}
+
+	if len(res.contacts)+len(res.data)+len(res.records) == 0 {
+		return lookupResult{}, errors.New("not found")
+	}
+
+	return res, nil
+}
+
+// each calls f with a key identifying every value r found and its index
+func (r pathResult) each(f func(k string, i int)) {
+	for i, c := range r.values {
+		f("c"+net.JoinHostPort(c.IP.String(), strconv.Itoa(c.Port)), i)
+	}
+	for i, d := range r.data {
+		f("d"+string(d), i)
+	}
+	for i, rec := range r.records {
+		f("r"+string(rec.Encode()), i)
+	}
+}
+
+// claims keeps every contact of a disjoint lookup to the path that learned it first
+type claims struct {
+	mtx   sync.Mutex
+	owner map[string]int
+}
+
+// newClaims gives every contact in the shortlists to the path it was seeded to
+func newClaims(key gokad.ID, shortlists []*treeMap) *claims {
+	c := &claims{owner: make(map[string]int)}
+	for i, shortlist := range shortlists {
+		shortlist.Traverse(func(_ gokad.Distance, node *pendingNode) bool {
+			c.owner[node.contact.ID.String()] = i
+			return true
+		})
+	}
+
+	return c
+}
+
+// claim reports if path may use contact. The first path to ask for a contact gets it
+func (c *claims) claim(path int, contact gokad.Contact) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	id := contact.ID.String()
+	owner, ok := c.owner[id]
+	if !ok {
+		c.owner[id] = path
+		return true
+	}
+
+	return owner == path
 }
 
 
 type findNodeStrategy struct {
-	nodeReplyBuffer buffers.Buffer
 	concurrency     int
 	k               int
 	dht             *dhtProxy
@@ -211,7 +557,7 @@ func (str *findNodeStrategy) setLookupKey(key gokad.ID) {
 	str.key = key
 }
 
-func (str *findNodeStrategy) round(nodes []*pendingNode, timeouts chan<- findXResult) chan findXResult {
+func (str *findNodeStrategy) round(ctx context.Context, nodes []*pendingNode, timeouts chan<- findXResult) chan findXResult {
 	out := make(chan findXResult)
 	var wg sync.WaitGroup
 	wg.Add(len(nodes))
@@ -219,11 +565,17 @@ func (str *findNodeStrategy) round(nodes []*pendingNode, timeouts chan<- findXRe
 	for _, n := range nodes {
 		go func(node *pendingNode) {
 			defer wg.Done()
-			res := <- str.send(node)
-			if res.err != nil && res.err.Error() == buffers.TimeoutErr {
-				timeouts <- res
+			res := <-str.send(ctx, node)
+			if res.err != nil && res.err.Error() == transaction.TimeoutErr {
+				select {
+				case timeouts <- res:
+				case <-ctx.Done():
+				}
 			} else {
-				out <- res
+				select {
+				case out <- res:
+				case <-ctx.Done():
+				}
 			}
 		}(n)
 	}
@@ -236,12 +588,12 @@ func (str *findNodeStrategy) round(nodes []*pendingNode, timeouts chan<- findXRe
 	return out
 }
 
-func (str *findNodeStrategy) send(node *pendingNode) chan findXResult {
+func (str *findNodeStrategy) send(ctx context.Context, node *pendingNode) chan findXResult {
 	out := make(chan findXResult)
 
 	go func() {
 		defer close(out)
-		res, err := str.client.FindNode(node.Contact(), str.key)
+		res, err := str.client.FindNodeContext(ctx, node.Contact(), str.key)
 		if err != nil {
 			out <- findXResult{node, findXResultPayload{}, res, err}
 			return
@@ -265,7 +617,6 @@ func (str *findNodeStrategy) send(node *pendingNode) chan findXResult {
 }
 
 type findValueStrategy struct {
-	valueReplyBuffer buffers.Buffer
 	concurrency     int
 	k               int
 	dht             *dhtProxy
@@ -282,7 +633,7 @@ func (v *findValueStrategy) setLookupKey(key gokad.ID) {
 	v.key = key
 }
 
-func (v *findValueStrategy) round(nodes []*pendingNode, timeouts chan<- findXResult) chan findXResult {
+func (v *findValueStrategy) round(ctx context.Context, nodes []*pendingNode, timeouts chan<- findXResult) chan findXResult {
 	out := make(chan findXResult)
 	var wg sync.WaitGroup
 	wg.Add(len(nodes))
@@ -290,11 +641,17 @@ func (v *findValueStrategy) round(nodes []*pendingNode, timeouts chan<- findXRes
 	for _, n := range nodes {
 		go func(node *pendingNode) {
 			defer wg.Done()
-			res := <-v.send(node)
-			if res.err != nil && res.err.Error() == buffers.TimeoutErr {
-				timeouts <- res
+			res := <-v.send(ctx, node)
+			if res.err != nil && res.err.Error() == transaction.TimeoutErr {
+				select {
+				case timeouts <- res:
+				case <-ctx.Done():
+				}
 			} else {
-				out <- res
+				select {
+				case out <- res:
+				case <-ctx.Done():
+				}
 			}
 		}(n)
 	}
@@ -307,12 +664,12 @@ func (v *findValueStrategy) round(nodes []*pendingNode, timeouts chan<- findXRes
 	return out
 }
 
-func (v *findValueStrategy) send(node *pendingNode) chan findXResult {
+func (v *findValueStrategy) send(ctx context.Context, node *pendingNode) chan findXResult {
 	out := make(chan findXResult)
 
 	go func() {
 		defer close(out)
-		res, err := v.client.FindValue(node.Contact(), v.key.String())
+		res, err := v.client.FindValueContext(ctx, node.Contact(), v.key.String())
 		if err != nil {
 			out <- findXResult{node, findXResultPayload{}, res, err}
 			return
@@ -336,6 +693,124 @@ func (v *findValueStrategy) send(node *pendingNode) chan findXResult {
 	return out
 }
 
+type findDataStrategy struct {
+	concurrency  int
+	k            int
+	dht          *dhtProxy
+	client       *Client
+	roundTimeout time.Duration
+	key          gokad.ID
+	// mutable sends FIND_MUTABLE_RPC's instead of FIND_DATA_RPC's
+	mutable bool
+}
+
+func (d *findDataStrategy) messageTypeId() int {
+	if d.mutable {
+		return 4
+	}
+	return 3
+}
+
+func (d *findDataStrategy) setLookupKey(key gokad.ID) {
+	d.key = key
+}
+
+func (d *findDataStrategy) round(ctx context.Context, nodes []*pendingNode, timeouts chan<- findXResult) chan findXResult {
+	out := make(chan findXResult)
+	var wg sync.WaitGroup
+	wg.Add(len(nodes))
+
+	for _, n := range nodes {
+		go func(node *pendingNode) {
+			defer wg.Done()
+			res := <-d.send(ctx, node)
+			if res.err != nil && res.err.Error() == transaction.TimeoutErr {
+				select {
+				case timeouts <- res:
+				case <-ctx.Done():
+				}
+			} else {
+				select {
+				case out <- res:
+				case <-ctx.Done():
+				}
+			}
+		}(n)
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
+func (d *findDataStrategy) send(ctx context.Context, node *pendingNode) chan findXResult {
+	out := make(chan findXResult)
+
+	go func() {
+		defer close(out)
+		if d.mutable {
+			out <- d.findMutable(ctx, node)
+			return
+		}
+
+		res, err := d.client.FindDataContext(ctx, node.Contact(), d.key.String())
+		if err != nil {
+			out <- findXResult{node, findXResultPayload{}, res, err}
+			return
+		}
+
+		var fdr messages.FindDataResponse
+		res.ReadTimeout(d.roundTimeout)
+		rerr := read(res, &fdr)
+		out <- findXResult{
+			node: node,
+			payload: findXResultPayload{
+				key:      fdr.Payload.Key,
+				contacts: fdr.Payload.Contacts,
+				data:     fdr.Payload.Data,
+			},
+			response: res,
+			err:      rerr,
+		}
+	}()
+
+	return out
+}
+
+func (d *findDataStrategy) findMutable(ctx context.Context, node *pendingNode) findXResult {
+	res, err := d.client.FindMutableContext(ctx, node.Contact(), d.key.String())
+	if err != nil {
+		return findXResult{node, findXResultPayload{}, res, err}
+	}
+
+	var fmr messages.FindMutableResponse
+	res.ReadTimeout(d.roundTimeout)
+	rerr := read(res, &fmr)
+	return findXResult{
+		node:     node,
+		payload:  mutablePayload(&fmr),
+		response: res,
+		err:      rerr,
+	}
+}
+
+// mutablePayload returns the payload of fmr. The record is only set if fmr holds one
+func mutablePayload(fmr *messages.FindMutableResponse) findXResultPayload {
+	p := findXResultPayload{
+		key:      fmr.Payload.Key,
+		contacts: fmr.Payload.Contacts,
+	}
+	if p.key != "" {
+		record := fmr.Payload.Record
+		p.record = &record
+	}
+
+	return p
+}
+
 func getKClosestNodes(m *treeMap, K int) []gokad.Contact {
 	var index int
 	out := make([]gokad.Contact, 0)
@@ -345,6 +820,7 @@ func getKClosestNodes(m *treeMap, K int) []gokad.Contact {
 		}
 
 		out = append(out, v.contact)
+		index++
 
 		return true
 	})
@@ -380,12 +856,19 @@ func nextRound(m *treeMap, max int, nodes []*pendingNode, K int) bool {
 	return false
 }
 
-func losers(in <-chan findXResult, mkey int) chan findXResult {
+func losers(ctx context.Context, in <-chan findXResult, mkey int) chan findXResult {
 	out := make(chan findXResult)
 	go func() {
-		for res := range in {
+		for {
+			var res findXResult
+			select {
+			case <-ctx.Done():
+				return
+			case res = <-in:
+			}
+
 			// Note: We now read without a timeout until
-			// the buffer is closed and push responses into it
+			// the transaction expires or the lookup is done and push responses into it
 			go func() {
 				var km messages.KademliaMessage
 				switch mkey {
@@ -393,6 +876,10 @@ func losers(in <-chan findXResult, mkey int) chan findXResult {
 					km = &messages.FindNodeResponse{}
 				case 2:
 					km = &messages.FindValueResponse{}
+				case 3:
+					km = &messages.FindDataResponse{}
+				case 4:
+					km = &messages.FindMutableResponse{}
 				}
 
 				err := read(res.response, km)
@@ -401,14 +888,23 @@ func losers(in <-chan findXResult, mkey int) chan findXResult {
 				case *messages.FindValueResponse:
 					p.key = v.Payload.Key
 					p.contacts = v.Payload.Contacts
+				case *messages.FindDataResponse:
+					p.key = v.Payload.Key
+					p.data = v.Payload.Data
+					p.contacts = v.Payload.Contacts
+				case *messages.FindMutableResponse:
+					p = mutablePayload(v)
 				case *messages.FindNodeResponse:
 					p.contacts = v.Payload
 				}
-				out <- findXResult{
+				select {
+				case out <- findXResult{
 					node:     res.node,
 					payload:  p,
 					response: res.response,
 					err:      err,
+				}:
+				case <-ctx.Done():
 				}
 			}()
 		}
@@ -424,7 +920,7 @@ func read(res *response.Response, km messages.KademliaMessage) error {
 	return err
 }
 
-func mergeLosersAndRound(losers, round <-chan findXResult) chan findXResult {
+func mergeLosersAndRound(ctx context.Context, losers, round <-chan findXResult) chan findXResult {
 	out := make(chan findXResult)
 	var wg sync.WaitGroup
 	wg.Add(1)
@@ -432,14 +928,23 @@ func mergeLosersAndRound(losers, round <-chan findXResult) chan findXResult {
 	go func() {
 		defer wg.Done()
 		for {
+			var next findXResult
 			select {
 			case res, ok := <-round:
 				if !ok {
 					return
 				}
-				out <- res
+				next = res
 			case res := <-losers:
-				out <- res
+				next = res
+			case <-ctx.Done():
+				return
+			}
+
+			select {
+			case out <- next:
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
@@ -0,0 +1,67 @@
+package kadnet
+
+import (
+	"context"
+	"time"
+)
+
+// maintainer keeps the routing table up to date while the node is listening.
+// Every k-bucket that has not been used for longer than refreshInterval (tRefresh)
+// is refreshed by a node lookup for a random id in its range.
+type maintainer struct {
+	node            *Node
+	refreshInterval time.Duration
+	checkInterval   time.Duration
+}
+
+func newMaintainer(n *Node) *maintainer {
+	return &maintainer{
+		node:            n,
+		refreshInterval: n.RefreshInterval,
+		checkInterval:   n.RefreshCheckInterval,
+	}
+}
+
+// Run checks for stale buckets every checkInterval until it receives on exit.
+// A refresh that is in progress is cancelled before Run returns
+func (m *maintainer) Run(exit <-chan chan error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ticker := m.node.Clock.NewTicker(m.checkInterval)
+	defer ticker.Stop()
+
+	var refreshing chan struct{} // non-nil channel means a refresh is currently running
+	for {
+		select {
+		case out := <-exit:
+			cancel()
+			if refreshing != nil {
+				<-refreshing
+			}
+			out <- nil
+			return
+
+		case <-ticker.C():
+			if refreshing != nil {
+				continue
+			}
+			refreshing = make(chan struct{})
+			go func(done chan struct{}) {
+				defer close(done)
+				m.refresh(ctx)
+			}(refreshing)
+
+		case <-refreshing:
+			refreshing = nil
+		}
+	}
+}
+
+func (m *maintainer) refresh(ctx context.Context) {
+	for _, index := range m.node.dht.staleBuckets(m.refreshInterval) {
+		if ctx.Err() != nil {
+			return
+		}
+
+		m.node.LookupContext(ctx, randomIDInBucket(m.node.Random, m.node.ID(), index))
+	}
+}
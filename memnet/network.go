@@ -0,0 +1,342 @@
+package memnet
+
+import (
+	"errors"
+	"github.com/alabianca/kadnet/kadclock"
+	"github.com/alabianca/kadnet/kadconn"
+	"github.com/alabianca/kadnet/messages"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const ErrAddressInUse = "address already in use"
+
+// inboxSize is the number of datagrams a conn buffers. Datagrams arriving at a full inbox are dropped
+const inboxSize = 1024
+
+// Network is a virtual switch between the KadConns it hands out with Listen.
+// Datagrams are copied between conns in memory. Latency, jitter, loss, reordering and partitions
+// can be changed at any time and apply to datagrams written afterwards
+type Network struct {
+	mtx   sync.Mutex
+	conns map[string]*conn
+	clock kadclock.Clock
+	seed  int64
+	// links holds a random source for every pair of addresses that exchanged datagrams.
+	// The fate of a datagram only depends on the seed and the datagrams sent over the same link before it
+	links map[string]*link
+	// pending holds the datagrams waiting for their latency to pass, ordered by due time, sender, receiver
+	// and the order they were sent on their link. Datagrams due at the same time are delivered in that order,
+	// however the goroutines that sent them were scheduled
+	pending []*delayed
+	// latency is the delay of every datagram. jitter adds up to that much on top
+	latency time.Duration
+	jitter  time.Duration
+	// loss is the probability that a datagram is dropped
+	loss float64
+	// reorder is the probability that a datagram is held back for another latency+jitter, so later ones overtake it
+	reorder float64
+	// partition maps addresses to the group they are in. Datagrams only pass between addresses of the same group.
+	// Addresses without a group reach each other
+	partition map[string]int
+	stats     struct{ sent, delivered, dropped uint64 }
+}
+
+// Stats counts the datagrams of a Network
+type Stats struct {
+	// Sent is the number of datagrams written to conns of the network
+	Sent uint64
+	// Delivered is the number of datagrams that reached the inbox of their destination
+	Delivered uint64
+	// Dropped is the number of datagrams lost, cut off by a partition, sent to no one or to a full inbox
+	Dropped uint64
+}
+
+func NewNetwork() *Network {
+	return NewSeededNetwork(time.Now().UnixNano())
+}
+
+// NewSeededNetwork returns a Network whose loss, jitter and reordering are reproducible from seed
+func NewSeededNetwork(seed int64) *Network {
+	return &Network{
+		conns: make(map[string]*conn),
+		clock: kadclock.Real(),
+		seed:  seed,
+		links: make(map[string]*link),
+	}
+}
+
+// Stats returns the number of datagrams sent, delivered and dropped so far
+func (n *Network) Stats() Stats {
+	return Stats{
+		Sent:      atomic.LoadUint64(&n.stats.sent),
+		Delivered: atomic.LoadUint64(&n.stats.delivered),
+		Dropped:   atomic.LoadUint64(&n.stats.dropped),
+	}
+}
+
+// SetClock lets latency pass on clock. Datagrams already in flight are delivered on the old clock
+func (n *Network) SetClock(clock kadclock.Clock) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	n.clock = clock
+}
+
+// SetLatency delays every datagram by latency plus a random duration of up to jitter
+func (n *Network) SetLatency(latency, jitter time.Duration) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	n.latency = latency
+	n.jitter = jitter
+}
+
+// SetLoss drops every datagram with probability p
+func (n *Network) SetLoss(p float64) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	n.loss = p
+}
+
+// SetReorder holds back every datagram with probability p, so datagrams written after it arrive first
+func (n *Network) SetReorder(p float64) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	n.reorder = p
+}
+
+// Partition splits the network into groups. Addresses in different groups cannot reach each other.
+// Addresses not in any group can reach everyone that is not in a group either
+func (n *Network) Partition(groups ...[]string) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	n.partition = make(map[string]int)
+	for i, group := range groups {
+		for _, addr := range group {
+			n.partition[addr] = i + 1
+		}
+	}
+}
+
+// Heal removes all partitions
+func (n *Network) Heal() {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	n.partition = nil
+}
+
+// Listen returns a KadConn reachable at addr. addr is a host:port pair like "127.0.0.1:5000"
+func (n *Network) Listen(addr string) (kadconn.KadConn, error) {
+	local, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	if _, ok := n.conns[local.String()]; ok {
+		return nil, errors.New(ErrAddressInUse)
+	}
+
+	c := &conn{
+		network: n,
+		local:   local,
+		inbox:   make(chan datagram, inboxSize),
+		closed:  make(chan struct{}),
+	}
+	n.conns[local.String()] = c
+
+	return c, nil
+}
+
+// send delivers p from from to to unless the network drops it
+func (n *Network) send(p []byte, from, to net.Addr) {
+	atomic.AddUint64(&n.stats.sent, 1)
+	n.mtx.Lock()
+	l := n.link(from.String(), to.String())
+	r := l.rand
+	dst, ok := n.conns[to.String()]
+	if !ok || n.partition[from.String()] != n.partition[to.String()] || r.Float64() < n.loss {
+		n.mtx.Unlock()
+		atomic.AddUint64(&n.stats.dropped, 1)
+		return
+	}
+
+	delay := n.delay(r)
+	if r.Float64() < n.reorder {
+		delay += n.delay(r)
+	}
+	clock := n.clock
+	d := datagram{p: append([]byte(nil), p...), from: from}
+	if delay <= 0 {
+		n.mtx.Unlock()
+		dst.deliver(d)
+		return
+	}
+
+	l.sent++
+	n.queue(&delayed{at: clock.Now().Add(delay), from: from.String(), to: to.String(), seq: l.sent, d: d, dst: dst})
+	n.mtx.Unlock()
+
+	clock.AfterFunc(delay, n.deliverNext)
+}
+
+// queue adds d to the pending datagrams. n.mtx must be held
+func (n *Network) queue(d *delayed) {
+	i := sort.Search(len(n.pending), func(i int) bool {
+		return d.before(n.pending[i])
+	})
+	n.pending = append(n.pending, nil)
+	copy(n.pending[i+1:], n.pending[i:])
+	n.pending[i] = d
+}
+
+// deliverNext delivers the first pending datagram. Every delayed datagram schedules one call
+func (n *Network) deliverNext() {
+	n.mtx.Lock()
+	if len(n.pending) == 0 {
+		n.mtx.Unlock()
+		return
+	}
+	d := n.pending[0]
+	n.pending = n.pending[1:]
+	n.mtx.Unlock()
+
+	d.dst.deliver(d.d)
+}
+
+// delay returns the latency of a datagram. n.mtx must be held
+func (n *Network) delay(r *rand.Rand) time.Duration {
+	delay := n.latency
+	if n.jitter > 0 {
+		delay += time.Duration(r.Int63n(int64(n.jitter)))
+	}
+
+	return delay
+}
+
+// link returns the link of datagrams from from to to. n.mtx must be held
+func (n *Network) link(from, to string) *link {
+	key := from + ">" + to
+	if l, ok := n.links[key]; ok {
+		return l
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	l := &link{rand: rand.New(rand.NewSource(n.seed ^ int64(h.Sum64())))}
+	n.links[key] = l
+
+	return l
+}
+
+func (n *Network) remove(c *conn) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	if n.conns[c.local.String()] == c {
+		delete(n.conns, c.local.String())
+	}
+}
+
+type datagram struct {
+	p    []byte
+	from net.Addr
+}
+
+// link is the direction from one address to another
+type link struct {
+	rand *rand.Rand
+	// sent is the number of delayed datagrams sent over the link
+	sent uint64
+}
+
+// delayed is a datagram waiting for its latency to pass
+type delayed struct {
+	at       time.Time
+	from, to string
+	seq      uint64
+	d        datagram
+	dst      *conn
+}
+
+// before reports whether d is delivered before other
+func (d *delayed) before(other *delayed) bool {
+	if !d.at.Equal(other.at) {
+		return d.at.Before(other.at)
+	}
+	if d.from != other.from {
+		return d.from < other.from
+	}
+	if d.to != other.to {
+		return d.to < other.to
+	}
+
+	return d.seq < other.seq
+}
+
+// conn is a KadConn attached to a Network
+type conn struct {
+	network   *Network
+	local     *net.UDPAddr
+	inbox     chan datagram
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *conn) Next() (messages.Message, net.Addr, error) {
+	select {
+	case d := <-c.inbox:
+		if !messages.IsValid(messages.MessageType(d.p[0])) {
+			return nil, d.from, errors.New(kadconn.ErrInvalidMessageType)
+		}
+
+		m, err := messages.Process(d.p)
+		return m, d.from, err
+	case <-c.closed:
+		return nil, nil, net.ErrClosed
+	}
+}
+
+func (c *conn) Write(p []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+
+	if len(p) > 0 {
+		c.network.send(p, c.local, addr)
+	}
+
+	return len(p), nil
+}
+
+func (c *conn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.network.remove(c)
+	})
+
+	return nil
+}
+
+// LocalAddr returns the address other conns of the network reach c at
+func (c *conn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *conn) deliver(d datagram) {
+	select {
+	case c.inbox <- d:
+		atomic.AddUint64(&c.network.stats.delivered, 1)
+	case <-c.closed:
+		atomic.AddUint64(&c.network.stats.dropped, 1)
+	default:
+		// the inbox is full. like a socket buffer it drops the datagram
+		atomic.AddUint64(&c.network.stats.dropped, 1)
+	}
+}
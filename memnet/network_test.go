@@ -0,0 +1,158 @@
+package memnet
+
+import (
+	"github.com/alabianca/gokad"
+	"github.com/alabianca/kadnet/kadclock"
+	"github.com/alabianca/kadnet/kadconn"
+	"github.com/alabianca/kadnet/messages"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNetwork_Deliver(t *testing.T) {
+	network := NewNetwork()
+	a, _ := network.Listen("127.0.0.1:5000")
+	b, _ := network.Listen("127.0.0.1:5001")
+	defer a.Close()
+	defer b.Close()
+
+	if _, err := network.Listen("127.0.0.1:5000"); err == nil || err.Error() != ErrAddressInUse {
+		t.Fatalf("Expected %s, but got %v\n", ErrAddressInUse, err)
+	}
+
+	ping := pingRequest()
+	a.Write(ping, addr("127.0.0.1:5001"))
+	msg, from, err := b.Next()
+	if err != nil {
+		t.Fatalf("Expected err to be nil, but got %s\n", err)
+	}
+	if string(msg) != string(ping) || from.String() != "127.0.0.1:5000" {
+		t.Fatalf("Expected %v from 127.0.0.1:5000, but got %v from %s\n", ping, msg, from)
+	}
+
+	a.Close()
+	if _, _, err := a.Next(); err != net.ErrClosed {
+		t.Fatalf("Expected %s, but got %v\n", net.ErrClosed, err)
+	}
+}
+
+func TestNetwork_Latency(t *testing.T) {
+	network := NewNetwork()
+	network.SetLatency(time.Millisecond*50, time.Millisecond*10)
+	a, _ := network.Listen("127.0.0.1:5000")
+	b, _ := network.Listen("127.0.0.1:5001")
+	defer a.Close()
+	defer b.Close()
+
+	start := time.Now()
+	a.Write(pingRequest(), addr("127.0.0.1:5001"))
+	b.Next()
+	if elapsed := time.Since(start); elapsed < time.Millisecond*50 {
+		t.Fatalf("Expected the datagram to take at least 50ms, but it took %s\n", elapsed)
+	}
+}
+
+func TestNetwork_SameTimeOrder(t *testing.T) {
+	network := NewNetwork()
+	clock := kadclock.NewManual(time.Unix(0, 0))
+	network.SetClock(clock)
+	network.SetLatency(time.Millisecond*20, 0)
+	a, _ := network.Listen("127.0.0.1:5000")
+	b, _ := network.Listen("127.0.0.1:5001")
+	c, _ := network.Listen("127.0.0.1:5002")
+	defer a.Close()
+	defer b.Close()
+	defer c.Close()
+
+	// datagrams due at the same time arrive ordered by sender, whatever order they were sent in
+	c.Write(pingRequest(), addr("127.0.0.1:5001"))
+	a.Write(pingRequest(), addr("127.0.0.1:5001"))
+	clock.Advance(time.Millisecond * 20)
+
+	for _, want := range []string{"127.0.0.1:5000", "127.0.0.1:5002"} {
+		if _, from, _ := b.Next(); from.String() != want {
+			t.Fatalf("Expected a datagram from %s, but got one from %s\n", want, from)
+		}
+	}
+}
+
+func TestNetwork_LossAndPartition(t *testing.T) {
+	network := NewNetwork()
+	a, _ := network.Listen("127.0.0.1:5000")
+	b, _ := network.Listen("127.0.0.1:5001")
+	defer a.Close()
+	defer b.Close()
+
+	network.SetLoss(1)
+	a.Write(pingRequest(), addr("127.0.0.1:5001"))
+	expectNothing(t, b)
+	network.SetLoss(0)
+
+	network.Partition([]string{"127.0.0.1:5000"}, []string{"127.0.0.1:5001"})
+	a.Write(pingRequest(), addr("127.0.0.1:5001"))
+	expectNothing(t, b)
+
+	network.Heal()
+	a.Write(pingRequest(), addr("127.0.0.1:5001"))
+	if _, _, err := b.Next(); err != nil {
+		t.Fatalf("Expected err to be nil after healing the partition, but got %s\n", err)
+	}
+}
+
+func TestNetwork_Seeded(t *testing.T) {
+	// the same seed drops the same datagrams of a link
+	dropped := func(seed int64) []bool {
+		network := NewSeededNetwork(seed)
+		network.SetLoss(0.5)
+		a, _ := network.Listen("127.0.0.1:5000")
+		b, _ := network.Listen("127.0.0.1:5001")
+		defer a.Close()
+		defer b.Close()
+
+		out := make([]bool, 20)
+		for i := range out {
+			a.Write(pingRequest(), addr("127.0.0.1:5001"))
+			out[i] = len(b.(*conn).inbox) == 0
+			for len(b.(*conn).inbox) > 0 {
+				<-b.(*conn).inbox
+			}
+		}
+
+		stats := network.Stats()
+		if stats.Sent != 20 || stats.Delivered+stats.Dropped != 20 {
+			t.Fatalf("Expected 20 datagrams to be sent and delivered or dropped, but got %+v\n", stats)
+		}
+
+		return out
+	}
+
+	first, second := dropped(7), dropped(7)
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("Expected runs with the same seed to drop the same datagrams, but got %v and %v\n", first, second)
+		}
+	}
+}
+
+// expectNothing checks that nothing was delivered to c. Datagrams without latency are delivered by Write
+func expectNothing(t *testing.T, c kadconn.KadConn) {
+	if n := len(c.(*conn).inbox); n != 0 {
+		t.Fatalf("Expected the datagram to be dropped, but %d were delivered\n", n)
+	}
+}
+
+func addr(s string) net.Addr {
+	a, _ := net.ResolveUDPAddr("udp", s)
+	return a
+}
+
+func pingRequest() []byte {
+	ping := messages.PingRequest{
+		SenderID: gokad.GenerateRandomID().String(),
+		RandomID: gokad.GenerateRandomID().String(),
+	}
+
+	b, _ := ping.Bytes()
+	return b
+}
@@ -0,0 +1,89 @@
+package messages
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/alabianca/gokad"
+	"net"
+)
+
+// AddressFamily tells how the IP of a serialized contact is read. The values are the IANA address family numbers
+type AddressFamily byte
+
+const (
+	IPv4 = AddressFamily(1)
+	IPv6 = AddressFamily(2)
+)
+
+// ContactSize is the size of a serialized contact
+const ContactSize = 39
+
+const ErrUnknownFamily = "unknown address family"
+
+// FamilyOf returns the family of ip. IPv4-mapped IPv6 addresses are IPv4. It returns 0 for an invalid ip
+func FamilyOf(ip net.IP) AddressFamily {
+	if ip.To4() != nil {
+		return IPv4
+	}
+	if ip.To16() != nil {
+		return IPv6
+	}
+
+	return 0
+}
+
+// SerializeContact returns the wire layout of c. IPv4 addresses are sent IPv4-mapped, so every contact has the same size.
+//
+// Contact
+// <- 20 Bytes <- 2 Bytes <- 1 Byte <- 16 Bytes
+//
+//	ID          Port       Family    IP
+func SerializeContact(c gokad.Contact) []byte {
+	out := make([]byte, ContactSize)
+	copy(out, c.ID)
+	binary.BigEndian.PutUint16(out[20:], uint16(c.Port))
+	out[22] = byte(FamilyOf(c.IP))
+	copy(out[23:], c.IP.To16())
+
+	return out
+}
+
+func toContact(b []byte) (gokad.Contact, error) {
+	l := len(b)
+	if l == 0 {
+		return gokad.Contact{}, errors.New("Empty Contact")
+	}
+
+	idOffset := 0
+	portOffset := 20
+	familyOffset := 22
+	ipOffset := 23
+
+	if l != ContactSize {
+		return gokad.Contact{}, errors.New("Malformed Contact")
+	}
+
+	idBytes := b[idOffset:portOffset]
+	portBytes := b[portOffset:familyOffset]
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, b[ipOffset:])
+
+	// the family has to match the address, so a mapped address is never taken for an IPv6 one or vice versa
+	if family := AddressFamily(b[familyOffset]); family != FamilyOf(ip) {
+		return gokad.Contact{}, errors.New(ErrUnknownFamily)
+	}
+
+	port := binary.BigEndian.Uint16(portBytes)
+	id, err := gokad.From(ToStringId(idBytes))
+	if err != nil {
+		return gokad.Contact{}, errors.New("Invalid ID")
+	}
+
+	c := gokad.Contact{
+		ID:   id,
+		IP:   ip,
+		Port: int(port),
+	}
+
+	return c, nil
+}
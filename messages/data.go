@@ -0,0 +1,260 @@
+package messages
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/alabianca/gokad"
+	"time"
+)
+
+// Data messages carry an opaque value instead of a host:port pair.
+//
+// StoreDataReq payload
+// <- 20 Bytes  <- 4 Bytes  <- 4 Bytes  <- X Bytes
+//  Key          TTL          Length      Data
+//
+// FindDataReq payload
+// <- 20 Bytes
+//  Key
+//
+// FindDataResOK payload. FindDataRes carries the k closest contacts like FindValueRes
+// <- 20 Bytes  <- 4 Bytes  <- X Bytes
+//  Key          Length      Data
+
+const ErrDataMalformed = "malformed data payload"
+
+type StoreDataRequestPayload struct {
+	Key  gokad.ID
+	Data []byte
+	// TTL is how long the receiver should keep the value. It is sent with a resolution of seconds.
+	// A TTL of 0 lets the receiver pick its default expiry
+	TTL time.Duration
+}
+
+type StoreDataRequest struct {
+	SenderID string
+	RandomID string
+	Payload  StoreDataRequestPayload
+}
+
+func (s *StoreDataRequest) MultiplexKey() MessageType {
+	return StoreDataReq
+}
+
+func (s *StoreDataRequest) Bytes() ([]byte, error) {
+	if len(s.Payload.Data) > MaxDataSize {
+		return nil, errors.New(ErrDataMalformed)
+	}
+
+	sid, err := SerializeID(s.SenderID)
+	if err != nil {
+		return nil, err
+	}
+	rid, err := SerializeID(s.RandomID)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := make([]byte, 4)
+	binary.BigEndian.PutUint32(ttl, uint32(s.Payload.TTL/time.Second))
+
+	out := make([]byte, 0, StoreDataReqSize+len(s.Payload.Data))
+	out = append(out, byte(StoreDataReq))
+	out = append(out, sid...)
+	out = append(out, s.Payload.Key...)
+	out = append(out, ttl...)
+	out = append(out, encodeData(s.Payload.Data)...)
+	out = append(out, rid...)
+
+	return out, nil
+}
+
+func (s *StoreDataRequest) GetRandomID() string {
+	return s.RandomID
+}
+
+func (s *StoreDataRequest) GetEchoRandomID() string {
+	return ""
+}
+
+func (s *StoreDataRequest) GetSenderID() string {
+	return s.SenderID
+}
+
+type FindDataRequest struct {
+	SenderID string
+	Payload  string
+	RandomID string
+}
+
+func (f *FindDataRequest) MultiplexKey() MessageType {
+	return FindDataReq
+}
+
+func (f *FindDataRequest) Bytes() ([]byte, error) {
+	sid, err := SerializeID(f.SenderID)
+	if err != nil {
+		return nil, err
+	}
+	pid, err := SerializeID(f.Payload)
+	if err != nil {
+		return nil, err
+	}
+	rid, err := SerializeID(f.RandomID)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, FindDataReqSize)
+	out = append(out, byte(FindDataReq))
+	out = append(out, sid...)
+	out = append(out, pid...)
+	out = append(out, rid...)
+
+	return out, nil
+}
+
+func (f *FindDataRequest) GetRandomID() string {
+	return f.RandomID
+}
+
+func (f *FindDataRequest) GetEchoRandomID() string {
+	return ""
+}
+
+func (f *FindDataRequest) GetSenderID() string {
+	return f.SenderID
+}
+
+type FindDataResponsePayload struct {
+	Key      string
+	Data     []byte
+	Contacts []gokad.Contact
+}
+
+type FindDataResponse struct {
+	mkey         MessageType
+	SenderID     string
+	Payload      FindDataResponsePayload
+	RandomID     string
+	EchoRandomID string
+}
+
+// FindDataResponseOK is sent if the value is found. Only Payload.Key and Payload.Data are sent
+func FindDataResponseOK() *FindDataResponse {
+	return &FindDataResponse{mkey: FindDataResOK}
+}
+
+// FindDataResponseNOK is sent if the value is not found. Only Payload.Contacts are sent
+func FindDataResponseNOK() *FindDataResponse {
+	return &FindDataResponse{mkey: FindDataRes}
+}
+
+func (f *FindDataResponse) MultiplexKey() MessageType {
+	return f.mkey
+}
+
+func (f *FindDataResponse) Bytes() ([]byte, error) {
+	sid, err := SerializeID(f.SenderID)
+	if err != nil {
+		return nil, err
+	}
+	eid, err := SerializeID(f.EchoRandomID)
+	if err != nil {
+		return nil, err
+	}
+	rid, err := SerializeID(f.RandomID)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0)
+	out = append(out, byte(f.mkey))
+	out = append(out, sid...)
+	out = append(out, eid...)
+	if f.mkey == FindDataResOK {
+		if len(f.Payload.Data) > MaxDataSize {
+			return nil, errors.New(ErrDataMalformed)
+		}
+		key, err := SerializeID(f.Payload.Key)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, key...)
+		out = append(out, encodeData(f.Payload.Data)...)
+	} else {
+		for _, c := range f.Payload.Contacts {
+			out = append(out, SerializeContact(c)...)
+		}
+	}
+	out = append(out, rid...)
+
+	return out, nil
+}
+
+func (f *FindDataResponse) GetSenderID() string {
+	return f.SenderID
+}
+
+func (f *FindDataResponse) GetEchoRandomID() string {
+	return f.EchoRandomID
+}
+
+func (f *FindDataResponse) GetRandomID() string {
+	return f.RandomID
+}
+
+func parseStoreDataRequestPayload(b []byte) (StoreDataRequestPayload, error) {
+	if len(b) < 28 {
+		return StoreDataRequestPayload{}, errors.New(ErrDataMalformed)
+	}
+
+	data, err := decodeData(b[24:])
+	if err != nil {
+		return StoreDataRequestPayload{}, err
+	}
+
+	key := make(gokad.ID, 20)
+	copy(key, b[:20])
+	ttl := time.Duration(binary.BigEndian.Uint32(b[20:24])) * time.Second
+
+	return StoreDataRequestPayload{Key: key, Data: data, TTL: ttl}, nil
+}
+
+func parseFindDataResponsePayload(mkey MessageType, b []byte) (FindDataResponsePayload, error) {
+	var res FindDataResponsePayload
+	var err error
+
+	switch mkey {
+	case FindDataResOK:
+		if len(b) < 24 {
+			return res, errors.New(ErrDataMalformed)
+		}
+		res.Key = ToStringId(b[:20])
+		res.Data, err = decodeData(b[20:])
+	case FindDataRes:
+		res.Contacts, err = processContacts(b)
+	default:
+		err = errors.New(ErrInvalidMessage)
+	}
+
+	return res, err
+}
+
+// encodeData prefixes data with its length
+func encodeData(data []byte) []byte {
+	out := make([]byte, 4, 4+len(data))
+	binary.BigEndian.PutUint32(out, uint32(len(data)))
+	return append(out, data...)
+}
+
+// decodeData reads a value written by encodeData. The value must span the rest of b
+func decodeData(b []byte) ([]byte, error) {
+	if len(b) < 4 || int(binary.BigEndian.Uint32(b[:4])) != len(b)-4 {
+		return nil, errors.New(ErrDataMalformed)
+	}
+
+	data := make([]byte, len(b)-4)
+	copy(data, b[4:])
+	return data, nil
+}
@@ -38,7 +38,7 @@ func (n *FindNodeResponse) Bytes() ([]byte, error) {
 	out = append(out, sid...)
 	out = append(out, eid...)
 	for _, c := range n.Payload {
-		ser := c.Serialize()
+		ser := SerializeContact(c)
 
 		out = append(out, ser...)
 	}
@@ -124,7 +124,7 @@ func (f *FindValueResponse) Bytes() ([]byte, error) {
 	}
 
 	for _, c := range f.Payload.Contacts {
-		out = append(out, c.Serialize()...)
+		out = append(out, SerializeContact(c)...)
 	}
 
 	out = append(out, rid...)
@@ -1,11 +1,15 @@
 package messages_test
 
 import (
+	"crypto/ed25519"
 	"github.com/alabianca/gokad"
+	"github.com/alabianca/kadnet/identity"
 	"github.com/alabianca/kadnet/messages"
+	"github.com/alabianca/kadnet/mutable"
 	"net"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestMessageX_FindNodeRequest(t *testing.T) {
@@ -63,9 +67,9 @@ func TestMessageX_FindNodeResponse(t *testing.T) {
 	echo, _ := gokad.From("28f787e3b60f99fb29b14266c40b536d6037307e")
 	random, _ := gokad.From("8f2d6ae2378dda228d3bd39c41a4b6f6f538a41a")
 	payload := make([]byte, 0)
-	payload = append(payload, c1.Serialize()...)
-	payload = append(payload, c2.Serialize()...)
-	payload = append(payload, c3.Serialize()...)
+	payload = append(payload, messages.SerializeContact(c1)...)
+	payload = append(payload, messages.SerializeContact(c2)...)
+	payload = append(payload, messages.SerializeContact(c3)...)
 
 	b, _ := fnr.Bytes()
 	msg := messages.Message(b)
@@ -88,6 +92,35 @@ func TestMessageX_FindNodeResponse(t *testing.T) {
 
 }
 
+func TestFindNodeResponse_AddressFamilies(t *testing.T) {
+	v4 := generateContact("b4945c02ddd3d4484ed7200107b46f65f5300305")
+	v6 := generateContact("dc03f8f281c7118225901c8655f788cd84e3f449")
+	v6.IP = net.ParseIP("2001:db8::1")
+	res := messages.FindNodeResponse{
+		SenderID:     gokad.GenerateRandomID().String(),
+		EchoRandomID: gokad.GenerateRandomID().String(),
+		Payload:      []gokad.Contact{v4, v6},
+		RandomID:     gokad.GenerateRandomID().String(),
+	}
+
+	b, _ := res.Bytes()
+	var out messages.FindNodeResponse
+	messages.ToKademliaMessage(messages.Message(b), &out)
+	if !reflect.DeepEqual(res, out) {
+		t.Fatalf("Expected %v, but got %v\n", res, out)
+	}
+	if messages.FamilyOf(out.Payload[0].IP) != messages.IPv4 || messages.FamilyOf(out.Payload[1].IP) != messages.IPv6 {
+		t.Fatalf("Expected an IPv4 and an IPv6 contact, but got %v\n", out.Payload)
+	}
+
+	// a contact whose family does not match its address is dropped
+	b[41+22] = byte(messages.IPv6)
+	messages.ToKademliaMessage(messages.Message(b), &out)
+	if len(out.Payload) != 1 || !reflect.DeepEqual(out.Payload[0], v6) {
+		t.Fatalf("Expected only %v, but got %v\n", v6, out.Payload)
+	}
+}
+
 func TestPingResponse_Bytes(t *testing.T) {
 	sid := gokad.GenerateRandomID()
 	eid := gokad.GenerateRandomID()
@@ -127,6 +160,163 @@ func TestExplicitAndImplicitPingRes(t *testing.T) {
 	}
 }
 
+func TestPingResponse_Proof(t *testing.T) {
+	ident, _ := identity.Generate(0)
+	for _, pr := range []*messages.PingResponse{messages.Implicit(), messages.Explicit()} {
+		pr.SenderID = ident.ID().String()
+		pr.EchoRandomID = gokad.GenerateRandomID().String()
+		pr.RandomID = gokad.GenerateRandomID().String()
+		signable, _ := pr.Signable()
+		pr.Proof = ident.Prove(signable)
+
+		b, _ := pr.Bytes()
+		var out messages.PingResponse
+		messages.ToKademliaMessage(messages.Message(b), &out)
+		if !reflect.DeepEqual(*pr, out) {
+			t.Fatalf("Expected %v, but got %v\n", *pr, out)
+		}
+
+		signable, _ = out.Signable()
+		if err := out.Proof.Verify(ident.ID(), signable, 0); err != nil {
+			t.Fatalf("Expected the decoded proof to be valid, but got %s\n", err)
+		}
+	}
+}
+
+func TestStoreRequest_TTL(t *testing.T) {
+	key := gokad.GenerateRandomID()
+	req := messages.StoreRequest{
+		SenderID: gokad.GenerateRandomID().String(),
+		RandomID: gokad.GenerateRandomID().String(),
+		Payload: messages.StoreRequestPayload{
+			Key:   key,
+			Value: gokad.Value{Host: net.ParseIP("127.0.0.1"), Port: 3000},
+			TTL:   time.Minute * 5,
+		},
+	}
+
+	b, _ := req.Bytes()
+	if len(b) != messages.StoreReqSize {
+		t.Fatalf("Expected store request to be %d bytes, but got %d\n", messages.StoreReqSize, len(b))
+	}
+
+	var out messages.StoreRequest
+	messages.ToKademliaMessage(messages.Message(b), &out)
+	if !reflect.DeepEqual(req, out) {
+		t.Fatalf("Expected %v, but got %v\n", req, out)
+	}
+
+	// a store request without a ttl gets a ttl of 0
+	legacy := append(append([]byte{}, b[:len(b)-24]...), b[len(b)-20:]...)
+	messages.ToKademliaMessage(messages.Message(legacy), &out)
+	if out.Payload.TTL != 0 || !reflect.DeepEqual(out.Payload.Key, key) {
+		t.Fatalf("Expected store request with key %s and no TTL, but got %s %s\n", key, out.Payload.Key, out.Payload.TTL)
+	}
+}
+
+func TestStoreDataRequest_Bytes(t *testing.T) {
+	req := messages.StoreDataRequest{
+		SenderID: gokad.GenerateRandomID().String(),
+		RandomID: gokad.GenerateRandomID().String(),
+		Payload: messages.StoreDataRequestPayload{
+			Key:  gokad.GenerateRandomID(),
+			Data: []byte(`{"service":"api","port":8080}`),
+			TTL:  time.Minute * 5,
+		},
+	}
+
+	b, _ := req.Bytes()
+	if len(b) != messages.StoreDataReqSize+len(req.Payload.Data) {
+		t.Fatalf("Expected store data request to be %d bytes, but got %d\n", messages.StoreDataReqSize+len(req.Payload.Data), len(b))
+	}
+
+	var out messages.StoreDataRequest
+	messages.ToKademliaMessage(messages.Message(b), &out)
+	if !reflect.DeepEqual(req, out) {
+		t.Fatalf("Expected %v, but got %v\n", req, out)
+	}
+
+	// a length prefix that does not match the data is rejected
+	b[messages.StoreDataReqSize-21] = 0xff
+	out = messages.StoreDataRequest{}
+	messages.ToKademliaMessage(messages.Message(b), &out)
+	if out.SenderID != "" {
+		t.Fatalf("Expected malformed store data request to be dropped, but got %v\n", out)
+	}
+}
+
+func TestFindDataResponse_Bytes(t *testing.T) {
+	ok := messages.FindDataResponseOK()
+	ok.SenderID = gokad.GenerateRandomID().String()
+	ok.EchoRandomID = gokad.GenerateRandomID().String()
+	ok.RandomID = gokad.GenerateRandomID().String()
+	ok.Payload.Key = gokad.GenerateRandomID().String()
+	ok.Payload.Data = []byte("some config blob")
+
+	b, _ := ok.Bytes()
+	if len(b) != messages.FindDataResOKSize+len(ok.Payload.Data) {
+		t.Fatalf("Expected find data response to be %d bytes, but got %d\n", messages.FindDataResOKSize+len(ok.Payload.Data), len(b))
+	}
+
+	var out messages.FindDataResponse
+	messages.ToKademliaMessage(messages.Message(b), &out)
+	if !reflect.DeepEqual(*ok, out) {
+		t.Fatalf("Expected %v, but got %v\n", *ok, out)
+	}
+
+	nok := messages.FindDataResponseNOK()
+	nok.SenderID = gokad.GenerateRandomID().String()
+	nok.EchoRandomID = gokad.GenerateRandomID().String()
+	nok.RandomID = gokad.GenerateRandomID().String()
+	nok.Payload.Contacts = []gokad.Contact{generateContact("b4945c02ddd3d4484ed7200107b46f65f5300305")}
+
+	b, _ = nok.Bytes()
+	messages.ToKademliaMessage(messages.Message(b), &out)
+	if out.MultiplexKey() != messages.FindDataRes || len(out.Payload.Contacts) != 1 || out.Payload.Data != nil {
+		t.Fatalf("Expected a find data response with 1 contact, but got %v\n", out)
+	}
+}
+
+func TestFindMutableResponse_Bytes(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(nil)
+	record, _ := mutable.Sign(priv, []byte("profile"), 7, []byte("some config blob"))
+
+	store := messages.StoreMutableRequest{
+		SenderID: gokad.GenerateRandomID().String(),
+		RandomID: gokad.GenerateRandomID().String(),
+		Payload: messages.StoreMutableRequestPayload{
+			Key:    record.Key(),
+			Record: record,
+			TTL:    time.Hour,
+		},
+	}
+
+	b, _ := store.Bytes()
+	var storeOut messages.StoreMutableRequest
+	messages.ToKademliaMessage(messages.Message(b), &storeOut)
+	if !reflect.DeepEqual(store, storeOut) {
+		t.Fatalf("Expected %v, but got %v\n", store, storeOut)
+	}
+
+	ok := messages.FindMutableResponseOK()
+	ok.SenderID = gokad.GenerateRandomID().String()
+	ok.EchoRandomID = gokad.GenerateRandomID().String()
+	ok.RandomID = gokad.GenerateRandomID().String()
+	ok.Payload.Key = record.Key().String()
+	ok.Payload.Record = record
+
+	b, _ = ok.Bytes()
+	var out messages.FindMutableResponse
+	messages.ToKademliaMessage(messages.Message(b), &out)
+	if !reflect.DeepEqual(*ok, out) {
+		t.Fatalf("Expected %v, but got %v\n", *ok, out)
+	}
+
+	if err := out.Payload.Record.Verify(); err != nil {
+		t.Fatalf("Expected the decoded record to verify, but got %s\n", err)
+	}
+}
+
 func generateContact(id string) gokad.Contact {
 	x, _ := gokad.From(id)
 	return gokad.Contact{
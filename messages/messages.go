@@ -7,7 +7,7 @@ import (
 	"github.com/alabianca/gokad"
 	"github.com/alabianca/kadnet/util"
 	"math"
-	"net"
+	"time"
 )
 
 type MessageType int
@@ -32,15 +32,37 @@ const (
 	FindValueResOK  = MessageType(27) // sent if the value is actually found from a FindValueReq
 	StoreReq        = MessageType(28)
 	StoreRes        = MessageType(29)
+	StoreDataReq    = MessageType(30) // like StoreReq but the value is opaque data
+	FindDataReq     = MessageType(31)
+	FindDataRes     = MessageType(32)
+	FindDataResOK   = MessageType(33) // sent if the data is actually found from a FindDataReq
+
+	StoreMutableReq  = MessageType(34) // like StoreDataReq but the value is a signed mutable record
+	FindMutableReq   = MessageType(35)
+	FindMutableRes   = MessageType(36)
+	FindMutableResOK = MessageType(37) // sent if the record is actually found from a FindMutableReq
 	// Message Sizes
 	PingReqSize      = 41
 	PingResSize      = 41
 	PingReqResSize   = 61
 	FindNodeReqSize  = 61
 	FindValueReqSize = 61
-	StoreReqSize     = 79
-	FindNodeResSize  = 841
-	FindValueResSize = 441 // Note: Assumes there are always k values in the payload
+	StoreReqSize     = 84
+	FindNodeResSize  = 861
+	FindValueResSize = 451 // Note: Assumes there are always k values in the payload
+
+	StoreDataReqSize  = 69 // Note: without the data
+	FindDataReqSize   = 61
+	FindDataResOKSize = 85 // Note: without the data
+	// MaxMessageSize is the largest message that fits in a single UDP datagram. SealOverhead bytes are left for sealing it
+	MaxMessageSize = 65507 - SealOverhead
+	// MaxDataSize is the largest opaque value every data message can carry
+	MaxDataSize = MaxMessageSize - FindDataResOKSize
+
+	FindMutableReqSize = 61
+
+	// SealOverhead is the room a secure connection needs to frame and seal a message
+	SealOverhead = 64
 )
 
 // Errors
@@ -64,6 +86,12 @@ func GetMessageSize(x MessageType) int {
 		size = PingResSize
 	case StoreReq:
 		size = StoreReqSize
+	case StoreDataReq:
+		size = StoreDataReqSize
+	case FindDataReq:
+		size = FindDataReqSize
+	case FindMutableReq:
+		size = FindMutableReqSize
 
 	}
 
@@ -101,6 +129,54 @@ func ToKademliaMessage(msg Message, km KademliaMessage) {
 				EchoRandomID: ToStringId(eid),
 			}
 		}
+	case *FindDataResponse:
+		if p, err := parseFindDataResponsePayload(mkey, p); err == nil {
+			*v = FindDataResponse{
+				mkey:         mkey,
+				SenderID:     ToStringId(sid),
+				Payload:      p,
+				RandomID:     ToStringId(rid),
+				EchoRandomID: ToStringId(eid),
+			}
+		}
+	case *FindMutableResponse:
+		if p, err := parseFindMutableResponsePayload(mkey, p); err == nil {
+			*v = FindMutableResponse{
+				mkey:         mkey,
+				SenderID:     ToStringId(sid),
+				Payload:      p,
+				RandomID:     ToStringId(rid),
+				EchoRandomID: ToStringId(eid),
+			}
+		}
+	case *FindMutableRequest:
+		*v = FindMutableRequest{
+			SenderID: ToStringId(sid),
+			Payload:  ToStringId(p),
+			RandomID: ToStringId(rid),
+		}
+	case *StoreMutableRequest:
+		if p, err := parseStoreMutableRequestPayload(p); err == nil {
+			*v = StoreMutableRequest{
+				SenderID: ToStringId(sid),
+				RandomID: ToStringId(rid),
+				Payload:  p,
+			}
+		}
+	case *FindDataRequest:
+		*v = FindDataRequest{
+			SenderID: ToStringId(sid),
+			Payload:  ToStringId(p),
+			RandomID: ToStringId(rid),
+		}
+	case *StoreDataRequest:
+		if p, err := parseStoreDataRequestPayload(p); err == nil {
+			*v = StoreDataRequest{
+				SenderID: ToStringId(sid),
+				RandomID: ToStringId(rid),
+				Payload:  p,
+			}
+		}
 	case *FindValueRequest:
 		*v = FindValueRequest{
 			SenderID:     ToStringId(sid),
@@ -125,12 +201,15 @@ func ToKademliaMessage(msg Message, km KademliaMessage) {
 		*v = PingRequest{
 			SenderID: ToStringId(sid),
 			RandomID: ToStringId(rid),
+			Proof:    parseProof(p),
 		}
 	case *PingResponse:
 		*v = PingResponse{
+			mkey:         mkey,
 			SenderID:     ToStringId(sid),
 			EchoRandomID: ToStringId(eid),
 			RandomID:     ToStringId(rid),
+			Proof:        parseProof(p),
 		}
 	case *FindNodeRequest:
 		*v = FindNodeRequest{
@@ -153,11 +232,12 @@ func ToKademliaMessage(msg Message, km KademliaMessage) {
 
 }
 
-// every contact is 38 bytes long.
-// split the raw bytes in chuncks of 38 bytes.
+// every contact is ContactSize bytes long.
+// split the raw bytes in chuncks of ContactSize bytes.
+// contacts that cannot be read, like the ones of an unknown address family, are dropped
 func processContacts(raw []byte) ([]gokad.Contact, error) {
 	offset := 0
-	cLen := 38
+	cLen := ContactSize
 	l := float64(len(raw))
 	x := float64(cLen)
 	numContacts := l / x
@@ -178,57 +258,29 @@ func processContacts(raw []byte) ([]gokad.Contact, error) {
 		offset += cLen
 	}
 
-	return out, nil
-}
-
-func toContact(b []byte) (gokad.Contact, error) {
-	l := len(b)
-	if l == 0 {
-		return gokad.Contact{}, errors.New("Empty Contact")
-	}
-
-	idOffset := 0
-	portOffset := 20
-	ipOffset := 22
-
-	if l <= ipOffset {
-		return gokad.Contact{}, errors.New("Malformed Contact")
-	}
-
-	idBytes := b[idOffset:portOffset]
-	portBytes := b[portOffset:ipOffset]
-	ipBytes := b[ipOffset:len(b)]
-	ip := net.IP(ipBytes)
-
-	port := binary.BigEndian.Uint16(portBytes)
-	id, err := gokad.From(ToStringId(idBytes))
-	if err != nil {
-		return gokad.Contact{}, errors.New("Invalid ID")
-	}
-
-	c := gokad.Contact{
-		ID:   id,
-		IP:   ip,
-		Port: int(port),
-	}
-
-	return c, nil
-
+	return out[:insert], nil
 }
 
+// The store request payload has the same structure as a contact followed by a 4 byte TTL in seconds.
+// A payload without the TTL gets a TTL of 0, so the receiver picks its default expiry
 func parseStoreRequestPayload(b []byte) (StoreRequestPayload, error) {
-	length := 38
-	if len(b) != length {
+	length := ContactSize
+	if len(b) != length && len(b) != length+4 {
 		return StoreRequestPayload{}, errors.New("malformed Store Request")
 	}
 
 	// we can use toContact here as the payload uses the same structure
-	c, err := toContact(b)
+	c, err := toContact(b[:length])
 	if err != nil {
 		return StoreRequestPayload{}, errors.New("malformed Store Request")
 	}
 
-	return StoreRequestPayload{Key: c.ID, Value: gokad.Value{Host: c.IP, Port: c.Port}}, nil
+	var ttl time.Duration
+	if len(b) > length {
+		ttl = time.Duration(binary.BigEndian.Uint32(b[length:])) * time.Second
+	}
+
+	return StoreRequestPayload{Key: c.ID, Value: gokad.Value{Host: c.IP, Port: c.Port}, TTL: ttl}, nil
 }
 
 func parseFindValueResponsePayload(mkey MessageType, b []byte) (FindValueResponsePayload, error) {
@@ -257,6 +309,10 @@ func IsResponse(msgType MessageType) bool {
 		msgType == FindValueRes ||
 		msgType == FindValueResOK ||
 		msgType == StoreRes ||
+		msgType == FindDataRes ||
+		msgType == FindDataResOK ||
+		msgType == FindMutableRes ||
+		msgType == FindMutableResOK ||
 		msgType == PingResExplicit
 }
 
@@ -265,6 +321,10 @@ func IsRequest(msgType MessageType) bool {
 		msgType == PingReq ||
 		msgType == FindValueReq ||
 		msgType == StoreReq ||
+		msgType == StoreDataReq ||
+		msgType == FindDataReq ||
+		msgType == StoreMutableReq ||
+		msgType == FindMutableReq ||
 		msgType == PingResImplicit
 }
 
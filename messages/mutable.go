@@ -0,0 +1,255 @@
+package messages
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/alabianca/gokad"
+	"github.com/alabianca/kadnet/mutable"
+	"time"
+)
+
+// Mutable messages carry a signed mutable.Record in its encoded form.
+//
+// StoreMutableReq payload
+// <- 20 Bytes  <- 4 Bytes  <- X Bytes
+//  Key          TTL          Record
+//
+// FindMutableReq payload
+// <- 20 Bytes
+//  Key
+//
+// FindMutableResOK payload. FindMutableRes carries the k closest contacts like FindValueRes
+// <- 20 Bytes  <- X Bytes
+//  Key          Record
+
+const ErrMessageTooLarge = "message too large"
+
+type StoreMutableRequestPayload struct {
+	Key    gokad.ID
+	Record mutable.Record
+	// TTL is how long the receiver should keep the record. It is sent with a resolution of seconds.
+	// A TTL of 0 lets the receiver pick its default expiry
+	TTL time.Duration
+}
+
+type StoreMutableRequest struct {
+	SenderID string
+	RandomID string
+	Payload  StoreMutableRequestPayload
+}
+
+func (s *StoreMutableRequest) MultiplexKey() MessageType {
+	return StoreMutableReq
+}
+
+func (s *StoreMutableRequest) Bytes() ([]byte, error) {
+	sid, err := SerializeID(s.SenderID)
+	if err != nil {
+		return nil, err
+	}
+	rid, err := SerializeID(s.RandomID)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := make([]byte, 4)
+	binary.BigEndian.PutUint32(ttl, uint32(s.Payload.TTL/time.Second))
+
+	out := make([]byte, 0)
+	out = append(out, byte(StoreMutableReq))
+	out = append(out, sid...)
+	out = append(out, s.Payload.Key...)
+	out = append(out, ttl...)
+	out = append(out, s.Payload.Record.Encode()...)
+	out = append(out, rid...)
+	if len(out) > MaxMessageSize {
+		return nil, errors.New(ErrMessageTooLarge)
+	}
+
+	return out, nil
+}
+
+func (s *StoreMutableRequest) GetRandomID() string {
+	return s.RandomID
+}
+
+func (s *StoreMutableRequest) GetEchoRandomID() string {
+	return ""
+}
+
+func (s *StoreMutableRequest) GetSenderID() string {
+	return s.SenderID
+}
+
+type FindMutableRequest struct {
+	SenderID string
+	Payload  string
+	RandomID string
+}
+
+func (f *FindMutableRequest) MultiplexKey() MessageType {
+	return FindMutableReq
+}
+
+func (f *FindMutableRequest) Bytes() ([]byte, error) {
+	sid, err := SerializeID(f.SenderID)
+	if err != nil {
+		return nil, err
+	}
+	pid, err := SerializeID(f.Payload)
+	if err != nil {
+		return nil, err
+	}
+	rid, err := SerializeID(f.RandomID)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, FindMutableReqSize)
+	out = append(out, byte(FindMutableReq))
+	out = append(out, sid...)
+	out = append(out, pid...)
+	out = append(out, rid...)
+
+	return out, nil
+}
+
+func (f *FindMutableRequest) GetRandomID() string {
+	return f.RandomID
+}
+
+func (f *FindMutableRequest) GetEchoRandomID() string {
+	return ""
+}
+
+func (f *FindMutableRequest) GetSenderID() string {
+	return f.SenderID
+}
+
+type FindMutableResponsePayload struct {
+	Key      string
+	Record   mutable.Record
+	Contacts []gokad.Contact
+}
+
+type FindMutableResponse struct {
+	mkey         MessageType
+	SenderID     string
+	Payload      FindMutableResponsePayload
+	RandomID     string
+	EchoRandomID string
+}
+
+// FindMutableResponseOK is sent if the record is found. Only Payload.Key and Payload.Record are sent
+func FindMutableResponseOK() *FindMutableResponse {
+	return &FindMutableResponse{mkey: FindMutableResOK}
+}
+
+// FindMutableResponseNOK is sent if the record is not found. Only Payload.Contacts are sent
+func FindMutableResponseNOK() *FindMutableResponse {
+	return &FindMutableResponse{mkey: FindMutableRes}
+}
+
+func (f *FindMutableResponse) MultiplexKey() MessageType {
+	return f.mkey
+}
+
+func (f *FindMutableResponse) Bytes() ([]byte, error) {
+	sid, err := SerializeID(f.SenderID)
+	if err != nil {
+		return nil, err
+	}
+	eid, err := SerializeID(f.EchoRandomID)
+	if err != nil {
+		return nil, err
+	}
+	rid, err := SerializeID(f.RandomID)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0)
+	out = append(out, byte(f.mkey))
+	out = append(out, sid...)
+	out = append(out, eid...)
+	if f.mkey == FindMutableResOK {
+		key, err := SerializeID(f.Payload.Key)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, key...)
+		out = append(out, f.Payload.Record.Encode()...)
+	} else {
+		for _, c := range f.Payload.Contacts {
+			out = append(out, SerializeContact(c)...)
+		}
+	}
+	out = append(out, rid...)
+	if len(out) > MaxMessageSize {
+		return nil, errors.New(ErrMessageTooLarge)
+	}
+
+	return out, nil
+}
+
+func (f *FindMutableResponse) GetSenderID() string {
+	return f.SenderID
+}
+
+func (f *FindMutableResponse) GetEchoRandomID() string {
+	return f.EchoRandomID
+}
+
+func (f *FindMutableResponse) GetRandomID() string {
+	return f.RandomID
+}
+
+func parseStoreMutableRequestPayload(b []byte) (StoreMutableRequestPayload, error) {
+	if len(b) < 24 {
+		return StoreMutableRequestPayload{}, errors.New(mutable.ErrMalformed)
+	}
+
+	record, n, err := mutable.Decode(b[24:])
+	if err != nil {
+		return StoreMutableRequestPayload{}, err
+	}
+	if n != len(b)-24 {
+		return StoreMutableRequestPayload{}, errors.New(mutable.ErrMalformed)
+	}
+
+	key := make(gokad.ID, 20)
+	copy(key, b[:20])
+	ttl := time.Duration(binary.BigEndian.Uint32(b[20:24])) * time.Second
+
+	return StoreMutableRequestPayload{Key: key, Record: record, TTL: ttl}, nil
+}
+
+func parseFindMutableResponsePayload(mkey MessageType, b []byte) (FindMutableResponsePayload, error) {
+	var res FindMutableResponsePayload
+
+	switch mkey {
+	case FindMutableResOK:
+		if len(b) < 20 {
+			return res, errors.New(mutable.ErrMalformed)
+		}
+		record, n, err := mutable.Decode(b[20:])
+		if err != nil {
+			return res, err
+		}
+		if n != len(b)-20 {
+			return res, errors.New(mutable.ErrMalformed)
+		}
+		res.Key = ToStringId(b[:20])
+		res.Record = record
+	case FindMutableRes:
+		contacts, err := processContacts(b)
+		if err != nil {
+			return res, err
+		}
+		res.Contacts = contacts
+	default:
+		return res, errors.New(ErrInvalidMessage)
+	}
+
+	return res, nil
+}
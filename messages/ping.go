@@ -1,10 +1,25 @@
 package messages
 
+import "github.com/alabianca/kadnet/identity"
+
+// Ping messages of nodes with an identity carry a proof of their SenderID in front of the RandomID.
+// The proof signs the message without the proof
+//
+// PingReq
+// <- 1 Byte  <- 20 Bytes  <- 96 Bytes  <- 20 Bytes
+//  Type       SenderID     Proof        RandomID
+//
+// PingResImplicit, PingResExplicit
+// <- 1 Byte  <- 20 Bytes  <- 20 Bytes     <- 96 Bytes  <- 20 Bytes
+//  Type       SenderID     EchoRandomID    Proof        RandomID
+
 type PingResponse struct {
 	mkey         MessageType
 	SenderID     string
 	EchoRandomID string
 	RandomID     string
+	// Proof is empty if the sender has no identity
+	Proof identity.Proof
 }
 
 func (m *PingResponse) MultiplexKey() MessageType {
@@ -33,11 +48,19 @@ func (m *PingResponse) Bytes() ([]byte, error) {
 	out = append(out, mkey...)
 	out = append(out, sid...)
 	out = append(out, eid...)
+	out = append(out, m.Proof.Encode()...)
 	out = append(out, rid...)
 
 	return out, nil
 }
 
+// Signable returns the bytes the proof of m signs
+func (m *PingResponse) Signable() ([]byte, error) {
+	unsigned := *m
+	unsigned.Proof = identity.Proof{}
+	return unsigned.Bytes()
+}
+
 func (m *PingResponse) GetRandomID() string {
 	return m.RandomID
 }
@@ -65,6 +88,8 @@ func Explicit() *PingResponse {
 type PingRequest struct {
 	SenderID string
 	RandomID string
+	// Proof is empty if the sender has no identity
+	Proof identity.Proof
 }
 
 func (p *PingRequest) MultiplexKey() MessageType {
@@ -88,11 +113,19 @@ func (p *PingRequest) Bytes() ([]byte, error) {
 	out := make([]byte, 0)
 	out = append(out, mkey...)
 	out = append(out, sid...)
+	out = append(out, p.Proof.Encode()...)
 	out = append(out, rid...)
 
 	return out, nil
 }
 
+// Signable returns the bytes the proof of p signs
+func (p *PingRequest) Signable() ([]byte, error) {
+	unsigned := *p
+	unsigned.Proof = identity.Proof{}
+	return unsigned.Bytes()
+}
+
 func (p *PingRequest) GetRandomID() string {
 	return p.RandomID
 }
@@ -104,3 +137,13 @@ func (p *PingRequest) GetSenderID() string {
 func (p *PingRequest) GetEchoRandomID() string {
 	return ""
 }
+
+// parseProof reads the proof at the end of the payload of a ping message. A payload without one gives an empty proof
+func parseProof(p []byte) identity.Proof {
+	if len(p) < identity.ProofSize {
+		return identity.Proof{}
+	}
+
+	proof, _ := identity.Decode(p[len(p)-identity.ProofSize:])
+	return proof
+}
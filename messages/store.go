@@ -3,11 +3,15 @@ package messages
 import (
 	"encoding/binary"
 	"github.com/alabianca/gokad"
+	"time"
 )
 
 type StoreRequestPayload struct {
 	Key   gokad.ID
 	Value gokad.Value
+	// TTL is how long the receiver should keep the value. It is sent with a resolution of seconds.
+	// A TTL of 0 lets the receiver pick its default expiry
+	TTL time.Duration
 }
 
 type StoreRequest struct {
@@ -31,18 +35,17 @@ func (n *StoreRequest) Bytes() ([]byte, error) {
 		return nil, err
 	}
 
-	key := make([]byte, len(n.Payload.Key))
-	copy(key, n.Payload.Key)
+	// the key and the value are serialized like a contact
+	value := SerializeContact(gokad.Contact{ID: n.Payload.Key, IP: n.Payload.Value.Host, Port: n.Payload.Value.Port})
 
-	port := make([]byte, 2)
-	binary.BigEndian.PutUint16(port, uint16(n.Payload.Value.Port))
+	ttl := make([]byte, 4)
+	binary.BigEndian.PutUint32(ttl, uint32(n.Payload.TTL/time.Second))
 
 	out := make([]byte, 0)
 	out = append(out, mkey...)
 	out = append(out, sid...)
-	out = append(out, key...)
-	out = append(out, port...)
-	out = append(out, n.Payload.Value.Host...)
+	out = append(out, value...)
+	out = append(out, ttl...)
 	out = append(out, rid...)
 
 	return out, nil
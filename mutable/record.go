@@ -0,0 +1,158 @@
+package mutable
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/alabianca/gokad"
+)
+
+const (
+	ErrInvalidSignature = "invalid signature"
+	ErrSaltTooLong      = "salt is too long"
+	ErrMalformed        = "malformed mutable record"
+	// MaxSaltSize is the longest salt a record may have
+	MaxSaltSize = 64
+)
+
+// Mutable records follow BitTorrent BEP-44. They are stored under the SHA-1 of the public key and salt.
+// The signature covers the bencoded salt, sequence number and value, so the same record can be checked
+// by anyone who knows the public key.
+//
+// Encoded record. All integers are big endian.
+// <- 32 Bytes  <- 8 Bytes  <- 64 Bytes  <- 1 Byte  <- X Bytes  <- 4 Bytes  <- X Bytes
+//  PublicKey    Seq         Signature    SaltLength  Salt        Length      Data
+
+// Record is a value signed by its publisher. A record replaces another record of the same key
+// only if its sequence number is higher
+type Record struct {
+	PublicKey ed25519.PublicKey
+	Salt      []byte
+	Seq       uint64
+	Data      []byte
+	Signature []byte
+}
+
+// Key returns the key a record of pub with salt is stored under
+func Key(pub ed25519.PublicKey, salt []byte) gokad.ID {
+	h := sha1.New()
+	h.Write(pub)
+	h.Write(salt)
+	return gokad.ID(h.Sum(nil))
+}
+
+// Sign returns the record holding data at sequence number seq, signed with priv
+func Sign(priv ed25519.PrivateKey, salt []byte, seq uint64, data []byte) (Record, error) {
+	if len(salt) > MaxSaltSize {
+		return Record{}, errors.New(ErrSaltTooLong)
+	}
+
+	r := Record{
+		PublicKey: priv.Public().(ed25519.PublicKey),
+		Salt:      salt,
+		Seq:       seq,
+		Data:      data,
+	}
+	r.Signature = ed25519.Sign(priv, signable(salt, seq, data))
+
+	return r, nil
+}
+
+// Key returns the key r is stored under
+func (r Record) Key() gokad.ID {
+	return Key(r.PublicKey, r.Salt)
+}
+
+// Verify checks that r was signed by the owner of r.PublicKey
+func (r Record) Verify() error {
+	if len(r.PublicKey) != ed25519.PublicKeySize || len(r.Signature) != ed25519.SignatureSize || len(r.Salt) > MaxSaltSize {
+		return errors.New(ErrInvalidSignature)
+	}
+
+	if !ed25519.Verify(r.PublicKey, signable(r.Salt, r.Seq, r.Data), r.Signature) {
+		return errors.New(ErrInvalidSignature)
+	}
+
+	return nil
+}
+
+// Supersedes reports whether r may replace old. That is the case if r has a higher sequence number,
+// or the same sequence number and the same data, which only refreshes old
+func (r Record) Supersedes(old Record) bool {
+	if r.Seq != old.Seq {
+		return r.Seq > old.Seq
+	}
+
+	return bytes.Equal(r.Data, old.Data)
+}
+
+// Encode returns the binary form of r
+func (r Record) Encode() []byte {
+	out := make([]byte, 0, ed25519.PublicKeySize+8+ed25519.SignatureSize+1+len(r.Salt)+4+len(r.Data))
+	out = append(out, r.PublicKey...)
+	seq := make([]byte, 8)
+	binary.BigEndian.PutUint64(seq, r.Seq)
+	out = append(out, seq...)
+	out = append(out, r.Signature...)
+	out = append(out, byte(len(r.Salt)))
+	out = append(out, r.Salt...)
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(r.Data)))
+	out = append(out, length...)
+	out = append(out, r.Data...)
+
+	return out
+}
+
+// Decode reads a record written by Encode from the start of b and returns it with the number of bytes read
+func Decode(b []byte) (Record, int, error) {
+	fixed := ed25519.PublicKeySize + 8 + ed25519.SignatureSize + 1
+	if len(b) < fixed {
+		return Record{}, 0, errors.New(ErrMalformed)
+	}
+
+	saltLen := int(b[fixed-1])
+	if saltLen > MaxSaltSize || len(b) < fixed+saltLen+4 {
+		return Record{}, 0, errors.New(ErrMalformed)
+	}
+
+	offset := fixed + saltLen
+	dataLen := int(binary.BigEndian.Uint32(b[offset : offset+4]))
+	offset += 4
+	if len(b)-offset < dataLen {
+		return Record{}, 0, errors.New(ErrMalformed)
+	}
+
+	r := Record{
+		PublicKey: ed25519.PublicKey(clone(b[:ed25519.PublicKeySize])),
+		Seq:       binary.BigEndian.Uint64(b[ed25519.PublicKeySize : ed25519.PublicKeySize+8]),
+		Signature: clone(b[ed25519.PublicKeySize+8 : fixed-1]),
+		Salt:      clone(b[fixed : fixed+saltLen]),
+		Data:      clone(b[offset : offset+dataLen]),
+	}
+
+	return r, offset + dataLen, nil
+}
+
+// signable returns what is signed for a record, the bencoded salt, seq and v as in BEP-44.
+// The salt is left out if it is empty
+func signable(salt []byte, seq uint64, data []byte) []byte {
+	var b bytes.Buffer
+	if len(salt) > 0 {
+		fmt.Fprintf(&b, "4:salt%d:", len(salt))
+		b.Write(salt)
+	}
+	fmt.Fprintf(&b, "3:seqi%de1:v%d:", seq, len(data))
+	b.Write(data)
+
+	return b.Bytes()
+}
+
+func clone(b []byte) []byte {
+	out := make([]byte, len(b))
+	copy(out, b)
+	return out
+}
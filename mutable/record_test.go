@@ -0,0 +1,76 @@
+package mutable
+
+import (
+	"crypto/ed25519"
+	"reflect"
+	"testing"
+)
+
+func TestRecord_SignVerify(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(nil)
+	r, err := Sign(priv, []byte("service"), 1, []byte(`{"port":8080}`))
+	if err != nil {
+		t.Fatalf("Expected err to be nil, but got %s\n", err)
+	}
+
+	if err := r.Verify(); err != nil {
+		t.Fatalf("Expected signature to be valid, but got %s\n", err)
+	}
+
+	tampered := []Record{r, r, r}
+	tampered[0].Seq++
+	tampered[1].Data = []byte(`{"port":9090}`)
+	tampered[2].Salt = []byte("other")
+	for _, x := range tampered {
+		if err := x.Verify(); err == nil || err.Error() != ErrInvalidSignature {
+			t.Fatalf("Expected %s for a tampered record, but got %v\n", ErrInvalidSignature, err)
+		}
+	}
+}
+
+func TestRecord_Key(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	r, _ := Sign(priv, []byte("a"), 1, []byte("x"))
+
+	if !reflect.DeepEqual(r.Key(), Key(pub, []byte("a"))) {
+		t.Fatalf("Expected record key to be derived from its public key and salt\n")
+	}
+
+	if reflect.DeepEqual(Key(pub, []byte("a")), Key(pub, []byte("b"))) {
+		t.Fatalf("Expected different salts to give different keys\n")
+	}
+}
+
+func TestRecord_Supersedes(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(nil)
+	v1, _ := Sign(priv, nil, 1, []byte("v1"))
+	v2, _ := Sign(priv, nil, 2, []byte("v2"))
+	other, _ := Sign(priv, nil, 2, []byte("other"))
+
+	if !v2.Supersedes(v1) || v1.Supersedes(v2) {
+		t.Fatalf("Expected only a higher sequence number to supersede a record\n")
+	}
+
+	if !v2.Supersedes(v2) || other.Supersedes(v2) {
+		t.Fatalf("Expected a record with the same sequence number to only refresh the same data\n")
+	}
+}
+
+func TestRecord_EncodeDecode(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(nil)
+	r, _ := Sign(priv, []byte("salt"), 42, []byte("data"))
+
+	b := append(r.Encode(), 1, 2, 3)
+	out, n, err := Decode(b)
+	if err != nil {
+		t.Fatalf("Expected err to be nil, but got %s\n", err)
+	}
+
+	if n != len(b)-3 || !reflect.DeepEqual(r, out) {
+		t.Fatalf("Expected %v after %d bytes, but got %v after %d\n", r, len(b)-3, out, n)
+	}
+
+	if _, _, err := Decode(b[:len(b)-5]); err == nil {
+		t.Fatalf("Expected %s for a truncated record\n", ErrMalformed)
+	}
+}
@@ -1,13 +1,23 @@
 package kadnet
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/ed25519"
 	"errors"
 	"github.com/alabianca/gokad"
-	"github.com/alabianca/kadnet/buffers"
+	"github.com/alabianca/kadnet/identity"
+	"github.com/alabianca/kadnet/kadclock"
 	"github.com/alabianca/kadnet/kadconn"
+	"github.com/alabianca/kadnet/kadlog"
 	"github.com/alabianca/kadnet/kadmux"
+	"github.com/alabianca/kadnet/kadrand"
 	"github.com/alabianca/kadnet/messages"
+	"github.com/alabianca/kadnet/mutable"
 	"github.com/alabianca/kadnet/response"
+	"github.com/alabianca/kadnet/storage"
+	"github.com/alabianca/kadnet/transaction"
 	"net"
 	"os"
 	"strconv"
@@ -29,7 +39,7 @@ type RpcPing interface {
 	Ping(contact gokad.Contact) (*response.Response, error)
 }
 type RpcStore interface {
-	Store(contact gokad.Contact, key gokad.ID, value gokad.Value) (*response.Response, error)
+	Store(contact gokad.Contact, key gokad.ID, value gokad.Value, ttl time.Duration) (*response.Response, error)
 }
 type RpcFindValue interface {
 	FindValue(contact gokad.Contact, hash string) (*response.Response, error)
@@ -37,33 +47,162 @@ type RpcFindValue interface {
 
 type NodeConfig func(*Node)
 
+// WithConn lets the node use conn instead of listening on Host and Port. See Node.Conn
+func WithConn(conn kadconn.KadConn) NodeConfig {
+	return func(n *Node) {
+		n.Conn = conn
+	}
+}
+
+// WithTransport lets the node send and receive messages over transport. See Node.Transport
+func WithTransport(transport kadconn.Transport) NodeConfig {
+	return func(n *Node) {
+		n.Transport = transport
+	}
+}
+
+// WithClock lets the node keep time on clock. See Node.Clock
+func WithClock(clock kadclock.Clock) NodeConfig {
+	return func(n *Node) {
+		n.Clock = clock
+	}
+}
+
+// WithRandom lets the node take its random ids from random. See Node.Random
+func WithRandom(random kadrand.RandomSource) NodeConfig {
+	return func(n *Node) {
+		n.Random = random
+	}
+}
+
+const ErrIdentityMismatch = "node id is not the id of its identity"
+const ErrNodeShutdown = "node is shut down"
+
+// BootstrapReport summarizes what a node learned during Bootstrap
+type BootstrapReport struct {
+	// BucketsFilled is the number of k-buckets that were empty before the bootstrap and are not anymore
+	BucketsFilled int
+	// ContactsLearned is the number of contacts that were added to the routing table
+	ContactsLearned int
+	// Refreshed is the number of bucket refresh lookups in step 3 that succeeded
+	Refreshed int
+	// RefreshFailed is the number of bucket refresh lookups in step 3 that failed
+	RefreshFailed int
+}
+
 type Node struct {
 	K            int
 	Alpha        int
 	RoundTimeout time.Duration
-	Host         string
-	Port         int
-	dht          *dhtProxy
-	conn         kadconn.KadConn
-	mux          kadmux.Mux
-	started      chan bool
+	// Host is the address the node listens on. It may be an IPv6 address. An unspecified address like "::" or ""
+	// listens dual-stack, so the node reaches and is reached by IPv4 and IPv6 nodes alike
+	Host string
+	Port int
+	// RefreshInterval is the time after which an unused k-bucket is refreshed (tRefresh).
+	// The routing table is not maintained in the background if it is 0
+	RefreshInterval time.Duration
+	// RefreshCheckInterval is how often the routing table is checked for buckets that need a refresh
+	RefreshCheckInterval time.Duration
+	// ExpireInterval is how long a stored value is kept (tExpire).
+	// It is the TTL of values published by this node and the maximum TTL accepted from others
+	ExpireInterval time.Duration
+	// ReplicateInterval is how often values held by this node are replicated to the k closest nodes (tReplicate)
+	ReplicateInterval time.Duration
+	// RepublishInterval is how often values published by this node are stored again (tRepublish)
+	RepublishInterval time.Duration
+	// RepublishCheckInterval is how often stored values are checked for expiry, replication and republishing.
+	// Values are neither evicted nor republished in the background if it is 0
+	RepublishCheckInterval time.Duration
+	// SnapshotPath is the file the routing table is saved to on Shutdown. If the file exists when the node
+	// starts listening, its contacts are pinged and the live ones are inserted into the routing table
+	SnapshotPath string
+	// Values holds the values this node stores for the network. It defaults to an in-memory store
+	Values storage.ValueStore
+	// MaxValueSize is the size in bytes of the largest opaque value this node publishes with Put or accepts from others.
+	// Values are sent in a single datagram, so large values are fragmented by IP. It is capped at messages.MaxDataSize
+	MaxValueSize int
+	// Conn is used to send and receive messages instead of a UDP socket on Host and Port if it is set.
+	// Host and Port must still be the address other nodes reach it at. Key and RequireSecure are not applied to it
+	Conn kadconn.KadConn
+	// Transport opens the connection on Host and Port. It defaults to kadconn.UDP(). kadconn.TCP reaches nodes in networks
+	// that block UDP, and kadconn.Negotiated(kadconn.NegotiatedConfig{}, kadconn.UDP(), kadconn.TCP(...)) answers every peer on the transport it used
+	Transport kadconn.Transport
+	// Key is the long-term X25519 key of the node. If it is set, messages are sealed with session keys established
+	// with every peer in a handshake. See kadconn.NewSecure
+	Key *ecdh.PrivateKey
+	// RequireSecure drops every message that is not sealed, so nodes without a Key are rejected. It has no effect without Key
+	RequireSecure bool
+	// Authorize decides if the peer with the long-term key key may send messages as the node id, so an id can be
+	// pinned to the key it is known by. key is nil for plain messages. It has no effect without Key.
+	// nil binds an id to the first key that sent a sealed message with it
+	Authorize func(id gokad.ID, key *ecdh.PublicKey) bool
+	// Identity binds the id of the node to a key, S/Kademlia style. If it is set, the id of the node must be Identity.ID(),
+	// pings prove the id of their sender and contacts are only inserted into the routing table once they proved theirs
+	Identity *identity.Identity
+	// Difficulty is the number of leading zero bits the SHA-1 of every id needs if Identity is set. See identity.Generate
+	Difficulty int
+	// Clock is the source of time of the node. Timeouts, expiries and the intervals of the background threads pass on it.
+	// It defaults to the wall clock. It must be set by a NodeConfig, as NewNode hands it to the routing table
+	Clock kadclock.Clock
+	// Random produces the RandomIDs of messages and the ids bucket refreshes look up. It defaults to kadrand.Crypto().
+	// A seeded source makes the messages of a node reproducible
+	Random kadrand.RandomSource
+	// Logger receives the node's log entries. Requests are logged at the debug level.
+	// It defaults to a logger writing info and above to stderr. Set it to kadlog.Nop() to disable logging
+	Logger     kadlog.Logger
+	dht        *dhtProxy
+	conn       kadconn.KadConn
+	mux        kadmux.Mux
+	started    chan bool
+	background []chan chan error // exit channels of the threads started in Listen. Guarded by mtx
+	shutdown   bool              // set once Shutdown ran, so Listen does not start background threads anymore
+	mtx        sync.Mutex
+	published  map[string]publication
+	events     *eventBus
+	// expected holds the implicit PingReplies this node expects for the requests it answered
+	expected *transaction.Table
+	// valuesMtx keeps the handlers and the republisher from replacing a stored record they did not read
+	valuesMtx sync.Mutex
+}
+
+// publication is a value this node stored in the network with Store or Put
+type publication struct {
+	record    storage.Record
+	published time.Time
 }
 
 func NewNode(dht *gokad.DHT, configs ...NodeConfig) *Node {
 	n := &Node{
-		dht:          newDhtProxy(dht),
-		K:            20,
-		Alpha:        3,
-		RoundTimeout: time.Second * 3,
-		Host:         "127.0.0.1",
-		Port:         5000,
-		started:      make(chan bool, 1),
+		K:                      20,
+		Alpha:                  3,
+		RoundTimeout:           time.Second * 3,
+		Host:                   "127.0.0.1",
+		Port:                   5000,
+		RefreshInterval:        time.Hour,
+		RefreshCheckInterval:   time.Minute,
+		ExpireInterval:         time.Hour * 24,
+		ReplicateInterval:      time.Hour,
+		RepublishInterval:      time.Hour * 24,
+		RepublishCheckInterval: time.Minute,
+		started:                make(chan bool, 1),
+		Values:                 storage.NewMemoryStore(),
+		MaxValueSize:           1024,
+		Logger:                 kadlog.New(os.Stderr, kadlog.Info),
+		Transport:              kadconn.UDP(),
+		Clock:                  kadclock.Real(),
+		Random:                 kadrand.Crypto(),
+		published:              make(map[string]publication),
 	}
 
 	for _, config := range configs {
 		config(n)
 	}
 
+	// events, the routing table and the expected replies keep time on the clock the configs chose
+	n.events = newEventBus(n.Clock)
+	n.dht = newDhtProxy(dht, n.events, n.Clock)
+	n.expected = transaction.NewTableWithClock(n.Clock)
+
 	return n
 }
 
@@ -83,30 +222,64 @@ func NewNode(dht *gokad.DHT, configs ...NodeConfig) *Node {
 https://pub.tik.ee.ethz.ch/students/2006-So/SA-2006-19.pdf
 **/
 func (n *Node) Bootstrap(port int, ip string) error {
-	// 1. Insert Gateway into k-bucket
-	c, err := n.pingAndGetFirst(net.ParseIP(ip), port)
+	return n.BootstrapContext(context.Background(), port, ip)
+}
+
+// BootstrapContext is like Bootstrap but stops once ctx is done
+func (n *Node) BootstrapContext(ctx context.Context, port int, ip string) error {
+	_, err := n.BootstrapWithReport(ctx, port, ip)
+	return err
+}
+
+// BootstrapWithReport is like BootstrapContext and reports what the node learned
+func (n *Node) BootstrapWithReport(ctx context.Context, port int, ip string) (BootstrapReport, error) {
+	var report BootstrapReport
+	bucketsBefore, contactsBefore := n.dht.stats()
+
+	// 1. Insert Gateway into k-bucket. We don't know the gateway's id yet
+	c, err := n.ping(ctx, net.ParseIP(ip), port, nil)
 	if err != nil {
-		return err
+		return report, err
 	}
 
 	if _, _, err := n.dht.insert(c); err != nil {
-		return err
+		return report, err
 	}
 
 	// 2. node lookup for own id
-	if _, err := n.Lookup(n.ID()); err != nil {
-		return err
+	if _, err := n.LookupContext(ctx, n.ID()); err != nil {
+		return report, err
 	}
 
-	// 3. @todo
+	// 3. node lookups for a random id in the range of every k-bucket above the lowest non-empty one.
+	// a failed refresh only means that part of the id space stays empty for now. It does not fail the bootstrap
+	if lowest, ok := n.dht.lowestNonEmptyBucket(); ok {
+		for index := lowest + 1; index < gokad.MaxRoutingTableSize; index++ {
+			_, err := n.LookupContext(ctx, randomIDInBucket(n.Random, n.ID(), index))
+			if err != nil && ctx.Err() != nil {
+				return report, ctx.Err()
+			}
+			if err != nil {
+				report.RefreshFailed++
+				continue
+			}
+			report.Refreshed++
+		}
+	}
 
-	return nil
+	bucketsAfter, contactsAfter := n.dht.stats()
+	report.BucketsFilled = bucketsAfter - bucketsBefore
+	report.ContactsLearned = contactsAfter - contactsBefore
 
+	return report, nil
 }
+
 func (n *Node) ID() gokad.ID {
 	return n.dht.getOwnID()
 }
 
+// Walk calls f for every contact of the routing table and the index of its k-bucket.
+// It walks a copy of the table, so contacts may be inserted concurrently and f may use the node
 func (n *Node) Walk(f func(index int, c gokad.Contact)) {
 	n.dht.walk(f)
 }
@@ -117,123 +290,435 @@ func (n *Node) Seed(cs ...gokad.Contact) {
 	}
 }
 
-func (n *Node) Shutdown() {
+// Subscribe returns a channel that receives the events of the node and a function to stop receiving them.
+// Up to buffer events are queued. Events are dropped rather than block the node if the channel is full
+func (n *Node) Subscribe(buffer int) (<-chan Event, func()) {
+	return n.events.subscribe(buffer)
+}
+
+// UnmatchedResponses returns the number of responses that were dropped because
+// no pending request was waiting for them
+func (n *Node) UnmatchedResponses() uint64 {
+	if n.mux == nil {
+		return 0
+	}
+
+	return n.mux.Transactions().Unmatched()
+}
+
+// Evictions returns the number of contacts that were evicted from the routing table
+// because they failed to answer a liveness check
+func (n *Node) Evictions() uint64 {
+	return n.dht.evictions()
+}
+
+// Shutdown stops the node. If SnapshotPath is set the routing table is saved first
+// and the error of saving it is returned
+func (n *Node) Shutdown() error {
+	var err error
+	if n.SnapshotPath != "" {
+		err = n.saveSnapshot(n.SnapshotPath)
+	}
+
+	n.mtx.Lock()
+	n.shutdown = true
+	background := n.background
+	n.background = nil
+	n.mtx.Unlock()
+
+	for _, exit := range background {
+		stopped := make(chan error)
+		exit <- stopped
+		<-stopped
+	}
+
 	if n.mux != nil {
 		n.mux.Close()
 	}
+
+	// Listen closes the conn only after the mux stopped. Close it here, so the address is free once Shutdown returns
+	n.mtx.Lock()
+	c := n.conn
+	n.mtx.Unlock()
+	if c != nil {
+		c.Close()
+	}
+
+	return err
 }
 
 func (n *Node) Listen(mux kadmux.Mux) error {
 	if mux == nil {
 		mux = defaultMux()
 	}
+	if n.Identity != nil {
+		if !bytes.Equal(n.Identity.ID(), n.ID()) {
+			return errors.New(ErrIdentityMismatch)
+		}
+		if !identity.Solves(n.ID(), n.Difficulty) {
+			return errors.New(identity.ErrPuzzleNotSolved)
+		}
+		n.dht.requireProof(n.Difficulty)
+	}
+
 	n.mux = mux
+	n.mux.SetLogger(n.Logger)
+	n.mux.SetClock(n.Clock)
 	n.registerRequestHandlers()
 
 	c, err := n.listen()
 	if err != nil {
+		n.events.publish(Event{Type: ListenError, Err: err})
 		return err
 	}
+	var snapshot []gokad.Contact
+	reseed := false
+	if n.SnapshotPath != "" {
+		var err error
+		snapshot, err = n.readSnapshotFile(n.SnapshotPath)
+		reseed = err == nil
+	}
+
+	n.mtx.Lock()
+	if n.shutdown {
+		n.mtx.Unlock()
+		c.Close()
+		return errors.New(ErrNodeShutdown)
+	}
 	n.conn = c
+	n.runInBackground(newEvictor(n).Run)
+	if n.Identity != nil {
+		n.runInBackground(newProver(n).Run)
+	}
+	if reseed {
+		n.runInBackground(newReseeder(n, snapshot).Run)
+	}
+	if n.RefreshInterval > 0 && n.RefreshCheckInterval > 0 {
+		n.runInBackground(newMaintainer(n).Run)
+	}
+	if n.RepublishCheckInterval > 0 {
+		n.runInBackground(newRepublisher(n).Run)
+	}
+	n.mtx.Unlock()
+
 	n.started <- true
+	n.events.publish(Event{Type: Listening})
 
 	defer c.Close()
 
-	return n.mux.Handle(c)
+	if err := n.mux.Handle(c); err != nil {
+		n.events.publish(Event{Type: ListenError, Err: err})
+		return err
+	}
+
+	return nil
 }
 
 func (n *Node) Ping(host net.IP, port int, id gokad.ID) (gokad.Contact, error) {
+	return n.PingContext(context.Background(), host, port, id)
+}
+
+// PingContext is like Ping but stops waiting for the reply once ctx is done
+func (n *Node) PingContext(ctx context.Context, host net.IP, port int, id gokad.ID) (gokad.Contact, error) {
 	if id == nil {
 		return gokad.Contact{}, errors.New("id not provided")
 	}
 
-	return n.ping(host, port, id)
+	return n.ping(ctx, host, port, id)
+
+}
+
+// Store stores key at the k closest nodes. It fails with ErrStoreQuorum if not a single one acknowledged
+func (n *Node) Store(key string, ip net.IP, port int) (StoreResult, error) {
+	return n.StoreContext(context.Background(), key, ip, port)
+}
 
+// StoreContext is like Store. Once ctx is done all outstanding STORE_RPC's are abandoned
+// and ctx.Err() is returned.
+// The value expires after ExpireInterval unless this node republishes it every RepublishInterval
+func (n *Node) StoreContext(ctx context.Context, key string, ip net.IP, port int) (StoreResult, error) {
+	return n.StoreWithOptions(ctx, key, ip, port, StoreOptions{})
 }
 
-func (n *Node) Store(key string, ip net.IP, port int) (int, error) {
-	buf := n.getBuffer(kadmux.StoreReplyBufferID)
-	if buf == nil {
-		return 0, errors.New("could not open buffer")
+// StoreWithOptions is like StoreContext but only succeeds once opts are satisfied
+func (n *Node) StoreWithOptions(ctx context.Context, key string, ip net.IP, port int, opts StoreOptions) (StoreResult, error) {
+	keyID, err := gokad.From(key)
+	if err != nil {
+		return StoreResult{}, err
 	}
-	buf.Open()
-	defer buf.Close()
 
+	return n.publish(ctx, storage.Record{Key: keyID, Value: gokad.Value{Host: ip, Port: port}}, opts)
+}
+
+// Put stores the opaque value data for key at the k closest nodes. Other nodes keep a single value per key,
+// so a later Put for key replaces data. It fails with ErrStoreQuorum if not a single one acknowledged
+func (n *Node) Put(key string, data []byte) (StoreResult, error) {
+	return n.PutContext(context.Background(), key, data)
+}
+
+// PutContext is like Put. Once ctx is done all outstanding STORE_DATA_RPC's are abandoned
+// and ctx.Err() is returned
+func (n *Node) PutContext(ctx context.Context, key string, data []byte) (StoreResult, error) {
+	return n.PutWithOptions(ctx, key, data, StoreOptions{})
+}
+
+// PutWithOptions is like PutContext but only succeeds once opts are satisfied
+func (n *Node) PutWithOptions(ctx context.Context, key string, data []byte, opts StoreOptions) (StoreResult, error) {
 	keyID, err := gokad.From(key)
 	if err != nil {
-		return 0, err
+		return StoreResult{}, err
+	}
+
+	if len(data) > n.MaxValueSize || len(data) > messages.MaxDataSize {
+		return StoreResult{}, errors.New(ErrValueTooLarge)
+	}
+
+	// a nil slice would make the record a provider record
+	if data == nil {
+		data = []byte{}
 	}
 
-	cs, err := n.Lookup(keyID)
+	return n.publish(ctx, storage.Record{Key: keyID, Data: data}, opts)
+}
+
+// PutMutable signs data with priv and stores it at the k closest nodes to mutable.Key(pub, salt).
+// Other nodes only replace a mutable record with one of a higher seq. It fails with ErrStoreQuorum if not a single one acknowledged
+func (n *Node) PutMutable(priv ed25519.PrivateKey, salt []byte, seq uint64, data []byte) (StoreResult, error) {
+	return n.PutMutableContext(context.Background(), priv, salt, seq, data)
+}
+
+// PutMutableContext is like PutMutable. Once ctx is done all outstanding STORE_MUTABLE_RPC's are abandoned
+// and ctx.Err() is returned
+func (n *Node) PutMutableContext(ctx context.Context, priv ed25519.PrivateKey, salt []byte, seq uint64, data []byte) (StoreResult, error) {
+	return n.PutMutableWithOptions(ctx, priv, salt, seq, data, StoreOptions{})
+}
+
+// PutMutableWithOptions is like PutMutableContext but only succeeds once opts are satisfied
+func (n *Node) PutMutableWithOptions(ctx context.Context, priv ed25519.PrivateKey, salt []byte, seq uint64, data []byte, opts StoreOptions) (StoreResult, error) {
+	if len(data) > n.MaxValueSize || len(data) > messages.MaxDataSize {
+		return StoreResult{}, errors.New(ErrValueTooLarge)
+	}
+
+	if data == nil {
+		data = []byte{}
+	}
+
+	record, err := mutable.Sign(priv, salt, seq, data)
 	if err != nil {
-		return 0, err
+		return StoreResult{}, err
 	}
 
-	client := n.NewClient()
-	storeSent := make(chan int)
-	var wg sync.WaitGroup
-	wg.Add(len(cs))
-	for _, c := range cs {
-		go func(contact gokad.Contact) {
-			defer wg.Done()
-			res, err := client.Store(contact, keyID, gokad.Value{Host: ip, Port: port})
-			if err != nil {
-				storeSent <- 0
-			}
+	return n.publish(ctx, storage.Record{Key: record.Key(), Data: data, Mutable: &record}, opts)
+}
+
+// Unpublish stops republishing every value this node published for key with Store, Put or PutMutable.
+// Nodes that hold them drop them once their TTL ran out
+func (n *Node) Unpublish(key string) error {
+	keyID, err := gokad.From(key)
+	if err != nil {
+		return err
+	}
+
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	for k, p := range n.published {
+		if bytes.Equal(p.record.Key, keyID) {
+			delete(n.published, k)
+		}
+	}
+
+	return nil
+}
+
+// publish stores r in the network. If the store succeeds r is remembered, so it is republished every RepublishInterval
+func (n *Node) publish(ctx context.Context, r storage.Record, opts StoreOptions) (StoreResult, error) {
+	published := n.Clock.Now()
+	res, err := n.store(ctx, r, n.ExpireInterval, opts)
+	if err != nil {
+		return res, err
+	}
+
+	n.mtx.Lock()
+	n.published[publicationKey(r)] = publication{record: r, published: published}
+	n.mtx.Unlock()
+
+	return res, nil
+}
+
+// Get returns the opaque value stored for key with Put. If nodes hold different values, the one most of them
+// returned wins and ties go to the smallest value. It fails with ErrValueNotFound if no node holds it
+func (n *Node) Get(key string) ([]byte, error) {
+	return n.GetContext(context.Background(), key)
+}
+
+// GetContext is like Get but gives up once ctx is done and returns ctx.Err()
+func (n *Node) GetContext(ctx context.Context, key string) ([]byte, error) {
+	return n.GetWithOptions(ctx, key, LookupOptions{})
+}
+
+// GetWithOptions is like GetContext but opts override the node's lookup settings for this lookup
+func (n *Node) GetWithOptions(ctx context.Context, key string, opts LookupOptions) ([]byte, error) {
+	keyID, err := gokad.From(key)
+	if err != nil {
+		return nil, err
+	}
 
-			res.Read(&messages.StoreResponse{})
-			storeSent <- 1
-		}(c)
+	lp, err := nodeLookup(func(l *lookup) {
+		l.dht = n.dht
+		l.client = n.NewClient()
+		l.isDataLookup = true
+		l.events = n.events
+		l.k = n.K
+		l.concurrency = n.Alpha
+		l.roundTimeout = n.RoundTimeout
+		l.clock = n.Clock
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if lp, err = lp.withOptions(opts); err != nil {
+		return nil, err
+	}
+
+	res, err := lp.find(ctx, keyID)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	if err == context.DeadlineExceeded {
+		return nil, err
+	}
+	if err != nil || len(res.data) == 0 {
+		return nil, errors.New(ErrValueNotFound)
 	}
 
-	go func() {
-		wg.Wait()
-		close(storeSent)
-	}()
+	return majority(res.data), nil
+}
 
-	var total int
-	for n := range storeSent {
-		total += n
+// majority returns the value that occurs most often in values. Ties go to the smallest value
+func majority(values [][]byte) []byte {
+	counts := make(map[string]int)
+	var best []byte
+	for _, v := range values {
+		counts[string(v)]++
+		if n, m := counts[string(v)], counts[string(best)]; best == nil || n > m || n == m && bytes.Compare(v, best) < 0 {
+			best = v
+		}
 	}
 
-	return total, nil
+	return best
+}
+
+// GetMutable returns the mutable record of pub and salt with the highest seq held by the k closest nodes.
+// Records with an invalid signature are ignored. It fails with ErrValueNotFound if no node holds a valid one
+func (n *Node) GetMutable(pub ed25519.PublicKey, salt []byte) (mutable.Record, error) {
+	return n.GetMutableContext(context.Background(), pub, salt)
+}
+
+// GetMutableContext is like GetMutable but gives up once ctx is done and returns ctx.Err()
+func (n *Node) GetMutableContext(ctx context.Context, pub ed25519.PublicKey, salt []byte) (mutable.Record, error) {
+	return n.GetMutableWithOptions(ctx, pub, salt, LookupOptions{})
+}
+
+// GetMutableWithOptions is like GetMutableContext but opts override the node's lookup settings for this lookup
+func (n *Node) GetMutableWithOptions(ctx context.Context, pub ed25519.PublicKey, salt []byte, opts LookupOptions) (mutable.Record, error) {
+	key := mutable.Key(pub, salt)
+	lp, err := nodeLookup(func(l *lookup) {
+		l.dht = n.dht
+		l.client = n.NewClient()
+		l.isMutableLookup = true
+		l.events = n.events
+		l.k = n.K
+		l.concurrency = n.Alpha
+		l.roundTimeout = n.RoundTimeout
+		l.clock = n.Clock
+	})
+	if err != nil {
+		return mutable.Record{}, err
+	}
+
+	if lp, err = lp.withOptions(opts); err != nil {
+		return mutable.Record{}, err
+	}
+
+	res, err := lp.find(ctx, key)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return mutable.Record{}, ctxErr
+	}
+	if err == context.DeadlineExceeded {
+		return mutable.Record{}, err
+	}
+
+	var latest *mutable.Record
+	for i, r := range res.records {
+		if !bytes.Equal(r.PublicKey, pub) || !bytes.Equal(r.Salt, salt) || r.Verify() != nil {
+			continue
+		}
+		if latest == nil || r.Seq > latest.Seq {
+			latest = &res.records[i]
+		}
+	}
+
+	if latest == nil {
+		return mutable.Record{}, errors.New(ErrValueNotFound)
+	}
+
+	return *latest, nil
 }
 
 func (n *Node) Lookup(id gokad.ID) ([]gokad.Contact, error) {
+	return n.LookupContext(context.Background(), id)
+}
+
+// LookupContext is like Lookup but is cancelled once ctx is done.
+// All goroutines of the lookup are stopped and ctx.Err() is returned
+func (n *Node) LookupContext(ctx context.Context, id gokad.ID) ([]gokad.Contact, error) {
+	return n.LookupWithOptions(ctx, id, LookupOptions{})
+}
+
+// LookupWithOptions is like LookupContext but opts override the node's lookup settings for this lookup
+func (n *Node) LookupWithOptions(ctx context.Context, id gokad.ID, opts LookupOptions) ([]gokad.Contact, error) {
 	nodeLp, err := nodeLookup(func(l *lookup) {
 		l.dht = n.dht
-		l.buffer = n.getBuffer(kadmux.NodeReplyBufferID)
 		l.client = n.NewClient()
 		l.isNodeLookup = true
+		l.events = n.events
+		l.k = n.K
+		l.concurrency = n.Alpha
+		l.roundTimeout = n.RoundTimeout
+		l.clock = n.Clock
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	return nodeLp.do(id)
+	if nodeLp, err = nodeLp.withOptions(opts); err != nil {
+		return nil, err
+	}
+
+	return nodeLp.do(ctx, id)
 
 }
 
 func (n *Node) NewClient() *Client {
-	nodeReplyBuf, _ := n.getBuffer(kadmux.NodeReplyBufferID).(*buffers.NodeReplyBuffer)
-	pingReplyBuf, _ := n.getBuffer(kadmux.PingReplyBufferID).(*buffers.PingReplyBuffer)
-	storeReplyBuf, _ := n.getBuffer(kadmux.StoreReplyBufferID).(*buffers.StoreReplyBuffer)
-	valueReplyBuf, _ := n.getBuffer(kadmux.ValueReplyBufferID).(*buffers.NodeReplyBuffer)
 	return &Client{
-		ID:               n.dht.getOwnID(),
-		Writer:           n.conn,
-		NodeReplyBuffer:  nodeReplyBuf,
-		PingReplyBuffer:  pingReplyBuf,
-		StoreReplyBuffer: storeReplyBuf,
-		ValueReplyBuffer: valueReplyBuf,
+		ID:           n.dht.getOwnID(),
+		Writer:       n.conn,
+		Transactions: n.mux.Transactions(),
+		Identity:     n.Identity,
+		Random:       n.Random,
 	}
 }
 
 func (n *Node) NewResolver() (*Resolver, error) {
 	lp, err := nodeLookup(func(l *lookup) {
 		l.dht = n.dht
-		l.buffer = n.getBuffer(kadmux.ValueReplyBufferID)
 		l.client = n.NewClient()
+		l.events = n.events
+		l.k = n.K
+		l.concurrency = n.Alpha
+		l.roundTimeout = n.RoundTimeout
+		l.clock = n.Clock
 	})
 
 	if err != nil {
@@ -248,34 +733,64 @@ func (n *Node) NewResolver() (*Resolver, error) {
 	return &r, nil
 }
 
-func (n *Node) getBuffer(key string) buffers.Buffer {
-	return n.mux.GetBuffer(key)
+// runInBackground starts run in its own goroutine. It is stopped on Shutdown.
+// n.mtx must be held
+func (n *Node) runInBackground(run func(exit <-chan chan error)) {
+	exit := make(chan chan error)
+	n.background = append(n.background, exit)
+	go run(exit)
+}
+
+// isPublisher reports whether this node published r
+func (n *Node) isPublisher(r storage.Record) bool {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	_, ok := n.published[publicationKey(r)]
+	return ok
+}
 
+// publicationKey identifies a publication by its key and provider, so a node can publish several providers for a key
+func publicationKey(r storage.Record) string {
+	return r.Key.String() + "/" + r.Provider()
 }
 
 func (n *Node) registerRequestHandlers() {
 	// register middlewares
-	pingReplyBuffer := n.getBuffer(kadmux.PingReplyBufferID)
 	n.mux.Use(
-		kadmux.Logging(os.Stdout),               // Log requests to stdout
-		kadmux.ExpectPingReply(pingReplyBuffer), // write the expected PingReplyMessage to the buffer
+		kadmux.LogRequests(n.Logger),       // Log requests
+		kadmux.ExpectPingReply(n.expected), // register the PingReply we expect after responding
+		observeRequests(n.events),          // publish a RequestReceived event
 	)
 	// handlers to run after middlewares executed
-	n.mux.HandleFunc(messages.FindNodeReq, onFindNode(n.dht))
-	n.mux.HandleFunc(messages.PingResImplicit, onPingReplyImplicit(n.dht, pingReplyBuffer))
-	n.mux.HandleFunc(messages.PingReq, onPingRequest(n.ID()))
-	n.mux.HandleFunc(messages.StoreReq, onStoreRequest(n.ID(), n.dht))
-	n.mux.HandleFunc(messages.FindValueReq, onFindValue(n.dht))
+	n.mux.HandleFunc(messages.FindNodeReq, onFindNode(n.dht, n.Random, n.Logger))
+	n.mux.HandleFunc(messages.PingResImplicit, onPingReplyImplicit(n.dht, n.expected, n.Identity, n.Difficulty, n.Logger))
+	n.mux.HandleFunc(messages.PingReq, onPingRequest(n.ID(), n.Identity, n.Difficulty, n.Random, n.Logger))
+	// stores and lookups of data and mutable records look at the record they replace or return.
+	// n.valuesMtx keeps them from interleaving with each other and with the republisher
+	guard := &n.valuesMtx
+	n.mux.HandleFunc(messages.StoreReq, onStoreRequest(n.ID(), n.Values, guard, n.ExpireInterval, n.events, n.Clock, n.Random, n.Logger))
+	n.mux.HandleFunc(messages.FindValueReq, onFindValue(n.dht, n.Values, n.K, n.Clock, n.Random, n.Logger))
+	n.mux.HandleFunc(messages.StoreDataReq, onStoreDataRequest(n.ID(), n.Values, guard, n.ExpireInterval, n.MaxValueSize, n.events, n.Clock, n.Random, n.Logger))
+	n.mux.HandleFunc(messages.FindDataReq, onFindData(n.dht, n.Values, guard, n.Clock, n.Random, n.Logger))
+	n.mux.HandleFunc(messages.StoreMutableReq, onStoreMutableRequest(n.ID(), n.Values, guard, n.ExpireInterval, n.MaxValueSize, n.events, n.Clock, n.Random, n.Logger))
+	n.mux.HandleFunc(messages.FindMutableReq, onFindMutable(n.dht, n.Values, guard, n.Clock, n.Random, n.Logger))
 }
 
 func (n *Node) listen() (kadconn.KadConn, error) {
-	conn, err := net.ListenPacket("udp", net.JoinHostPort(n.Host, strconv.Itoa(n.Port)))
+	if n.Conn != nil {
+		return n.Conn, nil
+	}
+
+	conn, err := n.Transport.ListenPacket(net.JoinHostPort(n.Host, strconv.Itoa(n.Port)))
+	if err == nil && n.Key != nil {
+		return kadconn.NewSecure(conn, kadconn.SecureConfig{Key: n.Key, Required: n.RequireSecure, AuthorizeSender: n.Authorize, Clock: n.Clock}), nil
+	}
 
 	return kadconn.New(conn), err
 }
 
-func (n *Node) ping(host net.IP, port int, id gokad.ID) (gokad.Contact, error) {
-	res, err := n.sendPing(host, port, id)
+func (n *Node) ping(ctx context.Context, host net.IP, port int, id gokad.ID) (gokad.Contact, error) {
+	res, err := n.sendPing(ctx, host, port, id)
 	if err != nil {
 		return gokad.Contact{}, err
 	}
@@ -291,37 +806,16 @@ func (n *Node) ping(host net.IP, port int, id gokad.ID) (gokad.Contact, error) {
 		return gokad.Contact{}, err
 	}
 
-	return gokad.Contact{ID: senderId, IP: host, Port: port}, nil
-}
-
-// pingAndGetFirst pings the node at the given host and port
-// and returns the first pingResponse it has in its buffer
-// this function should only be used in the bootstrap procedure
-// since we don't know our gateway's id yet
-func (n *Node) pingAndGetFirst(host net.IP, port int) (gokad.Contact, error) {
-
-	res, err := n.sendPing(host, port, nil)
-	if err != nil {
-		return gokad.Contact{}, err
-	}
-
-	prb, _ := res.Body.(*buffers.PingReplyBuffer)
-	var pr messages.PingResponse
-	prb.First(&pr)
-
-	if pr.SenderID == "" {
-		return gokad.Contact{}, errors.New("could not get first message of ping reply buffer")
+	if n.Identity != nil {
+		if err := verifyPingResponse(&pr, n.Difficulty); err != nil {
+			return gokad.Contact{}, err
+		}
 	}
 
-	idr, err := gokad.From(pr.SenderID)
-	if err != nil {
-		return gokad.Contact{}, err
-	}
-
-	return gokad.Contact{ID: idr, IP: host, Port: port}, nil
+	return gokad.Contact{ID: senderId, IP: host, Port: port}, nil
 }
 
-func (n *Node) sendPing(host net.IP, port int, id gokad.ID) (*response.Response, error) {
+func (n *Node) sendPing(ctx context.Context, host net.IP, port int, id gokad.ID) (*response.Response, error) {
 	client := n.NewClient()
 	contact := gokad.Contact{
 		ID:   id,
@@ -329,7 +823,27 @@ func (n *Node) sendPing(host net.IP, port int, id gokad.ID) (*response.Response,
 		Port: port,
 	}
 
-	return client.Ping(contact)
+	return client.PingContext(ctx, contact)
+}
+
+// randomIDInBucket returns a random id that falls into the k-bucket with the given index
+// as seen from own. The distance to own has its highest bit at index and random bits below it.
+func randomIDInBucket(random kadrand.RandomSource, own gokad.ID, index int) gokad.ID {
+	distance := random.ID()
+	bitPos := gokad.SIZE*8 - 1 - index // position of the highest bit counted from the most significant bit
+	byteIndex := bitPos / 8
+	for i := 0; i < byteIndex; i++ {
+		distance[i] = 0
+	}
+	mask := byte(0x80) >> uint(bitPos%8)
+	distance[byteIndex] = (distance[byteIndex] & (mask - 1)) | mask
+
+	id := make(gokad.ID, len(own))
+	for i := range own {
+		id[i] = own[i] ^ distance[i]
+	}
+
+	return id
 }
 
 func defaultMux() kadmux.Mux {
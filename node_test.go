@@ -1,10 +1,24 @@
 package kadnet
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/ed25519"
 	"github.com/alabianca/gokad"
+	"github.com/alabianca/kadnet/identity"
+	"github.com/alabianca/kadnet/kadclock"
+	"github.com/alabianca/kadnet/kadconn"
+	"github.com/alabianca/kadnet/kadlog"
+	"github.com/alabianca/kadnet/kadrand"
+	"github.com/alabianca/kadnet/memnet"
 	"github.com/alabianca/kadnet/messages"
+	"github.com/alabianca/kadnet/mutable"
+	"github.com/alabianca/kadnet/storage"
+	"github.com/alabianca/kadnet/transaction"
 	"net"
 	"reflect"
+	"strconv"
 	"sync"
 	"testing"
 	"time"
@@ -154,7 +168,7 @@ func TestNode_Ping(t *testing.T) {
 	<-node2.started
 	<-node1.started
 
-	c, err := node1.pingAndGetFirst(net.ParseIP("127.0.0.1"), 5002)
+	c, err := node1.ping(context.Background(), net.ParseIP("127.0.0.1"), 5002, nil)
 	if err != nil {
 		t.Fatalf("Expected error to be nil, but got %s\n", err)
 	}
@@ -172,6 +186,382 @@ func TestNode_Ping(t *testing.T) {
 	}
 }
 
+func TestNode_Shutdown(t *testing.T) {
+	node1 := NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5001 })
+	start(t, node1)
+	node1.Shutdown()
+
+	// the port is free as soon as Shutdown returns
+	node2 := NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5001 })
+	defer shutdown(node2)
+	start(t, node2)
+
+	// a node that was shut down does not start listening again
+	node3 := NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5002 })
+	node3.Shutdown()
+	if err := node3.Listen(nil); err == nil || err.Error() != ErrNodeShutdown {
+		t.Fatalf("Expected %s, but got %v\n", ErrNodeShutdown, err)
+	}
+}
+
+func TestNode_Authorize(t *testing.T) {
+	keys := make([]*ecdh.PrivateKey, 3)
+	for i := range keys {
+		keys[i], _ = kadconn.GenerateKey()
+	}
+	nodes := make([]*Node, 3)
+	for i := range nodes {
+		i := i
+		nodes[i] = NewNode(gokad.NewDHT(), func(n *Node) {
+			n.Port = 5000 + i
+			n.Key = keys[i]
+		})
+	}
+	defer shutdown(nodes...)
+
+	// node0 only accepts the id of node1 from the key of node1
+	pinned := nodes[1].ID()
+	nodes[0].Authorize = func(id gokad.ID, key *ecdh.PublicKey) bool {
+		return key != nil && bytes.Equal(id, pinned) && key.Equal(keys[1].PublicKey())
+	}
+	start(t, nodes...)
+
+	if _, err := nodes[1].Ping(net.ParseIP(nodes[0].Host), nodes[0].Port, nodes[0].ID()); err != nil {
+		t.Fatalf("Expected err to be nil, but got %s\n", err)
+	}
+	if _, err := nodes[2].Ping(net.ParseIP(nodes[0].Host), nodes[0].Port, nodes[0].ID()); err == nil {
+		t.Fatalf("Expected the ping of an unauthorized node to fail\n")
+	}
+}
+
+func TestNode_Secure(t *testing.T) {
+	secure := func(port int) NodeConfig {
+		return func(n *Node) {
+			n.Port = port
+			n.Key, _ = kadconn.GenerateKey()
+			n.RequireSecure = true
+		}
+	}
+
+	nodes := make([]*Node, 3)
+	for i := 0; i < len(nodes); i++ {
+		nodes[i] = NewNode(gokad.NewDHT(), secure(5000+i))
+	}
+	plain := NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5010 })
+	defer shutdown(append(nodes, plain)...)
+
+	for i := 1; i < len(nodes); i++ {
+		nodes[0].Seed(gokad.Contact{ID: nodes[i].ID(), IP: net.ParseIP(nodes[i].Host), Port: nodes[i].Port})
+	}
+
+	start(t, append(nodes, plain)...)
+
+	key := gokad.GenerateRandomID()
+	if _, err := nodes[0].Store(key.String(), net.ParseIP("127.0.0.1"), 8000); err != nil {
+		t.Fatalf("Expected err to be nil after Store, but got %s\n", err)
+	}
+
+	if _, err := nodes[1].Ping(net.ParseIP(nodes[2].Host), nodes[2].Port, nodes[2].ID()); err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	// a node that does not seal its messages is rejected
+	if _, err := plain.Ping(net.ParseIP(nodes[0].Host), nodes[0].Port, nodes[0].ID()); err == nil {
+		t.Fatalf("Expected the ping of a plain node to fail\n")
+	}
+}
+
+func TestNode_Transport(t *testing.T) {
+	tcp := NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5000 }, WithTransport(kadconn.TCP(kadconn.TCPConfig{})))
+	both := NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5001 }, WithTransport(kadconn.Negotiated(kadconn.NegotiatedConfig{}, kadconn.UDP(), kadconn.TCP(kadconn.TCPConfig{}))))
+	udp := NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5002 })
+	nodes := []*Node{tcp, both, udp}
+	defer shutdown(nodes...)
+
+	start(t, nodes...)
+
+	for _, n := range []*Node{tcp, udp} {
+		if _, err := n.Ping(net.ParseIP(both.Host), both.Port, both.ID()); err != nil {
+			t.Fatalf("Expected err to be nil after a ping from %d, but got %s\n", n.Port, err)
+		}
+	}
+
+	// both peers are reached on the transport they used
+	for _, n := range []*Node{tcp, udp} {
+		if _, err := both.Ping(net.ParseIP(n.Host), n.Port, n.ID()); err != nil {
+			t.Fatalf("Expected err to be nil after a ping to %d, but got %s\n", n.Port, err)
+		}
+	}
+
+	// a peer that never reached the node is tried on TCP once it does not answer on UDP
+	other := NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5003 }, WithTransport(kadconn.TCP(kadconn.TCPConfig{})))
+	defer shutdown(other)
+	start(t, other)
+	if _, err := both.Ping(net.ParseIP(other.Host), other.Port, other.ID()); err != nil {
+		t.Fatalf("Expected err to be nil after a ping to %d, but got %s\n", other.Port, err)
+	}
+}
+
+func TestNode_DualStack(t *testing.T) {
+	v4 := NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5000 })
+	dual := NewNode(gokad.NewDHT(), func(n *Node) { n.Host, n.Port = "::", 5001 })
+	v6 := NewNode(gokad.NewDHT(), func(n *Node) { n.Host, n.Port = "::1", 5002 })
+	other := NewNode(gokad.NewDHT(), func(n *Node) { n.Host, n.Port = "::", 5003 })
+	nodes := []*Node{v4, dual, v6, other}
+	defer shutdown(nodes...)
+
+	start(t, nodes...)
+
+	// the dual-stack node learns of the IPv4 node and the IPv6 node
+	if err := v4.Bootstrap(dual.Port, "127.0.0.1"); err != nil {
+		t.Fatalf("Expected err to be nil after an IPv4 bootstrap, but got %s\n", err)
+	}
+	if err := v6.Bootstrap(dual.Port, "::1"); err != nil {
+		t.Fatalf("Expected err to be nil after an IPv6 bootstrap, but got %s\n", err)
+	}
+
+	if err := other.Bootstrap(dual.Port, "::1"); err != nil {
+		t.Fatalf("Expected err to be nil after a bootstrap, but got %s\n", err)
+	}
+	contacts, err := other.Lookup(gokad.GenerateRandomID())
+	if err != nil {
+		t.Fatalf("Expected err to be nil after Lookup, but got %s\n", err)
+	}
+
+	found := make(map[string]messages.AddressFamily)
+	for _, c := range contacts {
+		found[c.ID.String()] = messages.FamilyOf(c.IP)
+	}
+	if found[v4.ID().String()] != messages.IPv4 || found[v6.ID().String()] != messages.IPv6 {
+		t.Fatalf("Expected the lookup to return an IPv4 and an IPv6 contact, but got %v\n", contacts)
+	}
+}
+
+func TestNode_DualStackAddresses(t *testing.T) {
+	dual := NewNode(gokad.NewDHT(), func(n *Node) { n.Host, n.Port = "::", 5000 })
+	both := NewNode(gokad.NewDHT(), func(n *Node) { n.Host, n.Port = "::", 5001 })
+	other := NewNode(gokad.NewDHT(), func(n *Node) { n.Host, n.Port = "::", 5002 })
+	nodes := []*Node{dual, both, other}
+	defer shutdown(nodes...)
+
+	start(t, nodes...)
+
+	// both reaches dual over IPv4 and over IPv6, so dual knows it by two addresses
+	if _, err := both.Ping(net.ParseIP("127.0.0.1"), dual.Port, dual.ID()); err != nil {
+		t.Fatalf("Expected err to be nil after an IPv4 ping, but got %s\n", err)
+	}
+	if _, err := both.Ping(net.ParseIP("::1"), dual.Port, dual.ID()); err != nil {
+		t.Fatalf("Expected err to be nil after an IPv6 ping, but got %s\n", err)
+	}
+
+	if err := other.Bootstrap(dual.Port, "::1"); err != nil {
+		t.Fatalf("Expected err to be nil after a bootstrap, but got %s\n", err)
+	}
+	contacts, err := other.LookupWithOptions(context.Background(), both.ID(), LookupOptions{AllAddresses: true})
+	if err != nil {
+		t.Fatalf("Expected err to be nil after Lookup, but got %s\n", err)
+	}
+
+	found := make(map[messages.AddressFamily]bool)
+	for _, c := range contacts {
+		if c.ID.String() == both.ID().String() {
+			found[messages.FamilyOf(c.IP)] = true
+		}
+	}
+	if !found[messages.IPv4] || !found[messages.IPv6] {
+		t.Fatalf("Expected the lookup to return an IPv4 and an IPv6 contact of the dual-stack node, but got %v\n", contacts)
+	}
+
+	// without AllAddresses every node is returned once
+	contacts, _ = other.Lookup(both.ID())
+	seen := make(map[string]bool)
+	for _, c := range contacts {
+		if seen[c.ID.String()] {
+			t.Fatalf("Expected every node once, but got %s twice\n", c.ID)
+		}
+		seen[c.ID.String()] = true
+	}
+}
+
+func TestNode_Identity(t *testing.T) {
+	const difficulty = 4
+	nodes := make([]*Node, 3)
+	for i := 0; i < len(nodes); i++ {
+		ident, _ := identity.Generate(difficulty)
+		port := 5000 + i
+		nodes[i] = NewNode(gokad.DHTFrom(gokad.DHTConfig{ID: ident.ID()}), func(n *Node) {
+			n.Port = port
+			n.Identity = &ident
+			n.Difficulty = difficulty
+		})
+	}
+	plain := NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5010 })
+	defer shutdown(append(nodes, plain)...)
+
+	for i := 1; i < len(nodes); i++ {
+		nodes[0].Seed(gokad.Contact{ID: nodes[i].ID(), IP: net.ParseIP(nodes[i].Host), Port: nodes[i].Port})
+	}
+
+	start(t, append(nodes, plain)...)
+
+	if _, err := nodes[0].Store(gokad.GenerateRandomID().String(), net.ParseIP("127.0.0.1"), 8000); err != nil {
+		t.Fatalf("Expected err to be nil after Store, but got %s\n", err)
+	}
+
+	// the implicit ping reply to the store proved the id of nodes[0]
+	time.Sleep(time.Millisecond * 100)
+	var known bool
+	nodes[1].Walk(func(index int, c gokad.Contact) {
+		known = known || c.ID.String() == nodes[0].ID().String()
+	})
+	if !known {
+		t.Fatalf("Expected %s to be inserted after proving its id\n", nodes[0].ID())
+	}
+
+	if _, err := plain.Ping(net.ParseIP(nodes[0].Host), nodes[0].Port, nodes[0].ID()); err == nil {
+		t.Fatalf("Expected the ping of a node without identity to fail\n")
+	}
+
+	ident, _ := identity.Generate(difficulty)
+	mismatch := NewNode(gokad.NewDHT(), func(n *Node) {
+		n.Port = 5011
+		n.Identity = &ident
+	})
+	if err := mismatch.Listen(nil); err == nil || err.Error() != ErrIdentityMismatch {
+		t.Fatalf("Expected %s, but got %v\n", ErrIdentityMismatch, err)
+	}
+}
+
+func TestNode_IdentityLearned(t *testing.T) {
+	const difficulty = 4
+	nodes := make([]*Node, 3)
+	for i := 0; i < len(nodes); i++ {
+		ident, _ := identity.Generate(difficulty)
+		port := 5000 + i
+		nodes[i] = NewNode(gokad.DHTFrom(gokad.DHTConfig{ID: ident.ID()}), func(n *Node) {
+			n.Port = port
+			n.Identity = &ident
+			n.Difficulty = difficulty
+		})
+	}
+	defer shutdown(nodes...)
+	nodes[1].Seed(gokad.Contact{ID: nodes[2].ID(), IP: net.ParseIP(nodes[2].Host), Port: nodes[2].Port})
+	start(t, nodes...)
+
+	// nodes[0] learns nodes[2] from the lookup of the bootstrap and inserts it once it answered an identity ping
+	if err := nodes[0].Bootstrap(nodes[1].Port, nodes[1].Host); err != nil {
+		t.Fatalf("Expected err to be nil after Bootstrap, but got %s\n", err)
+	}
+
+	var known bool
+	for i := 0; i < 20 && !known; i++ {
+		time.Sleep(time.Millisecond * 50)
+		nodes[0].Walk(func(index int, c gokad.Contact) {
+			known = known || bytes.Equal(c.ID, nodes[2].ID())
+		})
+	}
+	if !known {
+		t.Fatalf("Expected the learned contact %s to be inserted after proving its id\n", nodes[2].ID())
+	}
+}
+
+func TestNode_MemoryNetwork(t *testing.T) {
+	t.Parallel()
+	network := memnet.NewNetwork()
+	network.SetLatency(time.Millisecond, time.Millisecond)
+
+	nodes := make([]*Node, 10)
+	for i := 0; i < len(nodes); i++ {
+		conn, err := network.Listen(net.JoinHostPort("10.0.0.1", strconv.Itoa(5000+i)))
+		if err != nil {
+			t.Fatalf("Expected err to be nil, but got %s\n", err)
+		}
+		port := 5000 + i
+		nodes[i] = NewNode(gokad.NewDHT(), WithConn(conn), func(n *Node) {
+			n.Host = "10.0.0.1"
+			n.Port = port
+		})
+	}
+	defer shutdown(nodes...)
+	start(t, nodes...)
+
+	for i := 1; i < len(nodes); i++ {
+		if err := nodes[i].Bootstrap(nodes[0].Port, nodes[0].Host); err != nil {
+			t.Fatalf("Expected err to be nil after Bootstrap, but got %s\n", err)
+		}
+	}
+
+	key := gokad.GenerateRandomID()
+	if _, err := nodes[3].Store(key.String(), net.ParseIP("10.0.0.2"), 8000); err != nil {
+		t.Fatalf("Expected err to be nil after Store, but got %s\n", err)
+	}
+
+	resolver, _ := nodes[7].NewResolver()
+	addr, err := resolver.Resolve(key.String())
+	if err != nil || addr.String() != "10.0.0.2:8000" {
+		t.Fatalf("Expected 10.0.0.2:8000, but got %s (%v)\n", addr, err)
+	}
+
+	// a partitioned node cannot be reached anymore
+	network.Partition([]string{net.JoinHostPort("10.0.0.1", "5009")})
+	if _, err := nodes[0].PingContext(context.Background(), net.ParseIP("10.0.0.1"), 5009, nodes[9].ID()); err == nil {
+		t.Fatalf("Expected the ping of a partitioned node to fail\n")
+	}
+}
+
+func TestNode_ClockAndRandom(t *testing.T) {
+	network := memnet.NewNetwork()
+	conn, _ := network.Listen("10.0.0.1:5000")
+	silent, _ := network.Listen("10.0.0.2:5000")
+	defer silent.Close()
+
+	clock := kadclock.NewManual(time.Now())
+	node := NewNode(gokad.NewDHT(), WithConn(conn), WithClock(clock), WithRandom(kadrand.NewSeeded(7)), func(n *Node) {
+		n.Host = "10.0.0.1"
+	})
+	defer shutdown(node)
+	start(t, node)
+
+	res := make(chan error)
+	go func() {
+		_, err := node.PingContext(context.Background(), net.ParseIP("10.0.0.2"), 5000, gokad.GenerateRandomID())
+		res <- err
+	}()
+
+	// the RandomID of the ping is the first id of the seeded source
+	msg, _, err := silent.Next()
+	if err != nil {
+		t.Fatalf("Expected err to be nil, but got %s\n", err)
+	}
+	var ping messages.PingRequest
+	messages.ToKademliaMessage(msg, &ping)
+	if expected := kadrand.NewSeeded(7).ID().String(); ping.RandomID != expected {
+		t.Fatalf("Expected RandomID %s, but got %s\n", expected, ping.RandomID)
+	}
+
+	// the ping only times out once the clock moved
+	select {
+	case err := <-res:
+		t.Fatalf("Expected the ping to wait for the clock, but got %v\n", err)
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	for i := 0; ; i++ {
+		clock.Advance(time.Second)
+		select {
+		case err := <-res:
+			if err == nil || err.Error() != transaction.TimeoutErr {
+				t.Fatalf("Expected %s, but got %v\n", transaction.TimeoutErr, err)
+			}
+			return
+		case <-time.After(time.Millisecond * 10):
+		}
+		if i == 100 {
+			t.Fatalf("Expected the ping to time out on the clock\n")
+		}
+	}
+}
+
 func TestNode_Bootstrap(t *testing.T) {
 	node1 := NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5001 })
 	node2 := NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5002 })
@@ -182,154 +572,1029 @@ func TestNode_Bootstrap(t *testing.T) {
 	go node3.Listen(nil)
 	go node4.Listen(nil)
 	defer func() {
-		shutdown(node1, node2, node3, node4)
+		shutdown(node1, node2, node3, node4)
+	}()
+
+	node2.Seed(gokad.Contact{ID: node3.ID(), IP: net.ParseIP("127.0.0.1"), Port: 5003})
+	node2.Seed(gokad.Contact{ID: node4.ID(), IP: net.ParseIP("127.0.0.1"), Port: 5004})
+
+	<-node2.started
+	<-node1.started
+
+	report, err := node1.BootstrapWithReport(context.Background(), 5002, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Expected err to be nil, but got %s\n", err)
+	}
+
+	if report.ContactsLearned != 3 {
+		t.Fatalf("Expected bootstrap to learn %d contacts, but got %d\n", 3, report.ContactsLearned)
+	}
+
+	if report.BucketsFilled < 1 {
+		t.Fatalf("Expected bootstrap to fill at least one bucket, but got %d\n", report.BucketsFilled)
+	}
+
+	// every node is alive, so no bucket refresh fails. There is nothing to refresh if the lowest bucket is the last one
+	if report.RefreshFailed != 0 {
+		t.Fatalf("Expected only successful refreshes, but got %d refreshed and %d failed\n", report.Refreshed, report.RefreshFailed)
+	}
+
+	// give all nodes time to update their tables since it is async
+	time.Sleep(time.Millisecond * 500)
+	checks := []struct {
+		IN    *Node
+		COUNT int
+	}{
+		{
+			IN:    node1,
+			COUNT: 3,
+		},
+		{
+			IN:    node2,
+			COUNT: 3,
+		},
+		{
+			IN:    node3,
+			COUNT: 1,
+		},
+		{
+			IN:    node4,
+			COUNT: 1,
+		},
+	}
+
+	for _, check := range checks {
+		var count int
+		check.IN.Walk(func(index int, c gokad.Contact) {
+			count++
+		})
+
+		if count != check.COUNT {
+			t.Fatalf("Expected count to be %d, but got %d\n", check.COUNT, count)
+		}
+	}
+}
+
+func TestNode_Store_Basic(t *testing.T) {
+	node1 := NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5001 })
+	node2 := NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5002 })
+	go node1.Listen(nil)
+	go node2.Listen(nil)
+	defer func() {
+		shutdown(node1, node2)
+	}()
+
+	<-node1.started
+	<-node2.started
+
+	err := node1.Bootstrap(5002, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Expected err to be nil, but got %s\n", err)
+	}
+
+	key := gokad.GenerateRandomID()
+	_, err = node1.Store(key.String(), net.ParseIP("127.0.0.1"), 7000)
+	if err != nil {
+		t.Fatalf("Expected err to be nil after Store, but got %s\n", err)
+	}
+}
+
+func TestNode_Store_Many(t *testing.T) {
+	nodes := make([]*Node, 10)
+	for i := 0; i < len(nodes); i++ {
+		nodes[i] = NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5000 + i })
+	}
+
+	defer func() {
+		shutdown(nodes...)
+	}()
+
+	for i := 1; i < len(nodes); i++ {
+		nodes[0].Seed(gokad.Contact{ID: nodes[i].ID(), IP: net.ParseIP(nodes[i].Host), Port: nodes[i].Port})
+	}
+
+	start(t, nodes...)
+
+	key := gokad.GenerateRandomID()
+	res, err := nodes[0].Store(key.String(), net.ParseIP("127.0.0.1"), 8000)
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if n := len(res.Replicas); n != 3 {
+		t.Fatalf("Expected a store rpc to be sent to %d nodes, but was only sent to %d", 3, n)
+	}
+
+	if acked := res.Acknowledged(); len(acked) != 3 {
+		t.Fatalf("Expected %d nodes to acknowledge the store, but got %d: %v", 3, len(acked), res.Failed())
+	}
+
+}
+
+func TestNode_Store_Concurrent(t *testing.T) {
+	nodes := make([]*Node, 4)
+	for i := 0; i < len(nodes); i++ {
+		nodes[i] = NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5000 + i })
+	}
+
+	defer func() {
+		shutdown(nodes...)
+	}()
+
+	for i := 1; i < len(nodes); i++ {
+		nodes[0].Seed(gokad.Contact{ID: nodes[i].ID(), IP: net.ParseIP(nodes[i].Host), Port: nodes[i].Port})
+	}
+
+	start(t, nodes...)
+
+	// every store hits the same contacts, so each acknowledgement must be matched to its own request
+	results := make(chan StoreResult, 10)
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		go func(port int) {
+			res, err := nodes[0].Store(gokad.GenerateRandomID().String(), net.ParseIP("127.0.0.1"), port)
+			errs <- err
+			results <- res
+		}(8000 + i)
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("Expected error to be nil, but got %s\n", err)
+		}
+		res := <-results
+		if n := len(res.Acknowledged()); n != len(res.Replicas) || n == 0 {
+			t.Fatalf("Expected all %d replicas to acknowledge the store, but got %d: %v\n", len(res.Replicas), n, res.Failed())
+		}
+	}
+
+	if n := nodes[0].UnmatchedResponses(); n != 0 {
+		t.Fatalf("Expected every store acknowledgement to be matched, but %d were not\n", n)
+	}
+}
+
+func TestNode_StoreWithOptions(t *testing.T) {
+	nodes := make([]*Node, 4)
+	for i := 0; i < len(nodes); i++ {
+		nodes[i] = NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5000 + i })
+	}
+
+	defer func() {
+		shutdown(nodes...)
+	}()
+
+	for i := 1; i < len(nodes); i++ {
+		nodes[0].Seed(gokad.Contact{ID: nodes[i].ID(), IP: net.ParseIP(nodes[i].Host), Port: nodes[i].Port})
+	}
+
+	start(t, nodes...)
+
+	tests := []struct {
+		opts     StoreOptions
+		required int
+		err      string
+	}{
+		{StoreOptions{Consistency: StoreOne}, 1, ""},
+		{StoreOptions{Consistency: StoreQuorum}, 2, ""},
+		{StoreOptions{Consistency: StoreAll}, 3, ""},
+		{StoreOptions{Acks: 5}, 5, ErrStoreQuorum},
+	}
+
+	for _, test := range tests {
+		res, err := nodes[0].StoreWithOptions(context.Background(), gokad.GenerateRandomID().String(), net.ParseIP("127.0.0.1"), 8000, test.opts)
+		if (err == nil && test.err != "") || (err != nil && err.Error() != test.err) {
+			t.Fatalf("Expected error %q for %+v, but got %v\n", test.err, test.opts, err)
+		}
+
+		if res.Required != test.required {
+			t.Fatalf("Expected %d required acknowledgements for %+v, but got %d\n", test.required, test.opts, res.Required)
+		}
+
+		if n := len(res.Acknowledged()); n != 3 {
+			t.Fatalf("Expected %d acknowledgements for %+v, but got %d\n", 3, test.opts, n)
+		}
+	}
+}
+
+func TestNode_StoreWithOptions_Retry(t *testing.T) {
+	node1 := NewNode(gokad.NewDHT(), func(n *Node) {
+		n.Port = 5001
+		n.K = 2
+		n.RoundTimeout = time.Millisecond * 200
+	})
+	node2 := NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5002 })
+	defer shutdown(node1, node2)
+
+	start(t, node1, node2)
+
+	// the two contacts closest to key do not answer, so the store has to fall back to node2
+	key := gokad.GenerateRandomID()
+	for i := 0; i < 2; i++ {
+		id := make(gokad.ID, len(key))
+		copy(id, key)
+		id[len(id)-1] ^= byte(i + 1)
+		node1.Seed(gokad.Contact{ID: id, IP: net.ParseIP("127.0.0.1"), Port: 5008 + i})
+	}
+	node1.Seed(gokad.Contact{ID: node2.ID(), IP: net.ParseIP(node2.Host), Port: node2.Port})
+
+	res, err := node1.StoreWithOptions(context.Background(), key.String(), net.ParseIP("127.0.0.1"), 8000, StoreOptions{Consistency: StoreOne})
+	if err != nil {
+		t.Fatalf("Expected err to be nil, but got %s\n", err)
+	}
+
+	acked := res.Acknowledged()
+	if len(acked) != 1 || !reflect.DeepEqual(acked[0].ID, node2.ID()) {
+		t.Fatalf("Expected only %s to acknowledge the store, but got %v\n", node2.ID(), acked)
+	}
+
+	for _, replica := range res.Failed() {
+		if !replica.TimedOut() {
+			t.Fatalf("Expected %s to time out, but got %v\n", replica.Contact.ID, replica.Err)
+		}
+	}
+
+	// nobody else is left to retry with
+	_, err = node1.StoreWithOptions(context.Background(), key.String(), net.ParseIP("127.0.0.1"), 8000, StoreOptions{Consistency: StoreAll})
+	if err == nil || err.Error() != ErrStoreQuorum {
+		t.Fatalf("Expected %s, but got %v\n", ErrStoreQuorum, err)
+	}
+
+	_, err = node1.StoreWithOptions(context.Background(), key.String(), net.ParseIP("127.0.0.1"), 8000, StoreOptions{Acks: 5, Timeout: time.Millisecond * 100})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Expected %s, but got %v\n", context.DeadlineExceeded, err)
+	}
+}
+
+func TestNode_NewResolver(t *testing.T) {
+	nodes := make([]*Node, 10)
+	for i := 0; i < len(nodes); i++ {
+		nodes[i] = NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5000 + i })
+	}
+
+	defer func() {
+		shutdown(nodes...)
+	}()
+
+	for i := 1; i < len(nodes); i++ {
+		nodes[0].Seed(gokad.Contact{ID: nodes[i].ID(), IP: net.ParseIP(nodes[i].Host), Port: nodes[i].Port})
+	}
+
+	start(t, nodes...)
+
+	key := gokad.GenerateRandomID()
+	// store the key in the network
+	nodes[0].Store(key.String(), net.ParseIP("127.0.0.1"), 8000)
+	// give the network some time to update itself
+	time.Sleep(time.Second * 1)
+	// create a resolve to resolve the key
+	resolver, err := nodes[0].NewResolver()
+	if err != nil {
+		t.Fatalf("Expected error from NewResolver to be nil, but got %s\n", err)
+	}
+	// resolve the key
+	addr, err := resolver.Resolve(key.String())
+	if err != nil {
+		t.Fatalf("Expected error from Resolve to be nil, but got %s\n", err)
+	}
+
+	if addr.String() != "127.0.0.1:8000" {
+		t.Fatalf("Expected address to be 127.0.0.1:8000, but got %s\n", addr.String())
+	}
+}
+
+func TestNode_ResolveAll(t *testing.T) {
+	nodes := make([]*Node, 5)
+	for i := 0; i < len(nodes); i++ {
+		nodes[i] = NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5000 + i })
+	}
+
+	defer func() {
+		shutdown(nodes...)
+	}()
+
+	for i := 1; i < len(nodes); i++ {
+		nodes[0].Seed(gokad.Contact{ID: nodes[i].ID(), IP: net.ParseIP(nodes[i].Host), Port: nodes[i].Port})
+	}
+	nodes[1].Seed(gokad.Contact{ID: nodes[0].ID(), IP: net.ParseIP(nodes[0].Host), Port: nodes[0].Port})
+
+	start(t, nodes...)
+
+	// providers from different publishers add to the set of the key
+	key := gokad.GenerateRandomID()
+	stores := []struct {
+		node *Node
+		port int
+	}{
+		{nodes[0], 8000},
+		{nodes[0], 8001},
+		{nodes[1], 8002},
+		{nodes[1], 8000},
+	}
+	for _, store := range stores {
+		if _, err := store.node.Store(key.String(), net.ParseIP("127.0.0.1"), store.port); err != nil {
+			t.Fatalf("Expected error to be nil, but got %s\n", err)
+		}
+	}
+
+	resolver, err := nodes[0].NewResolver()
+	if err != nil {
+		t.Fatalf("Expected error from NewResolver to be nil, but got %s\n", err)
+	}
+
+	addrs, err := resolver.ResolveAll(key.String())
+	if err != nil {
+		t.Fatalf("Expected error from ResolveAll to be nil, but got %s\n", err)
+	}
+
+	got := make(map[string]bool)
+	for _, addr := range addrs {
+		got[addr.String()] = true
+	}
+
+	expected := map[string]bool{"127.0.0.1:8000": true, "127.0.0.1:8001": true, "127.0.0.1:8002": true}
+	if len(addrs) != len(expected) || !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Expected providers %v, but got %v\n", expected, addrs)
+	}
+}
+
+func TestNode_PutGet(t *testing.T) {
+	nodes := make([]*Node, 5)
+	for i := 0; i < len(nodes); i++ {
+		nodes[i] = NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5000 + i })
+	}
+
+	defer func() {
+		shutdown(nodes...)
+	}()
+
+	for i := 1; i < len(nodes); i++ {
+		nodes[0].Seed(gokad.Contact{ID: nodes[i].ID(), IP: net.ParseIP(nodes[i].Host), Port: nodes[i].Port})
+	}
+	nodes[1].Seed(gokad.Contact{ID: nodes[0].ID(), IP: net.ParseIP(nodes[0].Host), Port: nodes[0].Port})
+
+	start(t, nodes...)
+
+	key := gokad.GenerateRandomID()
+	descriptor := []byte(`{"service":"api","endpoints":["10.0.0.1:8080","10.0.0.2:8080"]}`)
+	if _, err := nodes[0].Put(key.String(), descriptor); err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	// a provider for the same key does not interfere with the data
+	if _, err := nodes[0].Store(key.String(), net.ParseIP("127.0.0.1"), 8000); err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	data, err := nodes[1].Get(key.String())
+	if err != nil {
+		t.Fatalf("Expected error from Get to be nil, but got %s\n", err)
+	}
+
+	if string(data) != string(descriptor) {
+		t.Fatalf("Expected %s, but got %s\n", descriptor, data)
+	}
+
+	if _, err := nodes[1].Get(gokad.GenerateRandomID().String()); err == nil || err.Error() != ErrValueNotFound {
+		t.Fatalf("Expected %s, but got %v\n", ErrValueNotFound, err)
+	}
+
+	if _, err := nodes[0].Put(key.String(), make([]byte, nodes[0].MaxValueSize+1)); err == nil || err.Error() != ErrValueTooLarge {
+		t.Fatalf("Expected %s, but got %v\n", ErrValueTooLarge, err)
+	}
+}
+
+func TestNode_GetMajority(t *testing.T) {
+	nodes := make([]*Node, 5)
+	for i := 0; i < len(nodes); i++ {
+		port := 5000 + i
+		nodes[i] = NewNode(gokad.NewDHT(), func(n *Node) { n.Port = port })
+	}
+	defer shutdown(nodes...)
+
+	for i := 1; i < len(nodes); i++ {
+		nodes[0].Seed(gokad.Contact{ID: nodes[i].ID(), IP: net.ParseIP(nodes[i].Host), Port: nodes[i].Port})
+	}
+
+	start(t, nodes...)
+
+	// every round of alpha nodes has at least two that hold the same value
+	key := gokad.GenerateRandomID()
+	now := time.Now()
+	nodes[1].Values.Put(storage.Record{Key: key, Data: []byte("a"), Expires: now.Add(time.Hour), Stored: now})
+	for _, n := range nodes[2:] {
+		n.Values.Put(storage.Record{Key: key, Data: []byte("b"), Expires: now.Add(time.Hour), Stored: now})
+	}
+
+	for i := 0; i < 5; i++ {
+		data, err := nodes[0].Get(key.String())
+		if err != nil {
+			t.Fatalf("Expected error from Get to be nil, but got %s\n", err)
+		}
+		if string(data) != "b" {
+			t.Fatalf("Expected the value most nodes hold, but got %s\n", data)
+		}
+	}
+
+	if v := majority([][]byte{[]byte("d"), []byte("c"), []byte("d"), []byte("c")}); string(v) != "c" {
+		t.Fatalf("Expected a tie to go to the smallest value, but got %s\n", v)
+	}
+}
+
+func TestNode_PutGetMutable(t *testing.T) {
+	nodes := make([]*Node, 5)
+	for i := 0; i < len(nodes); i++ {
+		port := 5000 + i
+		nodes[i] = NewNode(gokad.NewDHT(), func(n *Node) { n.Port = port })
+	}
+	defer shutdown(nodes...)
+
+	for i := 1; i < len(nodes); i++ {
+		nodes[0].Seed(gokad.Contact{ID: nodes[i].ID(), IP: net.ParseIP(nodes[i].Host), Port: nodes[i].Port})
+	}
+	nodes[1].Seed(gokad.Contact{ID: nodes[0].ID(), IP: net.ParseIP(nodes[0].Host), Port: nodes[0].Port})
+
+	start(t, nodes...)
+
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	salt := []byte("profile")
+	if _, err := nodes[0].PutMutable(priv, salt, 1, []byte("v1")); err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+	latest, err := nodes[0].PutMutable(priv, salt, 2, []byte("v2"))
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	// a stale sequence is not acknowledged by the nodes holding seq 2, so they keep it
+	stale, _ := nodes[0].PutMutable(priv, salt, 1, []byte("v1"))
+	for _, r := range latest.Acknowledged() {
+		for _, s := range stale.Acknowledged() {
+			if r.ID.String() == s.ID.String() {
+				t.Fatalf("Expected %s to reject a stale sequence\n", r.ID)
+			}
+		}
+	}
+
+	// a record signed by someone else is rejected
+	forged, _ := mutable.Sign(priv, salt, 3, []byte("v3"))
+	forged.Data = []byte("forged")
+	if _, err := nodes[0].store(context.Background(), storage.Record{Key: forged.Key(), Data: forged.Data, Mutable: &forged}, time.Hour, StoreOptions{}); err == nil || err.Error() != ErrStoreQuorum {
+		t.Fatalf("Expected %s for a forged record, but got %v\n", ErrStoreQuorum, err)
+	}
+
+	record, err := nodes[1].GetMutable(pub, salt)
+	if err != nil {
+		t.Fatalf("Expected error from GetMutable to be nil, but got %s\n", err)
+	}
+	if record.Seq != 2 || string(record.Data) != "v2" {
+		t.Fatalf("Expected seq 2 with v2, but got seq %d with %s\n", record.Seq, record.Data)
+	}
+
+	other, _, _ := ed25519.GenerateKey(nil)
+	if _, err := nodes[1].GetMutable(other, salt); err == nil || err.Error() != ErrValueNotFound {
+		t.Fatalf("Expected %s, but got %v\n", ErrValueNotFound, err)
+	}
+}
+
+func TestNode_ConcurrentLookups(t *testing.T) {
+	nodes := make([]*Node, 10)
+	for i := 0; i < len(nodes); i++ {
+		port := 5000 + i
+		nodes[i] = NewNode(gokad.NewDHT(), func(n *Node) { n.Port = port })
+	}
+	defer shutdown(nodes...)
+
+	for i := 1; i < len(nodes); i++ {
+		nodes[0].Seed(gokad.Contact{ID: nodes[i].ID(), IP: net.ParseIP(nodes[i].Host), Port: nodes[i].Port})
+	}
+
+	start(t, nodes...)
+
+	key := gokad.GenerateRandomID()
+	if _, err := nodes[0].Store(key.String(), net.ParseIP("127.0.0.1"), 8000); err != nil {
+		t.Fatalf("Expected err to be nil after Store, but got %s\n", err)
+	}
+
+	resolver, err := nodes[0].NewResolver()
+	if err != nil {
+		t.Fatalf("Expected error from NewResolver to be nil, but got %s\n", err)
+	}
+
+	// lookups, stores and resolves that overlap must not close the reply buffers under each other
+	errs := make(chan error, 41)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, err := nodes[0].Store(gokad.GenerateRandomID().String(), net.ParseIP("127.0.0.1"), 8001)
+		errs <- err
+	}()
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := resolver.Resolve(key.String())
+			errs <- err
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := nodes[0].Lookup(gokad.GenerateRandomID())
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Expected all concurrent calls to succeed, but got %s\n", err)
+		}
+	}
+}
+
+func TestNode_LookupContext_Cancel(t *testing.T) {
+	node1 := NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5001 })
+	defer shutdown(node1)
+
+	// nobody is listening on this port. without a context the lookup would wait for the round timeout
+	node1.Seed(gokad.Contact{ID: gokad.GenerateRandomID(), IP: net.ParseIP("127.0.0.1"), Port: 5009})
+
+	start(t, node1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+
+	start := time.Now()
+	_, err := node1.LookupContext(ctx, node1.ID())
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Expected error to be %s, but got %v\n", context.DeadlineExceeded, err)
+	}
+
+	if elapsed := time.Since(start); elapsed >= node1.RoundTimeout {
+		t.Fatalf("Expected lookup to return before the round timeout, but it took %s\n", elapsed)
+	}
+}
+
+func TestNode_LookupWithOptions(t *testing.T) {
+	passive := make([]*Node, 10)
+	for i := 0; i < len(passive); i++ {
+		port := 5000 + i
+		passive[i] = NewNode(gokad.NewDHT(), func(n *Node) { n.Port = port })
+	}
+
+	bootstrapNode := NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 6000 })
+	for _, n := range passive {
+		bootstrapNode.Seed(gokad.Contact{ID: n.ID(), IP: net.ParseIP(n.Host), Port: n.Port})
+	}
+
+	joining := NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 7000 })
+	joining.Seed(gokad.Contact{ID: bootstrapNode.ID(), IP: net.ParseIP(bootstrapNode.Host), Port: bootstrapNode.Port})
+
+	defer func() {
+		shutdown(passive...)
+		shutdown(bootstrapNode, joining)
 	}()
 
-	node2.Seed(gokad.Contact{ID: node3.ID(), IP: net.ParseIP("127.0.0.1"), Port: 5003})
-	node2.Seed(gokad.Contact{ID: node4.ID(), IP: net.ParseIP("127.0.0.1"), Port: 5004})
+	start(t, bootstrapNode, joining)
+	start(t, passive...)
 
-	<-node2.started
-	<-node1.started
+	excluded := passive[0].ID()
+	cs, err := joining.LookupWithOptions(context.Background(), joining.ID(), LookupOptions{
+		K:       3,
+		Alpha:   1,
+		Exclude: []gokad.ID{excluded},
+	})
+	if err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
 
-	err := node1.Bootstrap(5002, "127.0.0.1")
+	if len(cs) != 3 {
+		t.Fatalf("Expected %d contacts, but got %d\n", 3, len(cs))
+	}
+
+	for _, c := range cs {
+		if reflect.DeepEqual(c.ID, excluded) {
+			t.Fatalf("Expected excluded contact %s not to be returned\n", excluded)
+		}
+	}
+}
+
+func TestNode_LookupWithOptions_DisjointPaths(t *testing.T) {
+	nodes := make([]*Node, 8)
+	for i := 0; i < len(nodes); i++ {
+		port := 5000 + i
+		nodes[i] = NewNode(gokad.NewDHT(), func(n *Node) { n.Port = port })
+	}
+	defer shutdown(nodes...)
+
+	for i := 1; i < len(nodes); i++ {
+		nodes[0].Seed(gokad.Contact{ID: nodes[i].ID(), IP: net.ParseIP(nodes[i].Host), Port: nodes[i].Port})
+		nodes[i].Seed(gokad.Contact{ID: nodes[0].ID(), IP: net.ParseIP(nodes[0].Host), Port: nodes[0].Port})
+	}
+
+	start(t, nodes...)
+
+	opts := LookupOptions{DisjointPaths: 2}
+	cs, err := nodes[1].LookupWithOptions(context.Background(), nodes[5].ID(), opts)
 	if err != nil {
-		t.Fatalf("Expected err to be nil, but got %s\n", err)
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+	if len(cs) == 0 || !reflect.DeepEqual(cs[0].ID, nodes[5].ID()) {
+		t.Fatalf("Expected %s to be the closest contact, but got %v\n", nodes[5].ID(), cs)
 	}
 
-	// give all nodes time to update their tables since it is async
-	time.Sleep(time.Millisecond * 500)
-	checks := []struct {
-		IN    *Node
-		COUNT int
-	}{
-		{
-			IN:    node1,
-			COUNT: 3,
-		},
-		{
-			IN:    node2,
-			COUNT: 3,
-		},
-		{
-			IN:    node3,
-			COUNT: 1,
-		},
-		{
-			IN:    node4,
-			COUNT: 1,
-		},
+	key := gokad.GenerateRandomID()
+	if _, err := nodes[0].Put(key.String(), []byte("genuine")); err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
 	}
 
-	for _, check := range checks {
-		var count int
-		check.IN.Walk(func(index int, c gokad.Contact) {
-			count++
-		})
+	// a single malicious node answers with its own value. It is only part of one path
+	now := time.Now()
+	nodes[2].Values.Put(storage.Record{Key: key, Data: []byte("bogus"), Expires: now.Add(time.Hour), Stored: now})
 
-		if count != check.COUNT {
-			t.Fatalf("Expected count to be %d, but got %d\n", check.COUNT, count)
+	for i := 0; i < 5; i++ {
+		data, err := nodes[0].GetWithOptions(context.Background(), key.String(), opts)
+		if err != nil {
+			t.Fatalf("Expected error from Get to be nil, but got %s\n", err)
+		}
+		if string(data) != "genuine" {
+			t.Fatalf("Expected the value confirmed by both paths, but got %s\n", data)
 		}
 	}
+
+	if _, err := nodes[0].GetWithOptions(context.Background(), gokad.GenerateRandomID().String(), opts); err == nil || err.Error() != ErrValueNotFound {
+		t.Fatalf("Expected %s, but got %v\n", ErrValueNotFound, err)
+	}
 }
 
-func TestNode_Store_Basic(t *testing.T) {
+func TestNode_LookupWithOptions_Timeouts(t *testing.T) {
 	node1 := NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5001 })
+	defer shutdown(node1)
+
+	// nobody is listening on this port
+	node1.Seed(gokad.Contact{ID: gokad.GenerateRandomID(), IP: net.ParseIP("127.0.0.1"), Port: 5009})
+
+	start(t, node1)
+
+	start := time.Now()
+	_, err := node1.LookupWithOptions(context.Background(), node1.ID(), LookupOptions{Deadline: time.Now().Add(time.Millisecond * 200)})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Expected error to be %s, but got %v\n", context.DeadlineExceeded, err)
+	}
+	if elapsed := time.Since(start); elapsed >= node1.RoundTimeout {
+		t.Fatalf("Expected lookup to stop at its deadline, but it took %s\n", elapsed)
+	}
+
+	start = time.Now()
+	if _, err := node1.LookupWithOptions(context.Background(), node1.ID(), LookupOptions{RoundTimeout: time.Millisecond * 200}); err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+	if elapsed := time.Since(start); elapsed >= node1.RoundTimeout {
+		t.Fatalf("Expected the round to time out after 200ms, but it took %s\n", elapsed)
+	}
+}
+
+func TestNode_RefreshStaleBuckets(t *testing.T) {
+	refresh := func(n *Node) {
+		n.RefreshInterval = time.Millisecond * 100
+		n.RefreshCheckInterval = time.Millisecond * 50
+	}
+	node1 := NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5001 }, refresh)
 	node2 := NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5002 })
-	go node1.Listen(nil)
-	go node2.Listen(nil)
-	defer func() {
-		shutdown(node1, node2)
-	}()
+	node3 := NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5003 })
+	defer shutdown(node1, node2, node3)
 
-	<-node1.started
-	<-node2.started
+	// node1 only knows about node2 and node2 only knows about node3
+	node1.Seed(gokad.Contact{ID: node2.ID(), IP: net.ParseIP(node2.Host), Port: node2.Port})
+	node2.Seed(gokad.Contact{ID: node3.ID(), IP: net.ParseIP(node3.Host), Port: node3.Port})
 
-	err := node1.Bootstrap(5002, "127.0.0.1")
-	if err != nil {
-		t.Fatalf("Expected err to be nil, but got %s\n", err)
+	start(t, node1, node2, node3)
+
+	// without any explicit lookup, node1 should learn about node3 by refreshing its stale buckets
+	time.Sleep(time.Millisecond * 500)
+
+	var count int
+	node1.Walk(func(index int, c gokad.Contact) {
+		count++
+	})
+
+	if count != 2 {
+		t.Fatalf("Expected %d contacts after refreshing stale buckets, but got %d\n", 2, count)
+	}
+}
+
+func TestNode_StoreExpires(t *testing.T) {
+	expire := func(n *Node) {
+		n.ExpireInterval = time.Second
+		n.RepublishCheckInterval = time.Millisecond * 100
 	}
+	node1 := NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5001 }, expire)
+	node2 := NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5002 }, expire)
+	defer shutdown(node1, node2)
+
+	node1.Seed(gokad.Contact{ID: node2.ID(), IP: net.ParseIP(node2.Host), Port: node2.Port})
+	start(t, node1, node2)
 
 	key := gokad.GenerateRandomID()
-	_, err = node1.Store(key.String(), net.ParseIP("127.0.0.1"), 7000)
-	if err != nil {
-		t.Fatalf("Expected err to be nil after Store, but got %s\n", err)
+	if _, err := node1.Store(key.String(), net.ParseIP("127.0.0.1"), 8000); err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	if rs, _ := node2.Values.Get(key); len(rs) != 1 {
+		t.Fatalf("Expected node2 to hold the value after Store\n")
+	}
+
+	time.Sleep(time.Millisecond * 1500)
+
+	if held := node2.Values.Len(); held != 0 {
+		t.Fatalf("Expected the value to be evicted after it expired, but node2 still holds %d values\n", held)
 	}
 }
 
-func TestNode_Store_Many(t *testing.T) {
-	nodes := make([]*Node, 10)
+func TestNode_Republish(t *testing.T) {
+	node1 := NewNode(gokad.NewDHT(), func(n *Node) {
+		n.Port = 5001
+		n.ExpireInterval = time.Second
+		n.RepublishInterval = time.Millisecond * 300
+		n.RepublishCheckInterval = time.Millisecond * 100
+	})
+	node2 := NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5002 })
+	defer shutdown(node1, node2)
+
+	node1.Seed(gokad.Contact{ID: node2.ID(), IP: net.ParseIP(node2.Host), Port: node2.Port})
+	start(t, node1, node2)
+
+	key := gokad.GenerateRandomID()
+	if _, err := node1.Store(key.String(), net.ParseIP("127.0.0.1"), 8000); err != nil {
+		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	}
+
+	// the value was stored with a TTL of 1 second. node1 keeps republishing it so it must outlive its TTL
+	time.Sleep(time.Millisecond * 1500)
+
+	if rs, _ := node2.Values.Get(key); len(rs) != 1 || rs[0].Expired(time.Now()) {
+		t.Fatalf("Expected node2 to still hold the republished value\n")
+	}
+}
+
+func TestNode_Unpublish(t *testing.T) {
+	t.Parallel()
+	network := memnet.NewNetwork()
+	nodes := make([]*Node, 2)
 	for i := 0; i < len(nodes); i++ {
-		nodes[i] = NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5000 + i })
-		go func(node *Node) {
-			err := node.Listen(nil)
-			if err != nil {
-				t.Fatalf("Listen error %s\n", err)
-			}
-		}(nodes[i])
+		conn, _ := network.Listen(net.JoinHostPort("10.0.0.1", strconv.Itoa(5000+i)))
+		port := 5000 + i
+		nodes[i] = NewNode(gokad.NewDHT(), WithConn(conn), func(n *Node) {
+			n.Host = "10.0.0.1"
+			n.Port = port
+			n.RoundTimeout = time.Millisecond * 100
+		})
 	}
+	defer shutdown(nodes...)
+	start(t, nodes...)
 
-	defer func() {
-		shutdown(nodes...)
-	}()
+	// without contacts the store fails, so there is nothing to republish
+	key := gokad.GenerateRandomID()
+	if _, err := nodes[0].Put(key.String(), []byte("value")); err == nil {
+		t.Fatalf("Expected the Put without contacts to fail\n")
+	}
+	if nodes[0].isPublisher(storage.Record{Key: key, Data: []byte("value")}) {
+		t.Fatalf("Expected a failed Put not to be republished\n")
+	}
 
-	for i := 1; i < len(nodes); i++ {
-		nodes[0].Seed(gokad.Contact{ID: nodes[i].ID(), IP: net.ParseIP(nodes[i].Host), Port: nodes[i].Port})
+	nodes[0].Seed(gokad.Contact{ID: nodes[1].ID(), IP: net.ParseIP(nodes[1].Host), Port: nodes[1].Port})
+	if _, err := nodes[0].Put(key.String(), []byte("value")); err != nil {
+		t.Fatalf("Expected err to be nil after Put, but got %s\n", err)
+	}
+	if !nodes[0].isPublisher(storage.Record{Key: key, Data: []byte("value")}) {
+		t.Fatalf("Expected a successful Put to be republished\n")
 	}
 
-	<-wait(nodes...)
+	if err := nodes[0].Unpublish(key.String()); err != nil {
+		t.Fatalf("Expected err to be nil after Unpublish, but got %s\n", err)
+	}
+	if nodes[0].isPublisher(storage.Record{Key: key, Data: []byte("value")}) {
+		t.Fatalf("Expected an unpublished value not to be republished\n")
+	}
+}
 
+func TestNode_ReplicateStale(t *testing.T) {
+	node := NewNode(gokad.NewDHT())
+	_, priv, _ := ed25519.GenerateKey(nil)
 	key := gokad.GenerateRandomID()
-	n, err := nodes[0].Store(key.String(), net.ParseIP("127.0.0.1"), 8000)
-	if err != nil {
-		t.Fatalf("Expected error to be nil, but got %s\n", err)
+	now := time.Now()
+
+	old, _ := mutable.Sign(priv, nil, 1, []byte("v1"))
+	stale := storage.Record{Key: key, Data: old.Data, Mutable: &old, Expires: now.Add(time.Hour), Stored: now}
+	node.Values.Put(stale)
+
+	// a newer record arrives while the republisher holds the old one
+	newer, _ := mutable.Sign(priv, nil, 2, []byte("v2"))
+	node.Values.Put(storage.Record{Key: key, Data: newer.Data, Mutable: &newer, Expires: now.Add(time.Hour), Stored: now})
+
+	if newRepublisher(node).replicated(stale, now.Add(time.Minute)) {
+		t.Fatalf("Expected a replaced record not to be replicated\n")
 	}
 
-	if n != 3 {
-		t.Fatalf("Expected a store rpc to be sent to %d nodes, but was only sent to %d", 3, n)
+	rs, _ := node.Values.Get(key)
+	if len(rs) != 1 || rs[0].Mutable.Seq != 2 {
+		t.Fatalf("Expected the store to keep seq 2, but got %v\n", rs)
+	}
+}
+
+func TestNode_EvictLeastRecentlySeen(t *testing.T) {
+	dht1 := gokad.DHTFrom(gokad.DHTConfig{ID: gokad.GenerateID(make([]byte, 20))})
+	dht2 := gokad.DHTFrom(gokad.DHTConfig{ID: gokad.GenerateID([]byte{255, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})})
+	node1 := NewNode(dht1, func(n *Node) { n.Port = 5001 })
+	node2 := NewNode(dht2, func(n *Node) { n.Port = 5002 })
+	defer shutdown(node1, node2)
+
+	start(t, node1, node2)
+
+	// fill the farthest bucket of node1 with contacts nobody is listening for
+	dead := make([]gokad.Contact, node1.K)
+	for i := range dead {
+		id := make([]byte, 20)
+		id[0] = 128 + byte(i)
+		dead[i] = gokad.Contact{ID: gokad.GenerateID(id), IP: net.ParseIP("127.0.0.1"), Port: 5100 + i}
+	}
+	node1.Seed(dead...)
+
+	// node2 falls into the same bucket. the least recently seen contact does not answer and gets evicted
+	node2Contact := gokad.Contact{ID: node2.ID(), IP: net.ParseIP(node2.Host), Port: node2.Port}
+	node1.Seed(node2Contact)
+
+	time.Sleep(time.Second * 3)
+
+	if n := node1.Evictions(); n != 1 {
+		t.Fatalf("Expected %d eviction, but got %d\n", 1, n)
 	}
 
+	var foundNode2, foundLRS bool
+	node1.Walk(func(index int, c gokad.Contact) {
+		if reflect.DeepEqual(c.ID, node2.ID()) {
+			foundNode2 = true
+		}
+		if reflect.DeepEqual(c.ID, dead[0].ID) {
+			foundLRS = true
+		}
+	})
+
+	if !foundNode2 || foundLRS {
+		t.Fatalf("Expected node2 to replace the least recently seen contact\n")
+	}
 }
 
-func TestNode_NewResolver(t *testing.T) {
-	nodes := make([]*Node, 10)
-	for i := 0; i < len(nodes); i++ {
-		nodes[i] = NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5000 + i })
-		go func(node *Node) {
-			err := node.Listen(nil)
-			if err != nil {
-				t.Fatalf("Listen error %s\n", err)
-			}
-		}(nodes[i])
+func TestDhtProxy_Evict(t *testing.T) {
+	proxy := newDhtProxy(gokad.DHTFrom(gokad.DHTConfig{ID: gokad.GenerateID(make([]byte, 20))}), newEventBus(kadclock.Real()), kadclock.Real())
+	contact := func(i int) gokad.Contact {
+		id := make([]byte, 20)
+		id[0] = 128 + byte(i)
+		return gokad.Contact{ID: gokad.GenerateID(id), IP: net.ParseIP("127.0.0.1"), Port: 5100 + i}
+	}
+	// fill the farthest bucket
+	for i := 0; i < 20; i++ {
+		proxy.insert(contact(i))
+	}
+	contains := func(c gokad.Contact) bool {
+		proxy.mtx.Lock()
+		defer proxy.mtx.Unlock()
+		return proxy.contains(c.ID)
 	}
 
-	defer func() {
-		shutdown(nodes...)
-	}()
+	// the least recently seen contact is seen again while it is pinged, so it stays
+	proxy.insert(contact(20))
+	check := <-proxy.checks
+	proxy.insert(contact(0))
+	if _, ok := proxy.evict(check); ok || proxy.evictions() != 0 || !contains(contact(0)) {
+		t.Fatalf("Expected a contact that was seen again not to be evicted\n")
+	}
 
-	for i := 1; i < len(nodes); i++ {
-		nodes[0].Seed(gokad.Contact{ID: nodes[i].ID(), IP: net.ParseIP(nodes[i].Host), Port: nodes[i].Port})
+	// the bucket is not full anymore, so the replacement gets the free slot
+	proxy.insert(contact(21))
+	check = <-proxy.checks
+	bucket, _ := proxy.dht.RoutingTable().Bucket(check.index)
+	bucket.Remove(contact(5).ID)
+	if replacement, ok := proxy.evict(check); !ok || !reflect.DeepEqual(replacement.ID, contact(21).ID) || proxy.evictions() != 0 || !contains(check.lrs) {
+		t.Fatalf("Expected the replacement to fill the free slot without an eviction\n")
+	}
+
+	// a check that does not fit into the queue is requested again on the next insert into the bucket
+	checks := proxy.checks
+	proxy.checks = make(chan bucketCheck)
+	proxy.insert(contact(22))
+	proxy.checks = checks
+	proxy.insert(contact(3))
+	select {
+	case check := <-proxy.checks:
+		if _, ok := proxy.evict(check); !ok || proxy.evictions() != 1 || contains(check.lrs) {
+			t.Fatalf("Expected the least recently seen contact to be evicted\n")
+		}
+	default:
+		t.Fatalf("Expected the deferred check to be requested\n")
 	}
+}
+
+func TestNode_Subscribe(t *testing.T) {
+	node1 := NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5001 })
+	node2 := NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5002 })
+	events1, unsubscribe1 := node1.Subscribe(1024)
+	events2, unsubscribe2 := node2.Subscribe(1024)
+	defer unsubscribe1()
+	defer unsubscribe2()
+	defer shutdown(node1, node2)
+
+	start(t, node1, node2)
 
-	<-wait(nodes...)
+	if err := node1.Bootstrap(5002, "127.0.0.1"); err != nil {
+		t.Fatalf("Expected err to be nil, but got %s\n", err)
+	}
 
 	key := gokad.GenerateRandomID()
-	// store the key in the network
-	nodes[0].Store(key.String(), net.ParseIP("127.0.0.1"), 8000)
-	// give the network some time to update itself
-	time.Sleep(time.Second * 1)
-	// create a resolve to resolve the key
-	resolver, err := nodes[0].NewResolver()
-	if err != nil {
-		t.Fatalf("Expected error from NewResolver to be nil, but got %s\n", err)
+	if _, err := node1.Store(key.String(), net.ParseIP("127.0.0.1"), 7000); err != nil {
+		t.Fatalf("Expected err to be nil after Store, but got %s\n", err)
 	}
-	// resolve the key
-	addr, err := resolver.Resolve(key.String())
-	if err != nil {
-		t.Fatalf("Expected error from Resolve to be nil, but got %s\n", err)
+
+	seen1 := drain(events1)
+	for _, typ := range []EventType{ContactAdded, LookupStarted, LookupFinished} {
+		if len(seen1[typ]) == 0 {
+			t.Fatalf("Expected node1 to publish a %s event\n", typ)
+		}
+	}
+	if added := seen1[ContactAdded][0]; !reflect.DeepEqual(added.Contact.ID, node2.ID()) {
+		t.Fatalf("Expected node2 to be added to node1's routing table, but got %s\n", added.Contact.ID)
 	}
 
-	if addr.String() != "127.0.0.1:8000" {
-		t.Fatalf("Expected address to be 127.0.0.1:8000, but got %s\n", addr.String())
+	seen2 := drain(events2)
+	if len(seen2[RequestReceived]) == 0 {
+		t.Fatalf("Expected node2 to publish a %s event\n", RequestReceived)
+	}
+	stored := seen2[ValueStored]
+	if len(stored) != 1 || !reflect.DeepEqual(stored[0].Key, key) {
+		t.Fatalf("Expected node2 to publish a %s event for key %s\n", ValueStored, key)
+	}
+}
+
+func TestNode_Logger(t *testing.T) {
+	entries := make(chan string, 16)
+	logger := kadlog.LoggerFunc(func(level kadlog.Level, msg string, fields ...kadlog.Field) {
+		if level != kadlog.Debug || msg != "request received" {
+			return
+		}
+		for _, f := range fields {
+			if f.Key == "type" {
+				entries <- f.Value.(string)
+			}
+		}
+	})
+	node1 := NewNode(gokad.NewDHT(), func(n *Node) {
+		n.Port = 5001
+		n.Logger = logger
+	})
+	node2 := NewNode(gokad.NewDHT(), func(n *Node) {
+		n.Port = 5002
+		n.Logger = kadlog.Nop()
+	})
+	defer shutdown(node1, node2)
+
+	start(t, node1, node2)
+
+	if _, err := node2.Ping(net.ParseIP("127.0.0.1"), 5001, node1.ID()); err != nil {
+		t.Fatalf("Expected err to be nil, but got %s\n", err)
+	}
+
+	select {
+	case typ := <-entries:
+		if typ != "PingRequest" {
+			t.Fatalf("Expected a PingRequest to be logged, but got %s\n", typ)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatalf("Expected the request to be logged\n")
+	}
+}
+
+func TestNode_UnmatchedResponses(t *testing.T) {
+	node1 := NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5001 })
+	node2 := NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5002 })
+	defer shutdown(node1, node2)
+
+	start(t, node1, node2)
+
+	// a response to a request node1 never sent
+	fnr := messages.FindNodeResponse{
+		SenderID:     node2.ID().String(),
+		EchoRandomID: gokad.GenerateRandomID().String(),
+		Payload:      []gokad.Contact{},
+		RandomID:     gokad.GenerateRandomID().String(),
+	}
+	b, _ := fnr.Bytes()
+	addr, _ := net.ResolveUDPAddr("udp", "127.0.0.1:5001")
+	node2.conn.Write(b, addr)
+
+	time.Sleep(time.Millisecond * 200)
+
+	if n := node1.UnmatchedResponses(); n != 1 {
+		t.Fatalf("Expected %d unmatched response, but got %d\n", 1, n)
 	}
 }
 
@@ -439,6 +1704,16 @@ func TestResolveWithMockNetwork(t *testing.T) {
 	}
 }
 
+func TestRandomIDInBucket(t *testing.T) {
+	own := gokad.GenerateRandomID()
+	for _, index := range []int{0, 7, 8, 100, 158, 159} {
+		id := randomIDInBucket(kadrand.Crypto(), own, index)
+		if highest := bucketIndex(own, id); highest != index {
+			t.Fatalf("Expected random id to fall into bucket %d, but it fell into %d\n", index, highest)
+		}
+	}
+}
+
 func wait(nodes ...*Node) <-chan struct{} {
 	out := make(chan struct{})
 	var wg sync.WaitGroup
@@ -459,6 +1734,39 @@ func wait(nodes ...*Node) <-chan struct{} {
 	return out
 }
 
+// drain returns all events that are currently queued in events grouped by type
+func drain(events <-chan Event) map[EventType][]Event {
+	out := make(map[EventType][]Event)
+	for {
+		select {
+		case e := <-events:
+			out[e.Type] = append(out[e.Type], e)
+		default:
+			return out
+		}
+	}
+}
+
+// start runs Listen on every node and waits until all of them listen. A node that cannot listen fails the test
+func start(t *testing.T, nodes ...*Node) {
+	errs := make(chan error, len(nodes))
+	for _, n := range nodes {
+		go func(node *Node) {
+			if err := node.Listen(nil); err != nil {
+				errs <- err
+			}
+		}(n)
+	}
+
+	for _, n := range nodes {
+		select {
+		case <-n.started:
+		case err := <-errs:
+			t.Fatalf("Expected err to be nil after Listen, but got %s\n", err)
+		}
+	}
+}
+
 func shutdown(nodes ...*Node) {
 	for _, n := range nodes {
 		n.Shutdown()
@@ -0,0 +1,47 @@
+package kadnet
+
+import (
+	"bytes"
+	"context"
+	"github.com/alabianca/gokad"
+	"sync"
+)
+
+// prover inserts the contacts a node learns once they proved their id, if the node has an identity.
+// Contacts learned from lookups and nodes that answered a lookup are sent an identity ping first
+type prover struct {
+	node *Node
+}
+
+func newProver(n *Node) *prover {
+	return &prover{node: n}
+}
+
+// Run pings the contacts that wait for a proof until it receives on exit.
+// Pings that are in progress are cancelled before Run returns
+func (p *prover) Run(exit <-chan chan error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for {
+		select {
+		case out := <-exit:
+			cancel()
+			wg.Wait()
+			out <- nil
+			return
+
+		case c := <-p.node.dht.proofs:
+			wg.Add(1)
+			go func(c gokad.Contact) {
+				defer wg.Done()
+				p.prove(ctx, c)
+			}(c)
+		}
+	}
+}
+
+// prove pings c. The reply proves the id of c, so c is inserted if the reply comes from the id it was learned with
+func (p *prover) prove(ctx context.Context, c gokad.Contact) {
+	res, err := p.node.PingContext(ctx, c.IP, c.Port, c.ID)
+	p.node.dht.proven(c, err == nil && bytes.Equal(res.ID, c.ID))
+}
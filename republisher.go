@@ -0,0 +1,169 @@
+package kadnet
+
+import (
+	"bytes"
+	"context"
+	"github.com/alabianca/kadnet/storage"
+	"time"
+)
+
+// republisher takes care of the lifetime of stored values while the node is listening.
+// Expired values are evicted, values published by this node are stored again every RepublishInterval (tRepublish)
+// and values held for others are replicated to the current k closest nodes every ReplicateInterval (tReplicate)
+type republisher struct {
+	node          *Node
+	checkInterval time.Duration
+}
+
+func newRepublisher(n *Node) *republisher {
+	return &republisher{
+		node:          n,
+		checkInterval: n.RepublishCheckInterval,
+	}
+}
+
+// Run checks the stored values every checkInterval until it receives on exit.
+// A republish that is in progress is cancelled before Run returns
+func (r *republisher) Run(exit <-chan chan error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ticker := r.node.Clock.NewTicker(r.checkInterval)
+	defer ticker.Stop()
+
+	var republishing chan struct{} // non-nil channel means a republish is currently running
+	for {
+		select {
+		case out := <-exit:
+			cancel()
+			if republishing != nil {
+				<-republishing
+			}
+			out <- nil
+			return
+
+		case <-ticker.C():
+			if republishing != nil {
+				continue
+			}
+			republishing = make(chan struct{})
+			go func(done chan struct{}) {
+				defer close(done)
+				r.republish(ctx)
+			}(republishing)
+
+		case <-republishing:
+			republishing = nil
+		}
+	}
+}
+
+func (r *republisher) republish(ctx context.Context) {
+	n := r.node
+	now := n.Clock.Now()
+	held := r.expire(now)
+
+	due := make([]publication, 0)
+	n.mtx.Lock()
+	for k, p := range n.published {
+		if now.Sub(p.published) >= n.RepublishInterval {
+			p.published = now
+			n.published[k] = p
+			due = append(due, p)
+		}
+	}
+	n.mtx.Unlock()
+
+	for _, p := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		n.store(ctx, p.record, n.ExpireInterval, StoreOptions{})
+	}
+
+	for _, record := range held {
+		if ctx.Err() != nil {
+			return
+		}
+
+		// values published by this node are taken care of above
+		if n.isPublisher(record) {
+			continue
+		}
+
+		// if the value was stored by someone else within the interval it is already replicated.
+		// this keeps all nodes that hold a value from replicating it at the same time
+		if now.Sub(record.Stored) < n.ReplicateInterval {
+			continue
+		}
+
+		ttl := record.Expires.Sub(now)
+		if ttl < time.Second {
+			continue
+		}
+
+		if !r.replicated(record, now) {
+			continue
+		}
+		n.store(ctx, record, ttl, StoreOptions{})
+	}
+}
+
+// replicated marks record as stored at now. It reports false and leaves the store alone
+// if the record was replaced since it was read, so a newer record is never overwritten by an older one
+func (r *republisher) replicated(record storage.Record, now time.Time) bool {
+	n := r.node
+	n.valuesMtx.Lock()
+	defer n.valuesMtx.Unlock()
+
+	records, err := n.Values.Get(record.Key)
+	if err != nil {
+		return false
+	}
+
+	for _, held := range records {
+		if held.Provider() != record.Provider() {
+			continue
+		}
+		if !sameRecord(held, record) {
+			return false
+		}
+
+		record.Stored = now
+		return n.Values.Put(record) == nil
+	}
+
+	return false
+}
+
+// sameRecord reports whether a and b are the same version of a record
+func sameRecord(a, b storage.Record) bool {
+	if !a.Stored.Equal(b.Stored) || !a.Expires.Equal(b.Expires) || !bytes.Equal(a.Data, b.Data) {
+		return false
+	}
+	if (a.Mutable == nil) != (b.Mutable == nil) {
+		return false
+	}
+
+	return a.Mutable == nil || a.Mutable.Seq == b.Mutable.Seq
+}
+
+// expire evicts all expired records from the node's value store and returns the remaining ones
+func (r *republisher) expire(now time.Time) []storage.Record {
+	values := r.node.Values
+	held := make([]storage.Record, 0)
+	expired := make([]storage.Record, 0)
+	values.Iterate(func(record storage.Record) bool {
+		if record.Expired(now) {
+			expired = append(expired, record)
+		} else {
+			held = append(held, record)
+		}
+		return true
+	})
+
+	// a record that was refreshed in the meantime was just stored, so it is not due for replication anyway
+	for _, record := range expired {
+		values.RemoveIfExpired(record.Key, record.Provider(), now)
+	}
+
+	return held
+}
@@ -1,10 +1,11 @@
 package kadnet
 
 import (
+	"context"
 	"errors"
 	"github.com/alabianca/gokad"
+	"github.com/alabianca/kadnet/storage"
 	"net"
-	"strconv"
 )
 
 type Resolver struct {
@@ -13,6 +14,37 @@ type Resolver struct {
 }
 
 func (r *Resolver) Resolve(hash string) (net.Addr, error) {
+	return r.ResolveContext(context.Background(), hash)
+}
+
+// ResolveContext is like Resolve but gives up once ctx is done and returns ctx.Err()
+func (r *Resolver) ResolveContext(ctx context.Context, hash string) (net.Addr, error) {
+	return r.ResolveWithOptions(ctx, hash, LookupOptions{})
+}
+
+// ResolveWithOptions is like ResolveContext but opts override the node's lookup settings for this lookup
+func (r *Resolver) ResolveWithOptions(ctx context.Context, hash string, opts LookupOptions) (net.Addr, error) {
+	addrs, err := r.ResolveAllWithOptions(ctx, hash, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// for now just return the first provider
+	return addrs[0], nil
+}
+
+// ResolveAll returns every provider of hash that was seen during the lookup, without duplicates
+func (r *Resolver) ResolveAll(hash string) ([]net.Addr, error) {
+	return r.ResolveAllContext(context.Background(), hash)
+}
+
+// ResolveAllContext is like ResolveAll but gives up once ctx is done and returns ctx.Err()
+func (r *Resolver) ResolveAllContext(ctx context.Context, hash string) ([]net.Addr, error) {
+	return r.ResolveAllWithOptions(ctx, hash, LookupOptions{})
+}
+
+// ResolveAllWithOptions is like ResolveAllContext but opts override the node's lookup settings for this lookup
+func (r *Resolver) ResolveAllWithOptions(ctx context.Context, hash string, opts LookupOptions) ([]net.Addr, error) {
 	if r.lookup == nil {
 		return nil, errors.New("lookup strategy not set")
 	}
@@ -22,12 +54,38 @@ func (r *Resolver) Resolve(hash string) (net.Addr, error) {
 		return nil, err
 	}
 
-	cs, err := r.lookup.do(key)
+	lp, err := r.lookup.withOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	cs, err := lp.do(ctx, key)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	if err == context.DeadlineExceeded {
+		return nil, err
+	}
 	if err != nil || len(cs) == 0 {
 		return nil, errors.New("no contacts found")
 	}
 
-	// for now just return the first ip port pair
-	contact := cs[0]
-	return net.ResolveTCPAddr("tcp", net.JoinHostPort(contact.IP.String(), strconv.Itoa(contact.Port)))
+	// every node that holds the key replies with its own providers, so the same provider is usually seen more than once
+	seen := make(map[string]bool, len(cs))
+	addrs := make([]net.Addr, 0, len(cs))
+	for _, c := range cs {
+		provider := storage.Provider(gokad.Value{Host: c.IP, Port: c.Port})
+		if seen[provider] {
+			continue
+		}
+		seen[provider] = true
+
+		addr, err := net.ResolveTCPAddr("tcp", provider)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, addr)
+	}
+
+	return addrs, nil
 }
@@ -1,9 +1,10 @@
 package response
 
 import (
+	"context"
 	"github.com/alabianca/gokad"
-	"github.com/alabianca/kadnet/buffers"
 	"github.com/alabianca/kadnet/messages"
+	"github.com/alabianca/kadnet/transaction"
 	"net"
 	"strconv"
 	"time"
@@ -11,19 +12,18 @@ import (
 
 type Response struct {
 	Contact gokad.Contact
-	Body    buffers.Buffer
 	// SendPingReplyFunc is called whenever a response is successfully
 	// read in Response.Read
 	SendPingReplyFunc func(echoRandomID string)
-	matcher           string
+	tx                *transaction.Transaction
 	readTimeout       time.Duration
+	ctx               context.Context
 }
 
-func New(c gokad.Contact, matcher string, buffer buffers.Buffer) *Response {
+func New(c gokad.Contact, tx *transaction.Transaction) *Response {
 	return &Response{
 		Contact: c,
-		Body:    buffer,
-		matcher: matcher,
+		tx:      tx,
 	}
 }
 
@@ -41,20 +41,48 @@ func (r *Response) ReadTimeout(dur time.Duration) {
 	r.readTimeout = dur
 }
 
+// Context returns the response's context. It is never nil
+func (r *Response) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+
+	return context.Background()
+}
+
+// WithContext returns a shallow copy of r with its context changed to ctx.
+// Reads on the returned response stop and return ctx.Err() once ctx is done.
+func (r *Response) WithContext(ctx context.Context) *Response {
+	if ctx == nil {
+		panic("nil context")
+	}
+
+	r2 := new(Response)
+	*r2 = *r
+	r2.ctx = ctx
+	return r2
+}
+
+// RandomID returns the RandomID of the request r is the response to
+func (r *Response) RandomID() string {
+	return r.tx.ID()
+}
+
+// Read waits for the response and decodes it into km.
+// If the read times out, the response can still be read later
 func (r *Response) Read(km messages.KademliaMessage) (int, error) {
 	defer r.resetTimeout()
-	reader := r.Body.NewReader(r.Contact.ID.String() + r.matcher)
-
-	if r.readTimeout != time.Duration(0) {
-		reader.SetDeadline(r.readTimeout)
+	msg, err := r.tx.Wait(r.Context(), r.readTimeout)
+	if err != nil {
+		return 0, err
 	}
-	n, err := reader.Read(km)
 
-	if err == nil && r.SendPingReplyFunc != nil {
+	messages.ToKademliaMessage(msg, km)
+	if r.SendPingReplyFunc != nil {
 		r.SendPingReplyFunc(km.GetEchoRandomID())
 	}
 
-	return n, err
+	return len(msg), nil
 }
 
 func (r *Response) resetTimeout() {
@@ -0,0 +1,80 @@
+package sim
+
+import (
+	"github.com/alabianca/gokad"
+	"time"
+)
+
+// LookupReport describes a single lookup of a simulation
+type LookupReport struct {
+	// At is the virtual time the lookup ran at
+	At     time.Duration
+	Source gokad.ID
+	Target gokad.ID
+	// Hops is the number of rounds of FIND_NODE_RPC's the lookup took
+	Hops int
+	// Messages is the number of datagrams sent in the network while the lookup ran
+	Messages uint64
+	// Latency is the virtual time the lookup took
+	Latency time.Duration
+	// Success reports if the target was among the contacts the lookup returned
+	Success bool
+	Err     error
+}
+
+// Report is the outcome of a simulation
+type Report struct {
+	Seed int64
+	// Joined and Left are the number of nodes that joined and left, including the ones that joined initially
+	Joined int
+	Left   int
+	// FailedJoins is the number of joining nodes whose lookup of their own id failed
+	FailedJoins int
+	Lookups     []LookupReport
+	// Messages is the number of datagrams sent during the whole simulation
+	Messages uint64
+}
+
+// SuccessRatio is the share of lookups that found their target. It is 0 without lookups
+func (r Report) SuccessRatio() float64 {
+	if len(r.Lookups) == 0 {
+		return 0
+	}
+
+	var ok int
+	for _, l := range r.Lookups {
+		if l.Success {
+			ok++
+		}
+	}
+
+	return float64(ok) / float64(len(r.Lookups))
+}
+
+// MeanHops is the average number of hops of all lookups
+func (r Report) MeanHops() float64 {
+	return r.mean(func(l LookupReport) float64 { return float64(l.Hops) })
+}
+
+// MeanMessages is the average number of messages sent per lookup
+func (r Report) MeanMessages() float64 {
+	return r.mean(func(l LookupReport) float64 { return float64(l.Messages) })
+}
+
+// MeanLatency is the average virtual time a lookup took
+func (r Report) MeanLatency() time.Duration {
+	return time.Duration(r.mean(func(l LookupReport) float64 { return float64(l.Latency) }))
+}
+
+func (r Report) mean(f func(l LookupReport) float64) float64 {
+	if len(r.Lookups) == 0 {
+		return 0
+	}
+
+	var sum float64
+	for _, l := range r.Lookups {
+		sum += f(l)
+	}
+
+	return sum / float64(len(r.Lookups))
+}
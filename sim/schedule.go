@@ -0,0 +1,80 @@
+package sim
+
+import (
+	"sort"
+	"time"
+)
+
+// Schedule scripts a simulation. Every step runs once the virtual clock reached its time.
+// Steps at the same time run in the order they were added
+type Schedule struct {
+	steps []step
+}
+
+type step struct {
+	at time.Duration
+	do func(s *Simulator)
+}
+
+// Join lets count new nodes join the network at virtual time at
+func (s *Schedule) Join(at time.Duration, count int) *Schedule {
+	return s.add(at, func(sim *Simulator) {
+		for i := 0; i < count; i++ {
+			sim.join()
+		}
+	})
+}
+
+// Leave shuts down count random live nodes at virtual time at. At least one node is always left running
+func (s *Schedule) Leave(at time.Duration, count int) *Schedule {
+	return s.add(at, func(sim *Simulator) {
+		for i := 0; i < count; i++ {
+			sim.leave()
+		}
+	})
+}
+
+// Lookups runs count node lookups one after another at virtual time at.
+// Every lookup starts at a random live node and looks for the id of another random live node
+func (s *Schedule) Lookups(at time.Duration, count int) *Schedule {
+	return s.add(at, func(sim *Simulator) {
+		for i := 0; i < count; i++ {
+			sim.lookup()
+		}
+	})
+}
+
+// Fault runs f at virtual time at. f injects faults with the Network of the simulator,
+// like sim.Network().SetLoss(0.1) or a Partition of sim.Addresses()
+func (s *Schedule) Fault(at time.Duration, f func(sim *Simulator)) *Schedule {
+	return s.add(at, f)
+}
+
+// Churn lets joins nodes join and leaves nodes leave every interval from from until to
+func (s *Schedule) Churn(from, to, interval time.Duration, joins, leaves int) *Schedule {
+	if interval <= 0 {
+		return s
+	}
+
+	for at := from; at <= to; at += interval {
+		s.Join(at, joins)
+		s.Leave(at, leaves)
+	}
+
+	return s
+}
+
+func (s *Schedule) add(at time.Duration, do func(sim *Simulator)) *Schedule {
+	s.steps = append(s.steps, step{at: at, do: do})
+	return s
+}
+
+// sorted returns the steps in the order they run
+func (s *Schedule) sorted() []step {
+	steps := append([]step(nil), s.steps...)
+	sort.SliceStable(steps, func(i, j int) bool {
+		return steps[i].at < steps[j].at
+	})
+
+	return steps
+}
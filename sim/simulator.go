@@ -0,0 +1,386 @@
+package sim
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/alabianca/gokad"
+	"github.com/alabianca/kadnet"
+	"github.com/alabianca/kadnet/kadclock"
+	"github.com/alabianca/kadnet/kadlog"
+	"github.com/alabianca/kadnet/kadrand"
+	"github.com/alabianca/kadnet/memnet"
+	"math/rand"
+	"net"
+	"runtime"
+	"runtime/metrics"
+	"strings"
+	"time"
+)
+
+const ErrListenTimeout = "node did not start listening"
+
+// port is the port of every simulated node. Nodes are told apart by their IP
+const port = 4000
+
+// Epoch is the time every simulation starts at
+var Epoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Simulations run real Nodes with their kadmux and lookup engine on a memnet.Network.
+// Nodes and the network share a virtual kadclock.Manual. Once every goroutine of the nodes is blocked,
+// they all wait for the clock and the next timer fires. Timers fire one at a time, so a datagram is handled
+// before the next one arrives. An hour of churn takes as long as its lookups do.
+// Ids, addresses, the RandomIDs of messages, the order of joins and leaves, the sources and targets of lookups
+// and the fate of every datagram on a link are drawn from the seed, so a run with the same seed and schedule is reproducible
+
+// Config holds the settings of a simulation
+type Config struct {
+	Seed int64
+	// K and Alpha are the settings of every node. They default to the ones of kadnet.NewNode
+	K     int
+	Alpha int
+	// RoundTimeout is the virtual time a contact gets to answer in a lookup. It defaults to the one of kadnet.NewNode
+	RoundTimeout time.Duration
+	// Latency and Jitter are the virtual one way delay of every datagram. See memnet.Network.SetLatency
+	Latency time.Duration
+	Jitter  time.Duration
+	// Lookup holds the options every scripted lookup runs with
+	Lookup kadnet.LookupOptions
+}
+
+// Simulator runs a Schedule on a network of nodes
+type Simulator struct {
+	config  Config
+	clock   *kadclock.Manual
+	rand    *rand.Rand
+	network *memnet.Network
+	// nodes holds the live nodes in the order they joined
+	nodes  []*kadnet.Node
+	joined int
+	report Report
+	err    error
+}
+
+func New(config Config) *Simulator {
+	clock := kadclock.NewManual(Epoch)
+	network := memnet.NewSeededNetwork(config.Seed)
+	network.SetClock(clock)
+	network.SetLatency(config.Latency, config.Jitter)
+
+	return &Simulator{
+		config:  config,
+		clock:   clock,
+		rand:    rand.New(rand.NewSource(config.Seed)),
+		network: network,
+	}
+}
+
+// Run runs every step of schedule and returns the report of the simulation.
+// All nodes are shut down before it returns
+func (s *Simulator) Run(schedule *Schedule) (Report, error) {
+	defer s.shutdown()
+	s.report = Report{Seed: s.config.Seed}
+	start := s.network.Stats()
+
+	for _, st := range schedule.sorted() {
+		s.advance(Epoch.Add(st.at))
+		st.do(s)
+		if s.err != nil {
+			return s.report, s.err
+		}
+	}
+
+	s.report.Messages = s.network.Stats().Sent - start.Sent
+	return s.report, nil
+}
+
+// Clock returns the virtual clock of the simulation
+func (s *Simulator) Clock() *kadclock.Manual {
+	return s.clock
+}
+
+// Elapsed returns the virtual time since the simulation started
+func (s *Simulator) Elapsed() time.Duration {
+	return s.clock.Now().Sub(Epoch)
+}
+
+// Network returns the network the nodes are attached to. Faults are injected through it
+func (s *Simulator) Network() *memnet.Network {
+	return s.network
+}
+
+// Addresses returns the host:port pairs of the live nodes in the order they joined
+func (s *Simulator) Addresses() []string {
+	out := make([]string, len(s.nodes))
+	for i, n := range s.nodes {
+		out[i] = net.JoinHostPort(n.Host, fmt.Sprint(n.Port))
+	}
+
+	return out
+}
+
+// Nodes returns the live nodes in the order they joined
+func (s *Simulator) Nodes() []*kadnet.Node {
+	return append([]*kadnet.Node(nil), s.nodes...)
+}
+
+// join starts a new node and lets it look up its own id through a random live node.
+// The bucket refreshes of Bootstrap are left out to keep large networks fast to build
+func (s *Simulator) join() {
+	s.joined++
+	host := net.IPv4(10, byte(s.joined>>16), byte(s.joined>>8), byte(s.joined)).String()
+	id := make([]byte, gokad.SIZE)
+	s.rand.Read(id)
+	random := kadrand.NewSeeded(s.rand.Int63())
+
+	conn, err := s.network.Listen(net.JoinHostPort(host, fmt.Sprint(port)))
+	if err != nil {
+		s.err = err
+		return
+	}
+
+	dht := gokad.DHTFrom(gokad.DHTConfig{ID: gokad.GenerateID(id)})
+	node := kadnet.NewNode(dht, kadnet.WithConn(conn), kadnet.WithClock(s.clock), kadnet.WithRandom(random), func(n *kadnet.Node) {
+		n.Host = host
+		n.Port = port
+		if s.config.RoundTimeout > 0 {
+			n.RoundTimeout = s.config.RoundTimeout
+		}
+		n.RefreshInterval = 0
+		n.RepublishCheckInterval = 0
+		n.Logger = kadlog.Nop()
+		if s.config.K > 0 {
+			n.K = s.config.K
+		}
+		if s.config.Alpha > 0 {
+			n.Alpha = s.config.Alpha
+		}
+	})
+
+	if err := listen(node); err != nil {
+		s.err = err
+		return
+	}
+
+	var gateway *kadnet.Node
+	if len(s.nodes) > 0 {
+		gateway = s.nodes[s.rand.Intn(len(s.nodes))]
+	}
+	s.nodes = append(s.nodes, node)
+	s.report.Joined++
+	if gateway == nil {
+		return
+	}
+
+	node.Seed(gokad.Contact{ID: gateway.ID(), IP: net.ParseIP(gateway.Host), Port: gateway.Port})
+	var failed error
+	s.await(func() {
+		_, failed = node.LookupContext(context.Background(), node.ID())
+	})
+	if failed != nil {
+		s.report.FailedJoins++
+	}
+}
+
+// leave shuts down a random live node
+func (s *Simulator) leave() {
+	if len(s.nodes) < 2 {
+		return
+	}
+
+	i := s.rand.Intn(len(s.nodes))
+	s.nodes[i].Shutdown()
+	s.nodes = append(s.nodes[:i], s.nodes[i+1:]...)
+	s.report.Left++
+}
+
+// lookup runs a lookup for the id of a random live node from another one
+func (s *Simulator) lookup() {
+	if len(s.nodes) < 2 {
+		return
+	}
+
+	i, j := s.rand.Intn(len(s.nodes)), s.rand.Intn(len(s.nodes)-1)
+	if j >= i {
+		j++
+	}
+	source, target := s.nodes[i], s.nodes[j]
+	res := LookupReport{At: s.Elapsed(), Source: source.ID(), Target: target.ID()}
+
+	events, cancel := source.Subscribe(256)
+	defer cancel()
+	before, start := s.network.Stats(), s.clock.Now()
+	var contacts []gokad.Contact
+	s.await(func() {
+		contacts, res.Err = source.LookupWithOptions(context.Background(), target.ID(), s.config.Lookup)
+	})
+	res.Messages = s.network.Stats().Sent - before.Sent
+	res.Latency = s.clock.Now().Sub(start)
+
+	for _, c := range contacts {
+		if bytes.Equal(c.ID, target.ID()) {
+			res.Success = true
+			break
+		}
+	}
+
+	// the lookup published its result before it returned
+	for found := false; !found; {
+		select {
+		case e := <-events:
+			if e.Type == kadnet.LookupFinished && bytes.Equal(e.Key, target.ID()) {
+				res.Hops = e.Rounds
+				found = true
+			}
+		default:
+			found = true
+		}
+	}
+
+	s.report.Lookups = append(s.report.Lookups, res)
+}
+
+// await runs f and moves the clock whenever the nodes wait for it, until f returned.
+// What f set off at the time it returned comes to rest before await returns
+func (s *Simulator) await(f func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+
+	for s.settle(done) {
+		s.clock.Step()
+	}
+	s.settle(nil)
+}
+
+// advance moves the clock to t. Everything that is due before fires one at a time and gets to settle
+func (s *Simulator) advance(t time.Time) {
+	for {
+		next, ok := s.clock.Next()
+		if !ok || next.After(t) {
+			s.clock.Set(t)
+			return
+		}
+
+		s.clock.Step()
+		s.settle(nil)
+	}
+}
+
+// settle waits until every goroutine of the nodes is blocked, so nothing but the clock can move them on.
+// It returns false if done is closed first
+func (s *Simulator) settle(done <-chan struct{}) bool {
+	for {
+		select {
+		case <-done:
+			return false
+		default:
+		}
+
+		if !busy() && blocked() {
+			// done is closed if f returned right before the goroutines were looked at
+			select {
+			case <-done:
+				return false
+			default:
+				return true
+			}
+		}
+		runtime.Gosched()
+	}
+}
+
+// busy reports whether goroutines other than the calling one run or wait to run. The runtime only counts them
+// approximately, so an idle reading is confirmed with blocked. Runtimes without the metrics never report busy
+func busy() bool {
+	samples := []metrics.Sample{
+		{Name: "/sched/goroutines/runnable:goroutines"},
+		{Name: "/sched/goroutines/running:goroutines"},
+	}
+	metrics.Read(samples)
+
+	var n uint64
+	for _, sample := range samples {
+		if sample.Value.Kind() == metrics.KindUint64 {
+			n += sample.Value.Uint64()
+		}
+	}
+
+	return n > 1
+}
+
+// blocked reports whether every goroutine running code of this module, except the calling one, waits on a channel
+// or a lock. runtime.Stack stops the world, so the states are taken at the same instant
+func blocked() bool {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+
+	// the first goroutine in the dump is the calling one
+	goroutines := strings.Split(string(buf), "\n\n")
+	for _, g := range goroutines[1:] {
+		if !strings.Contains(g, "github.com/alabianca/kadnet") {
+			continue
+		}
+		if !waiting(g) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// waiting reports whether the state in the header of a goroutine of a stack dump, like "goroutine 7 [select]:", is a blocked one
+func waiting(g string) bool {
+	start, end := strings.Index(g, "["), strings.Index(g, "]")
+	if start < 0 || end < start {
+		return false
+	}
+
+	state := g[start+1 : end]
+	for _, prefix := range []string{"chan ", "select", "semacquire", "sync."} {
+		if strings.HasPrefix(state, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Simulator) shutdown() {
+	for _, n := range s.nodes {
+		n.Shutdown()
+	}
+	s.nodes = nil
+}
+
+// listen starts node and waits until it is ready
+func listen(node *kadnet.Node) error {
+	events, cancel := node.Subscribe(16)
+	defer cancel()
+	go node.Listen(nil)
+
+	timeout := time.After(time.Second * 5)
+	for {
+		select {
+		case e := <-events:
+			switch e.Type {
+			case kadnet.Listening:
+				return nil
+			case kadnet.ListenError:
+				return e.Err
+			}
+		case <-timeout:
+			return errors.New(ErrListenTimeout)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package sim
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSimulator_Run(t *testing.T) {
+	schedule := new(Schedule).
+		Join(0, 40).
+		Lookups(time.Minute, 20).
+		Churn(time.Minute*2, time.Minute*4, time.Minute, 2, 3).
+		Fault(time.Minute*5, func(s *Simulator) { s.Network().SetLoss(0.05) }).
+		Lookups(time.Minute*5, 20)
+
+	run := func() Report {
+		report, err := New(Config{Seed: 42, K: 8, Latency: time.Millisecond * 20}).Run(schedule)
+		if err != nil {
+			t.Fatalf("Expected err to be nil, but got %s\n", err)
+		}
+
+		return report
+	}
+
+	first, second := run(), run()
+	if first.Joined != 46 || first.Left != 9 || len(first.Lookups) != 40 {
+		t.Fatalf("Expected 46 joins, 9 leaves and 40 lookups, but got %d, %d and %d\n", first.Joined, first.Left, len(first.Lookups))
+	}
+
+	for i := range first.Lookups {
+		a, b := first.Lookups[i], second.Lookups[i]
+		if !bytes.Equal(a.Source, b.Source) || !bytes.Equal(a.Target, b.Target) || a.Hops != b.Hops ||
+			a.Messages != b.Messages || a.Latency != b.Latency || a.Success != b.Success {
+			t.Fatalf("Expected lookup %d to be the same in runs with the same seed, but got %+v and %+v\n", i, a, b)
+		}
+	}
+
+	if ratio := first.SuccessRatio(); ratio < 0.9 {
+		t.Fatalf("Expected at least 90%% of the lookups to succeed, but got %.2f\n", ratio)
+	}
+	if first.MeanHops() < 1 || first.MeanMessages() == 0 || first.MeanLatency() < time.Millisecond*40 {
+		t.Fatalf("Expected every lookup to take hops and messages, but got %.2f hops, %.2f messages and %s\n",
+			first.MeanHops(), first.MeanMessages(), first.MeanLatency())
+	}
+}
@@ -0,0 +1,225 @@
+package kadnet
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/alabianca/gokad"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Routing table snapshot format. All integers are big endian.
+// <- 4 Bytes  <- 1 Byte  <- 4 Bytes      <- X Bytes
+//  Magic        Version    ContactCount     Contacts
+//
+// Every contact is
+// <- 20 Bytes  <- 2 Bytes  <- 1 Byte  <- X Bytes
+//  ID            Port        IPLength    IP
+
+const (
+	snapshotVersion = 1
+	// maxParallelPings bounds the number of saved contacts that are pinged at once on a warm restart
+	maxParallelPings = 32
+)
+
+var snapshotMagic = []byte("KRTS")
+
+const (
+	ErrSnapshotMalformed = "malformed routing table snapshot"
+	ErrSnapshotVersion   = "unsupported routing table snapshot version"
+)
+
+// SaveRoutingTable writes every contact of the routing table to w
+func (n *Node) SaveRoutingTable(w io.Writer) error {
+	contacts := make([]gokad.Contact, 0)
+	n.Walk(func(index int, c gokad.Contact) {
+		contacts = append(contacts, c)
+	})
+
+	return writeSnapshot(w, contacts)
+}
+
+// LoadRoutingTable reads a snapshot written by SaveRoutingTable and inserts all of its contacts
+// into the routing table. The contacts are not checked for liveness
+func (n *Node) LoadRoutingTable(r io.Reader) error {
+	contacts, err := readSnapshot(r)
+	if err != nil {
+		return err
+	}
+
+	n.Seed(contacts...)
+	return nil
+}
+
+// saveSnapshot atomically replaces the snapshot file at path
+func (n *Node) saveSnapshot(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	if err := n.SaveRoutingTable(w); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (n *Node) readSnapshotFile(path string) ([]gokad.Contact, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return readSnapshot(bufio.NewReader(f))
+}
+
+func writeSnapshot(w io.Writer, contacts []gokad.Contact) error {
+	header := make([]byte, 0, 9)
+	header = append(header, snapshotMagic...)
+	header = append(header, snapshotVersion)
+	count := make([]byte, 4)
+	binary.BigEndian.PutUint32(count, uint32(len(contacts)))
+	header = append(header, count...)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	for _, c := range contacts {
+		if len(c.ID) != gokad.SIZE {
+			return fmt.Errorf("contact %s has an invalid id", c.ID)
+		}
+
+		ip := c.IP.To4()
+		if ip == nil {
+			ip = c.IP.To16()
+		}
+
+		out := make([]byte, 0, gokad.SIZE+3+len(ip))
+		out = append(out, c.ID...)
+		port := make([]byte, 2)
+		binary.BigEndian.PutUint16(port, uint16(c.Port))
+		out = append(out, port...)
+		out = append(out, byte(len(ip)))
+		out = append(out, ip...)
+		if _, err := w.Write(out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readSnapshot(r io.Reader) ([]gokad.Contact, error) {
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, errors.New(ErrSnapshotMalformed)
+	}
+
+	if string(header[:4]) != string(snapshotMagic) {
+		return nil, errors.New(ErrSnapshotMalformed)
+	}
+
+	if header[4] != snapshotVersion {
+		return nil, errors.New(ErrSnapshotVersion)
+	}
+
+	count := binary.BigEndian.Uint32(header[5:])
+	out := make([]gokad.Contact, 0)
+	for i := uint32(0); i < count; i++ {
+		fixed := make([]byte, gokad.SIZE+3)
+		if _, err := io.ReadFull(r, fixed); err != nil {
+			return nil, errors.New(ErrSnapshotMalformed)
+		}
+
+		ipLen := int(fixed[gokad.SIZE+2])
+		if ipLen != net.IPv4len && ipLen != net.IPv6len {
+			return nil, errors.New(ErrSnapshotMalformed)
+		}
+
+		ip := make([]byte, ipLen)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return nil, errors.New(ErrSnapshotMalformed)
+		}
+
+		id := make(gokad.ID, gokad.SIZE)
+		copy(id, fixed[:gokad.SIZE])
+		out = append(out, gokad.Contact{
+			ID:   id,
+			IP:   net.IP(ip),
+			Port: int(binary.BigEndian.Uint16(fixed[gokad.SIZE : gokad.SIZE+2])),
+		})
+	}
+
+	return out, nil
+}
+
+// reseeder pings the contacts of a routing table snapshot in parallel once the node is listening
+// and inserts the ones that are still alive
+type reseeder struct {
+	node     *Node
+	contacts []gokad.Contact
+}
+
+func newReseeder(n *Node, contacts []gokad.Contact) *reseeder {
+	return &reseeder{node: n, contacts: contacts}
+}
+
+// Run re-seeds the routing table and waits to receive on exit.
+// Pings that are in progress are cancelled before Run returns
+func (r *reseeder) Run(exit <-chan chan error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.reseed(ctx)
+	}()
+
+	out := <-exit
+	cancel()
+	<-done
+	out <- nil
+}
+
+func (r *reseeder) reseed(ctx context.Context) {
+	sem := make(chan struct{}, maxParallelPings)
+	var wg sync.WaitGroup
+	for _, c := range r.contacts {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		}
+
+		wg.Add(1)
+		go func(contact gokad.Contact) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			live, err := r.node.PingContext(ctx, contact.IP, contact.Port, contact.ID)
+			if err != nil {
+				return
+			}
+			r.node.dht.insert(live)
+		}(c)
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,124 @@
+package kadnet
+
+import (
+	"bytes"
+	"github.com/alabianca/gokad"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNode_SaveAndLoadRoutingTable(t *testing.T) {
+	node1 := NewNode(gokad.NewDHT())
+	node2 := NewNode(gokad.NewDHT())
+
+	contacts := []gokad.Contact{
+		{ID: gokad.GenerateRandomID(), IP: net.ParseIP("127.0.0.1"), Port: 5001},
+		{ID: gokad.GenerateRandomID(), IP: net.ParseIP("10.0.0.1"), Port: 5002},
+		{ID: gokad.GenerateRandomID(), IP: net.ParseIP("fe80::1"), Port: 5003},
+	}
+	node1.Seed(contacts...)
+
+	var buf bytes.Buffer
+	if err := node1.SaveRoutingTable(&buf); err != nil {
+		t.Fatalf("Expected save error to be nil, but got %s\n", err)
+	}
+
+	if err := node2.LoadRoutingTable(&buf); err != nil {
+		t.Fatalf("Expected load error to be nil, but got %s\n", err)
+	}
+
+	loaded := make(map[string]gokad.Contact)
+	node2.Walk(func(index int, c gokad.Contact) {
+		loaded[c.ID.String()] = c
+	})
+
+	if len(loaded) != len(contacts) {
+		t.Fatalf("Expected %d contacts to be loaded, but got %d\n", len(contacts), len(loaded))
+	}
+
+	for _, c := range contacts {
+		l, ok := loaded[c.ID.String()]
+		if !ok || !l.IP.Equal(c.IP) || l.Port != c.Port {
+			t.Fatalf("Expected contact %s %s:%d to be loaded, but got %s:%d\n", c.ID, c.IP, c.Port, l.IP, l.Port)
+		}
+	}
+}
+
+func TestNode_SaveRoutingTable_Concurrent(t *testing.T) {
+	node := NewNode(gokad.NewDHT())
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			node.Seed(gokad.Contact{ID: gokad.GenerateRandomID(), IP: net.ParseIP("127.0.0.1"), Port: 5000 + i})
+		}
+	}()
+
+	// run with -race to see the walk and the inserts do not interleave
+	for i := 0; i < 20; i++ {
+		if err := node.SaveRoutingTable(&bytes.Buffer{}); err != nil {
+			t.Fatalf("Expected save error to be nil, but got %s\n", err)
+		}
+	}
+	<-done
+}
+
+func TestNode_LoadRoutingTable_Version(t *testing.T) {
+	var buf bytes.Buffer
+	writeSnapshot(&buf, nil)
+	b := buf.Bytes()
+	b[4] = snapshotVersion + 1
+
+	err := NewNode(gokad.NewDHT()).LoadRoutingTable(bytes.NewReader(b))
+	if err == nil || err.Error() != ErrSnapshotVersion {
+		t.Fatalf("Expected error to be %s, but got %v\n", ErrSnapshotVersion, err)
+	}
+}
+
+func TestNode_WarmRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routing.snapshot")
+	dht1 := gokad.NewDHT()
+	node1 := NewNode(dht1, func(n *Node) {
+		n.Port = 5001
+		n.SnapshotPath = path
+	})
+	node2 := NewNode(gokad.NewDHT(), func(n *Node) { n.Port = 5002 })
+	go node1.Listen(nil)
+	go node2.Listen(nil)
+	defer shutdown(node2)
+
+	<-wait(node1, node2)
+
+	// node2 is alive. nobody is listening for the other contact
+	node1.Seed(
+		gokad.Contact{ID: node2.ID(), IP: net.ParseIP(node2.Host), Port: node2.Port},
+		gokad.Contact{ID: gokad.GenerateRandomID(), IP: net.ParseIP("127.0.0.1"), Port: 5009},
+	)
+
+	if err := node1.Shutdown(); err != nil {
+		t.Fatalf("Expected shutdown error to be nil, but got %s\n", err)
+	}
+
+	// restart node1 with an empty routing table
+	restarted := NewNode(gokad.DHTFrom(gokad.DHTConfig{ID: dht1.ID}), func(n *Node) {
+		n.Port = 5003
+		n.SnapshotPath = path
+	})
+	go restarted.Listen(nil)
+	defer shutdown(restarted)
+	<-wait(restarted)
+
+	time.Sleep(time.Second * 3)
+
+	var cs []gokad.Contact
+	restarted.Walk(func(index int, c gokad.Contact) {
+		cs = append(cs, c)
+	})
+
+	if len(cs) != 1 || !reflect.DeepEqual(cs[0].ID, node2.ID()) {
+		t.Fatalf("Expected only node2 to be re-seeded, but got %d contacts\n", len(cs))
+	}
+}
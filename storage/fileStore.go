@@ -0,0 +1,475 @@
+package storage
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"github.com/alabianca/gokad"
+	"github.com/alabianca/kadnet/mutable"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const ErrFileStoreMalformed = "malformed file store"
+const ErrFileStoreClosed = "file store closed"
+
+// File store format. The file is an append only log of operations that is replayed on open.
+// All integers are big endian.
+// <- 4 Bytes  <- 1 Byte  <- X Bytes
+//  Magic        Version    Entries
+//
+// Every entry is
+// <- 1 Byte  <- 20 Bytes  <- 2 Bytes  <- 1 Byte  <- X Bytes  <- 8 Bytes  <- 8 Bytes
+//  Op          Key          Port        IPLength    IP          Expires     Stored
+// Delete entries end after the key. Remove entries end after the IP.
+//
+// Data records use their own entries
+// <- 1 Byte  <- 20 Bytes  <- 4 Bytes  <- X Bytes  <- 8 Bytes  <- 8 Bytes
+//  Op          Key          Length      Data        Expires     Stored
+// RemoveData entries end after the key.
+//
+// Mutable data records hold the encoded mutable.Record instead of the length and data
+// <- 1 Byte  <- 20 Bytes  <- X Bytes  <- 8 Bytes  <- 8 Bytes
+//  Op          Key          Record      Expires     Stored
+
+const (
+	fileStoreVersion = 1
+	opPut            = byte(1)
+	opDelete         = byte(2)
+	opRemove         = byte(3)
+	opPutData        = byte(4)
+	opRemoveData     = byte(5)
+	opPutMutable     = byte(6)
+	// the log is compacted once it holds this many more entries than live records
+	compactThreshold = 1024
+)
+
+var fileStoreMagic = []byte("KVLS")
+
+// FileStore keeps all records in memory and in an append only log file, so they survive restarts.
+// FileStore implements the storage.ValueStore interface.
+type FileStore struct {
+	mtx     sync.Mutex
+	path    string
+	file    *os.File
+	records *MemoryStore
+	entries int // number of entries in the log
+}
+
+// NewFileStore opens the file store at path. The file is created if it does not exist
+func NewFileStore(path string) (*FileStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &FileStore{
+		path:    path,
+		file:    f,
+		records: NewMemoryStore(),
+	}
+
+	if err := fs.replay(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return fs, nil
+}
+
+func (fs *FileStore) Put(r Record) error {
+	if len(r.Key) != gokad.SIZE {
+		return errors.New("invalid key")
+	}
+
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+	if err := fs.append(encode(r)); err != nil {
+		return err
+	}
+	fs.records.Put(r)
+
+	return fs.compactIfNeeded()
+}
+
+func (fs *FileStore) Get(key gokad.ID) ([]Record, error) {
+	return fs.records.Get(key)
+}
+
+func (fs *FileStore) Delete(key gokad.ID) error {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+	if rs, _ := fs.records.Get(key); len(rs) == 0 {
+		return nil
+	}
+
+	entry := append([]byte{opDelete}, key...)
+	if err := fs.append(entry); err != nil {
+		return err
+	}
+	fs.records.Delete(key)
+
+	return fs.compactIfNeeded()
+}
+
+func (fs *FileStore) Remove(r Record) error {
+	if len(r.Key) != gokad.SIZE {
+		return errors.New("invalid key")
+	}
+
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+	if !fs.holds(r) {
+		return nil
+	}
+
+	if err := fs.append(removeEntry(r)); err != nil {
+		return err
+	}
+	fs.records.Remove(r)
+
+	return fs.compactIfNeeded()
+}
+
+func (fs *FileStore) RemoveIfExpired(key gokad.ID, provider string, now time.Time) (bool, error) {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+	r, ok := fs.held(key, provider)
+	if !ok || !r.Expired(now) {
+		return false, nil
+	}
+
+	if err := fs.append(removeEntry(r)); err != nil {
+		return false, err
+	}
+	fs.records.RemoveIfExpired(key, provider, now)
+
+	return true, fs.compactIfNeeded()
+}
+
+// holds reports whether a record of the provider of r is stored for r.Key
+func (fs *FileStore) holds(r Record) bool {
+	_, ok := fs.held(r.Key, r.Provider())
+	return ok
+}
+
+// held returns the record of provider stored for key
+func (fs *FileStore) held(key gokad.ID, provider string) (Record, bool) {
+	rs, _ := fs.records.Get(key)
+	for _, r := range rs {
+		if r.Provider() == provider {
+			return r, true
+		}
+	}
+
+	return Record{}, false
+}
+
+// removeEntry returns the log entry that removes r
+func removeEntry(r Record) []byte {
+	if r.IsData() {
+		return append([]byte{opRemoveData}, r.Key...)
+	}
+
+	return append(append([]byte{opRemove}, r.Key...), encodeValue(r.Value)...)
+}
+
+func (fs *FileStore) Iterate(f func(r Record) bool) error {
+	return fs.records.Iterate(f)
+}
+
+func (fs *FileStore) Len() int {
+	return fs.records.Len()
+}
+
+// Close closes the underlying file. The store must not be used afterwards
+func (fs *FileStore) Close() error {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+	if fs.file == nil {
+		return nil
+	}
+
+	err := fs.file.Close()
+	fs.file = nil
+	return err
+}
+
+func (fs *FileStore) append(entry []byte) error {
+	if fs.file == nil {
+		return errors.New(ErrFileStoreClosed)
+	}
+
+	if _, err := fs.file.Write(entry); err != nil {
+		return err
+	}
+	if err := fs.file.Sync(); err != nil {
+		return err
+	}
+	fs.entries++
+
+	return nil
+}
+
+// compactIfNeeded compacts the log once it holds compactThreshold entries more than there are records.
+// It must only be called after the records reflect every appended entry, as the log is rebuilt from them
+func (fs *FileStore) compactIfNeeded() error {
+	if fs.entries > fs.records.Len()+compactThreshold {
+		return fs.compact()
+	}
+
+	return nil
+}
+
+// replay reads the log from the start and rebuilds the records.
+// A partially written entry at the end of the log is cut off. Any other broken entry fails with ErrFileStoreMalformed,
+// so the entries after it are not lost
+func (fs *FileStore) replay() error {
+	info, err := fs.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() == 0 {
+		header := append(append([]byte{}, fileStoreMagic...), fileStoreVersion)
+		_, err := fs.file.Write(header)
+		return err
+	}
+
+	r := bufio.NewReader(fs.file)
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:4]) != string(fileStoreMagic) {
+		return errors.New(ErrFileStoreMalformed)
+	}
+	if header[4] != fileStoreVersion {
+		return errors.New(ErrFileStoreMalformed)
+	}
+
+	offset := int64(len(header))
+	for {
+		n, err := fs.replayEntry(r)
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			// the process stopped in the middle of writing this entry
+			if err := fs.file.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil {
+			return errors.New(ErrFileStoreMalformed)
+		}
+		offset += int64(n)
+		fs.entries++
+	}
+
+	_, err = fs.file.Seek(offset, io.SeekStart)
+	return err
+}
+
+func (fs *FileStore) replayEntry(r *bufio.Reader) (int, error) {
+	op, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	key := make(gokad.ID, gokad.SIZE)
+	if _, err := io.ReadFull(r, key); err != nil {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	switch op {
+	case opDelete:
+		fs.records.Delete(key)
+		return 1 + gokad.SIZE, nil
+	case opRemove:
+		value, n, err := decodeValue(r)
+		if err != nil {
+			return 0, err
+		}
+
+		fs.records.Remove(Record{Key: key, Value: value})
+		return 1 + gokad.SIZE + n, nil
+	case opRemoveData:
+		fs.records.Remove(Record{Key: key, Data: []byte{}})
+		return 1 + gokad.SIZE, nil
+	case opPutMutable:
+		// the record is self delimiting but its length is only known once it is read
+		fixed := make([]byte, ed25519.PublicKeySize+8+ed25519.SignatureSize+1)
+		if _, err := io.ReadFull(r, fixed); err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		saltAndLength := make([]byte, int(fixed[len(fixed)-1])+4)
+		if _, err := io.ReadFull(r, saltAndLength); err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		rest := make([]byte, int(binary.BigEndian.Uint32(saltAndLength[len(saltAndLength)-4:]))+16)
+		if _, err := io.ReadFull(r, rest); err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+
+		encoded := append(append(fixed, saltAndLength...), rest[:len(rest)-16]...)
+		record, n, err := mutable.Decode(encoded)
+		if err != nil {
+			return 0, errors.New(ErrFileStoreMalformed)
+		}
+
+		times := rest[len(rest)-16:]
+		fs.records.Put(Record{
+			Key:     key,
+			Data:    record.Data,
+			Mutable: &record,
+			Expires: time.Unix(0, int64(binary.BigEndian.Uint64(times[:8]))),
+			Stored:  time.Unix(0, int64(binary.BigEndian.Uint64(times[8:]))),
+		})
+		return 1 + gokad.SIZE + n + 16, nil
+	case opPutData:
+		length := make([]byte, 4)
+		if _, err := io.ReadFull(r, length); err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		rest := make([]byte, int(binary.BigEndian.Uint32(length))+16)
+		if _, err := io.ReadFull(r, rest); err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+
+		n := len(rest) - 16
+		fs.records.Put(Record{
+			Key:     key,
+			Data:    rest[:n],
+			Expires: time.Unix(0, int64(binary.BigEndian.Uint64(rest[n:n+8]))),
+			Stored:  time.Unix(0, int64(binary.BigEndian.Uint64(rest[n+8:]))),
+		})
+		return 1 + gokad.SIZE + 4 + len(rest), nil
+	case opPut:
+		value, n, err := decodeValue(r)
+		if err != nil {
+			return 0, err
+		}
+		times := make([]byte, 16)
+		if _, err := io.ReadFull(r, times); err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+
+		fs.records.Put(Record{
+			Key:     key,
+			Value:   value,
+			Expires: time.Unix(0, int64(binary.BigEndian.Uint64(times[:8]))),
+			Stored:  time.Unix(0, int64(binary.BigEndian.Uint64(times[8:]))),
+		})
+		return 1 + gokad.SIZE + n + 16, nil
+	default:
+		return 0, errors.New(ErrFileStoreMalformed)
+	}
+}
+
+// compact rewrites the log so it only holds the live records
+func (fs *FileStore) compact() error {
+	tmpPath := fs.path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(tmp)
+	w.Write(fileStoreMagic)
+	w.WriteByte(fileStoreVersion)
+	var entries int
+	fs.records.Iterate(func(r Record) bool {
+		w.Write(encode(r))
+		entries++
+		return true
+	})
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	// the compacted log must be on disk before it replaces the old one
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, fs.path); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	fs.file.Close()
+	fs.file = tmp
+	fs.entries = entries
+	return nil
+}
+
+// encode returns the put entry of r
+func encode(r Record) []byte {
+	var value []byte
+	op := opPut
+	if r.Mutable != nil {
+		op = opPutMutable
+		value = r.Mutable.Encode()
+	} else if r.IsData() {
+		op = opPutData
+		value = make([]byte, 4, 4+len(r.Data))
+		binary.BigEndian.PutUint32(value, uint32(len(r.Data)))
+		value = append(value, r.Data...)
+	} else {
+		value = encodeValue(r.Value)
+	}
+
+	out := make([]byte, 0, 1+gokad.SIZE+len(value)+16)
+	out = append(out, op)
+	out = append(out, r.Key...)
+	out = append(out, value...)
+	times := make([]byte, 16)
+	binary.BigEndian.PutUint64(times[:8], uint64(r.Expires.UnixNano()))
+	binary.BigEndian.PutUint64(times[8:], uint64(r.Stored.UnixNano()))
+	out = append(out, times...)
+
+	return out
+}
+
+// encodeValue encodes value as Port, IPLength and IP
+func encodeValue(value gokad.Value) []byte {
+	ip := value.Host.To4()
+	if ip == nil {
+		ip = value.Host.To16()
+	}
+
+	out := make([]byte, 3, 3+len(ip))
+	binary.BigEndian.PutUint16(out[:2], uint16(value.Port))
+	out[2] = byte(len(ip))
+	return append(out, ip...)
+}
+
+// decodeValue reads a value written by encodeValue and returns it with the number of bytes read
+func decodeValue(r *bufio.Reader) (gokad.Value, int, error) {
+	fixed := make([]byte, 3)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return gokad.Value{}, 0, io.ErrUnexpectedEOF
+	}
+	if fixed[2] != net.IPv4len && fixed[2] != net.IPv6len {
+		return gokad.Value{}, 0, errors.New(ErrFileStoreMalformed)
+	}
+	ip := make([]byte, int(fixed[2]))
+	if _, err := io.ReadFull(r, ip); err != nil {
+		return gokad.Value{}, 0, io.ErrUnexpectedEOF
+	}
+
+	value := gokad.Value{
+		Host: net.IP(ip),
+		Port: int(binary.BigEndian.Uint16(fixed[:2])),
+	}
+
+	return value, 3 + len(ip), nil
+}
@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"crypto/ed25519"
+	"github.com/alabianca/gokad"
+	"github.com/alabianca/kadnet/mutable"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStore_Reopen(t *testing.T) {
+	path := tempStorePath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Expected err to be nil, but got %s\n", err)
+	}
+
+	kept := generateRecord(time.Hour)
+	deleted := generateRecord(time.Hour)
+	removed := kept
+	removed.Value.Port++
+	store.Put(kept)
+	store.Put(removed)
+	store.Put(deleted)
+	store.Delete(deleted.Key)
+	store.Remove(removed)
+	store.Close()
+
+	store, err = NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Expected err to be nil, but got %s\n", err)
+	}
+	defer store.Close()
+
+	if store.Len() != 1 {
+		t.Fatalf("Expected 1 record after reopen, but got %d\n", store.Len())
+	}
+
+	rs, _ := store.Get(kept.Key)
+	if len(rs) != 1 {
+		t.Fatalf("Expected only record %s to survive a reopen, but got %v\n", kept.Key, rs)
+	}
+
+	if res := rs[0]; !res.Expires.Equal(kept.Expires) || res.Value.Port != kept.Value.Port {
+		t.Fatalf("Expected record %v, but got %v\n", kept, res)
+	}
+
+	if rs, _ := store.Get(deleted.Key); len(rs) != 0 {
+		t.Fatalf("Expected deleted record to stay deleted after reopen\n")
+	}
+}
+
+func TestFileStore_Data(t *testing.T) {
+	path := tempStorePath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	store, _ := NewFileStore(path)
+	kept := generateRecord(time.Hour)
+	kept.Value = gokad.Value{}
+	kept.Data = []byte(`{"service":"api"}`)
+	removed := generateRecord(time.Hour)
+	removed.Value = gokad.Value{}
+	removed.Data = []byte{}
+	store.Put(kept)
+	store.Put(removed)
+	store.Remove(removed)
+	store.Close()
+
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Expected err to be nil, but got %s\n", err)
+	}
+	defer store.Close()
+
+	rs, _ := store.Get(kept.Key)
+	if len(rs) != 1 || string(rs[0].Data) != string(kept.Data) || !rs[0].Expires.Equal(kept.Expires) {
+		t.Fatalf("Expected data record %v to survive a reopen, but got %v\n", kept, rs)
+	}
+
+	if store.Len() != 1 {
+		t.Fatalf("Expected the removed data record to stay removed, but got %d records\n", store.Len())
+	}
+}
+
+func TestFileStore_Mutable(t *testing.T) {
+	path := tempStorePath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	_, priv, _ := ed25519.GenerateKey(nil)
+	signed, _ := mutable.Sign(priv, []byte("salt"), 3, []byte("v3"))
+
+	store, _ := NewFileStore(path)
+	kept := generateRecord(time.Hour)
+	kept.Key = signed.Key()
+	kept.Value = gokad.Value{}
+	kept.Data = signed.Data
+	kept.Mutable = &signed
+	store.Put(kept)
+	store.Close()
+
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Expected err to be nil, but got %s\n", err)
+	}
+	defer store.Close()
+
+	rs, _ := store.Get(kept.Key)
+	if len(rs) != 1 || rs[0].Mutable == nil || rs[0].Mutable.Seq != signed.Seq || string(rs[0].Data) != string(signed.Data) {
+		t.Fatalf("Expected mutable record %v to survive a reopen, but got %v\n", kept, rs)
+	}
+
+	if err := rs[0].Mutable.Verify(); err != nil {
+		t.Fatalf("Expected the reopened record to verify, but got %s\n", err)
+	}
+}
+
+func TestFileStore_PartialEntry(t *testing.T) {
+	path := tempStorePath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	store, _ := NewFileStore(path)
+	record := generateRecord(time.Hour)
+	store.Put(record)
+	store.Close()
+
+	// simulate a crash in the middle of writing an entry
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	f.Write([]byte{opPut, 1, 2, 3})
+	f.Close()
+
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Expected err to be nil, but got %s\n", err)
+	}
+
+	if rs, _ := store.Get(record.Key); len(rs) != 1 {
+		t.Fatalf("Expected record to survive a partial tail entry\n")
+	}
+
+	// the partial entry must be gone so new entries are readable
+	next := generateRecord(time.Hour)
+	store.Put(next)
+	store.Close()
+
+	store, _ = NewFileStore(path)
+	defer store.Close()
+	if store.Len() != 2 {
+		t.Fatalf("Expected 2 records, but got %d\n", store.Len())
+	}
+}
+
+func TestFileStore_Malformed(t *testing.T) {
+	path := tempStorePath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	ioutil.WriteFile(path, []byte("nope!"), 0600)
+	if _, err := NewFileStore(path); err == nil {
+		t.Fatalf("Expected %s, but got nil\n", ErrFileStoreMalformed)
+	}
+}
+
+func TestFileStore_CorruptEntry(t *testing.T) {
+	path := tempStorePath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	store, _ := NewFileStore(path)
+	store.Put(generateRecord(time.Hour))
+	store.Close()
+
+	// an unknown op in the middle of the log is followed by a valid entry
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	f.Write(append([]byte{0xff}, make([]byte, gokad.SIZE)...))
+	f.Write(encode(generateRecord(time.Hour)))
+	f.Close()
+	before, _ := os.Stat(path)
+
+	if _, err := NewFileStore(path); err == nil || err.Error() != ErrFileStoreMalformed {
+		t.Fatalf("Expected %s, but got %v\n", ErrFileStoreMalformed, err)
+	}
+
+	// the entries after the corrupt one are kept
+	if after, _ := os.Stat(path); after.Size() != before.Size() {
+		t.Fatalf("Expected the log to keep its %d bytes, but it has %d\n", before.Size(), after.Size())
+	}
+}
+
+func TestFileStore_Compact(t *testing.T) {
+	path := tempStorePath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	store, _ := NewFileStore(path)
+	kept := generateRecord(time.Hour)
+	churn := generateRecord(time.Hour)
+	store.Put(kept)
+
+	// the Delete that crosses compactThreshold must not bring churn back
+	compactAfter(store, func() { store.Put(churn) }, func() { store.Delete(churn.Key) })
+	store.Close()
+
+	store, _ = NewFileStore(path)
+	if store.Len() != 1 {
+		t.Fatalf("Expected 1 record after a compacting Delete, but got %d\n", store.Len())
+	}
+
+	// the Put that crosses compactThreshold must survive a reopen
+	store.Put(churn)
+	compactAfter(store, func() {
+		churn.Expires = churn.Expires.Add(time.Second)
+		store.Put(churn)
+	})
+	store.Close()
+
+	store, _ = NewFileStore(path)
+	defer store.Close()
+	rs, _ := store.Get(churn.Key)
+	if len(rs) != 1 || !rs[0].Expires.Equal(churn.Expires) {
+		t.Fatalf("Expected record %v after a compacting Put, but got %v\n", churn, rs)
+	}
+
+	if rs, _ := store.Get(kept.Key); len(rs) != 1 {
+		t.Fatalf("Expected record %s to survive compaction\n", kept.Key)
+	}
+}
+
+// compactAfter runs ops in turn until one of them compacts the log
+func compactAfter(store *FileStore, ops ...func()) {
+	for {
+		for _, op := range ops {
+			before := store.entries
+			op()
+			if store.entries <= before {
+				return
+			}
+		}
+	}
+}
+
+func tempStorePath(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "kadnet-store")
+	if err != nil {
+		t.Fatalf("Could not create temp dir %s\n", err)
+	}
+
+	return filepath.Join(dir, "values.log")
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"github.com/alabianca/gokad"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps all records in memory. They are lost when the process exits.
+// MemoryStore implements the storage.ValueStore interface.
+type MemoryStore struct {
+	mtx sync.RWMutex
+	// records maps a key to its providers
+	records map[string]map[string]Record
+	len     int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		records: make(map[string]map[string]Record),
+	}
+}
+
+func (m *MemoryStore) Put(r Record) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	key := r.Key.String()
+	providers, ok := m.records[key]
+	if !ok {
+		providers = make(map[string]Record)
+		m.records[key] = providers
+	}
+
+	provider := r.Provider()
+	if _, ok := providers[provider]; !ok {
+		m.len++
+	}
+	providers[provider] = r
+	return nil
+}
+
+func (m *MemoryStore) Get(key gokad.ID) ([]Record, error) {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	providers := m.records[key.String()]
+	out := make([]Record, 0, len(providers))
+	for _, r := range providers {
+		out = append(out, r)
+	}
+
+	return out, nil
+}
+
+func (m *MemoryStore) Delete(key gokad.ID) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.len -= len(m.records[key.String()])
+	delete(m.records, key.String())
+	return nil
+}
+
+func (m *MemoryStore) Remove(r Record) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	key := r.Key.String()
+	providers, ok := m.records[key]
+	if !ok {
+		return nil
+	}
+
+	provider := r.Provider()
+	if _, ok := providers[provider]; !ok {
+		return nil
+	}
+
+	delete(providers, provider)
+	m.len--
+	if len(providers) == 0 {
+		delete(m.records, key)
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) RemoveIfExpired(key gokad.ID, provider string, now time.Time) (bool, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	providers := m.records[key.String()]
+	r, ok := providers[provider]
+	if !ok || !r.Expired(now) {
+		return false, nil
+	}
+
+	delete(providers, provider)
+	m.len--
+	if len(providers) == 0 {
+		delete(m.records, key.String())
+	}
+
+	return true, nil
+}
+
+// Iterate walks over a snapshot of the records so f may call back into the store
+func (m *MemoryStore) Iterate(f func(r Record) bool) error {
+	m.mtx.RLock()
+	snapshot := make([]Record, 0, m.len)
+	for _, providers := range m.records {
+		for _, r := range providers {
+			snapshot = append(snapshot, r)
+		}
+	}
+	m.mtx.RUnlock()
+
+	for _, r := range snapshot {
+		if !f(r) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) Len() int {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	return m.len
+}
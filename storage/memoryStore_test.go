@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"github.com/alabianca/gokad"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore_PutGetDelete(t *testing.T) {
+	store := NewMemoryStore()
+	record := generateRecord(time.Hour)
+
+	if err := store.Put(record); err != nil {
+		t.Fatalf("Expected err to be nil, but got %s\n", err)
+	}
+
+	rs, err := store.Get(record.Key)
+	if err != nil || len(rs) != 1 {
+		t.Fatalf("Expected record to be found, but got %v err=%v\n", rs, err)
+	}
+
+	if res := rs[0]; !res.Value.Host.Equal(record.Value.Host) || res.Value.Port != record.Value.Port {
+		t.Fatalf("Expected value %v, but got %v\n", record.Value, res.Value)
+	}
+
+	if store.Len() != 1 {
+		t.Fatalf("Expected store to hold 1 record, but got %d\n", store.Len())
+	}
+
+	store.Delete(record.Key)
+	if rs, _ := store.Get(record.Key); len(rs) != 0 {
+		t.Fatalf("Expected record to be deleted\n")
+	}
+}
+
+func TestMemoryStore_Providers(t *testing.T) {
+	store := NewMemoryStore()
+	first := generateRecord(time.Hour)
+	second := first
+	second.Value.Port++
+	store.Put(first)
+	store.Put(second)
+
+	// storing the same provider again only refreshes its record
+	refreshed := first
+	refreshed.Expires = first.Expires.Add(time.Hour)
+	store.Put(refreshed)
+
+	rs, _ := store.Get(first.Key)
+	if len(rs) != 2 || store.Len() != 2 {
+		t.Fatalf("Expected 2 providers, but got %v\n", rs)
+	}
+
+	for _, r := range rs {
+		if r.Provider() == first.Provider() && !r.Expires.Equal(refreshed.Expires) {
+			t.Fatalf("Expected the provider record to be replaced, but got %v\n", r)
+		}
+	}
+
+	store.Remove(first)
+	rs, _ = store.Get(first.Key)
+	if len(rs) != 1 || rs[0].Provider() != second.Provider() {
+		t.Fatalf("Expected only %s to remain, but got %v\n", second.Provider(), rs)
+	}
+
+	store.Remove(second)
+	if store.Len() != 0 {
+		t.Fatalf("Expected store to be empty, but got %d\n", store.Len())
+	}
+}
+
+func TestMemoryStore_Iterate(t *testing.T) {
+	store := NewMemoryStore()
+	for i := 0; i < 5; i++ {
+		store.Put(generateRecord(time.Hour))
+	}
+
+	var count int
+	store.Iterate(func(r Record) bool {
+		count++
+		// deleting while iterating must not deadlock
+		store.Delete(r.Key)
+		return true
+	})
+
+	if count != 5 {
+		t.Fatalf("Expected to iterate 5 records, but got %d\n", count)
+	}
+
+	if store.Len() != 0 {
+		t.Fatalf("Expected store to be empty, but got %d\n", store.Len())
+	}
+}
+
+func TestMemoryStore_Data(t *testing.T) {
+	store := NewMemoryStore()
+	provider := generateRecord(time.Hour)
+	data := Record{Key: provider.Key, Data: []byte("v1"), Expires: provider.Expires}
+	store.Put(provider)
+	store.Put(data)
+
+	// a key holds a single data record next to its providers
+	data.Data = []byte("v2")
+	store.Put(data)
+
+	rs, _ := store.Get(provider.Key)
+	if len(rs) != 2 {
+		t.Fatalf("Expected a provider and a data record, but got %v\n", rs)
+	}
+
+	for _, r := range rs {
+		if r.IsData() && string(r.Data) != "v2" {
+			t.Fatalf("Expected the data record to be replaced, but got %s\n", r.Data)
+		}
+	}
+
+	store.Remove(data)
+	if rs, _ := store.Get(provider.Key); len(rs) != 1 || rs[0].IsData() {
+		t.Fatalf("Expected only the provider to remain, but got %v\n", rs)
+	}
+}
+
+func TestMemoryStore_RemoveIfExpired(t *testing.T) {
+	store := NewMemoryStore()
+	record := generateRecord(time.Minute)
+	store.Put(record)
+
+	if removed, _ := store.RemoveIfExpired(record.Key, record.Provider(), time.Now()); removed {
+		t.Fatalf("Expected a live record to be kept\n")
+	}
+
+	// the record expired when it was read, but was refreshed before it is removed
+	later := record.Expires.Add(time.Second)
+	refreshed := record
+	refreshed.Expires = later.Add(time.Hour)
+	store.Put(refreshed)
+	if removed, _ := store.RemoveIfExpired(record.Key, record.Provider(), later); removed || store.Len() != 1 {
+		t.Fatalf("Expected the refreshed record to be kept\n")
+	}
+
+	if removed, _ := store.RemoveIfExpired(record.Key, record.Provider(), refreshed.Expires); !removed || store.Len() != 0 {
+		t.Fatalf("Expected the expired record to be removed\n")
+	}
+}
+
+func TestRecord_Expired(t *testing.T) {
+	now := time.Now()
+	record := generateRecord(time.Minute)
+
+	if record.Expired(now) {
+		t.Fatalf("Expected record not to be expired\n")
+	}
+
+	if !record.Expired(now.Add(time.Hour)) {
+		t.Fatalf("Expected record to be expired\n")
+	}
+}
+
+func generateRecord(ttl time.Duration) Record {
+	now := time.Now()
+	return Record{
+		Key:     gokad.GenerateRandomID(),
+		Value:   gokad.Value{Host: net.ParseIP("127.0.0.1"), Port: 5050},
+		Expires: now.Add(ttl),
+		Stored:  now,
+	}
+}
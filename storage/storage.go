@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"github.com/alabianca/gokad"
+	"github.com/alabianca/kadnet/mutable"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Record is a provider a node holds for a key on behalf of the network.
+// A key maps to a set of records, one per provider, and at most one data record
+type Record struct {
+	Key   gokad.ID
+	Value gokad.Value
+	// Data is the opaque value of a data record. Value is not set for data records
+	Data []byte
+	// Mutable is set if the data record is a signed mutable record. Data is Mutable.Data then
+	Mutable *mutable.Record
+	// Expires is the time after which the record is evicted (tExpire)
+	Expires time.Time
+	// Stored is the last time the record was received with a STORE_RPC or replicated by the node
+	Stored time.Time
+}
+
+// Expired reports whether the record reached its expiry at time now
+func (r Record) Expired(now time.Time) bool {
+	return !now.Before(r.Expires)
+}
+
+// Provider identifies the provider of the record by its address. It is empty for data records
+func (r Record) Provider() string {
+	if r.IsData() {
+		return ""
+	}
+
+	return Provider(r.Value)
+}
+
+// IsData reports whether r holds an opaque value rather than a provider address
+func (r Record) IsData() bool {
+	return r.Data != nil
+}
+
+// Provider returns the address of value as host:port
+func Provider(value gokad.Value) string {
+	return net.JoinHostPort(value.Host.String(), strconv.Itoa(value.Port))
+}
+
+// ValueStore holds the records of a node. Implementations must be safe for concurrent use.
+type ValueStore interface {
+	// Put adds r to the providers of r.Key. A record of the same provider is replaced
+	Put(r Record) error
+	// Get returns the records of every provider stored for key
+	Get(key gokad.ID) ([]Record, error)
+	// Delete removes all records stored for key. Deleting a key that is not stored is not an error
+	Delete(key gokad.ID) error
+	// Remove removes the record of the provider of r for r.Key. Removing a record that is not stored is not an error
+	Remove(r Record) error
+	// RemoveIfExpired removes the record of provider for key only if it is expired at now. It reports whether it was removed.
+	// The check and the removal are atomic, so a record that was refreshed after it was read is kept
+	RemoveIfExpired(key gokad.ID, provider string, now time.Time) (bool, error)
+	// Iterate calls f for every stored record until f returns false
+	Iterate(f func(r Record) bool) error
+	// Len returns the number of stored records
+	Len() int
+}
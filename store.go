@@ -0,0 +1,216 @@
+package kadnet
+
+import (
+	"context"
+	"errors"
+	"github.com/alabianca/gokad"
+	"github.com/alabianca/kadnet/kadclock"
+	"github.com/alabianca/kadnet/messages"
+	"github.com/alabianca/kadnet/response"
+	"github.com/alabianca/kadnet/storage"
+	"github.com/alabianca/kadnet/transaction"
+	"sync"
+	"time"
+)
+
+const (
+	ErrStoreQuorum   = "store was not acknowledged by enough replicas"
+	ErrValueTooLarge = "value is larger than the maximum value size"
+	ErrValueNotFound = "value not found"
+)
+
+// Consistency is how many of the k closest contacts must acknowledge a store for it to succeed
+type Consistency int
+
+const (
+	// StoreOne needs a single acknowledgement
+	StoreOne Consistency = iota
+	// StoreQuorum needs a majority of the k closest contacts
+	StoreQuorum
+	// StoreAll needs as many acknowledgements as there are k closest contacts
+	StoreAll
+)
+
+// StoreOptions control when a store is considered successful
+type StoreOptions struct {
+	Consistency Consistency
+	// Acks is the exact number of acknowledgements needed. It overrides Consistency if greater than 0
+	Acks int
+	// Timeout bounds the whole store, including the lookup and all retries. 0 means no limit
+	Timeout time.Duration
+}
+
+// required returns the number of acknowledgements needed if replicas contacts are the k closest
+func (opts StoreOptions) required(replicas int) int {
+	if opts.Acks > 0 {
+		return opts.Acks
+	}
+
+	required := 1
+	switch opts.Consistency {
+	case StoreQuorum:
+		required = replicas/2 + 1
+	case StoreAll:
+		required = replicas
+	}
+
+	if required < 1 {
+		required = 1
+	}
+
+	return required
+}
+
+// StoreResult reports how contacts answered the STORE_RPC's of a Store
+type StoreResult struct {
+	// Required is the number of acknowledgements the store needed
+	Required int
+	// Replicas holds one entry per contact a STORE_RPC was sent to, the k closest first and retries after
+	Replicas []ReplicaResult
+}
+
+// Acknowledged returns the contacts that acknowledged the store
+func (r StoreResult) Acknowledged() []gokad.Contact {
+	out := make([]gokad.Contact, 0, len(r.Replicas))
+	for _, replica := range r.Replicas {
+		if replica.Acknowledged() {
+			out = append(out, replica.Contact)
+		}
+	}
+
+	return out
+}
+
+// Failed returns the replicas that did not acknowledge the store
+func (r StoreResult) Failed() []ReplicaResult {
+	out := make([]ReplicaResult, 0)
+	for _, replica := range r.Replicas {
+		if !replica.Acknowledged() {
+			out = append(out, replica)
+		}
+	}
+
+	return out
+}
+
+// ReplicaResult is the outcome of the STORE_RPC sent to a single contact
+type ReplicaResult struct {
+	Contact gokad.Contact
+	// Err is nil if the contact acknowledged the store. Otherwise it is the reason it did not
+	Err error
+}
+
+func (r ReplicaResult) Acknowledged() bool {
+	return r.Err == nil
+}
+
+// TimedOut reports whether the contact did not answer in time
+func (r ReplicaResult) TimedOut() bool {
+	return r.Err != nil && r.Err.Error() == transaction.TimeoutErr
+}
+
+// store sends STORE_RPC's or STORE_DATA_RPC's for r to the k closest nodes. As long as fewer than opts require acknowledged
+// it keeps sending to the next closest contacts in the routing table that were not tried yet.
+// If the requirement is not met it returns ctx.Err() if ctx is done and ErrStoreQuorum otherwise
+func (n *Node) store(ctx context.Context, r storage.Record, ttl time.Duration, opts StoreOptions) (StoreResult, error) {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = kadclock.WithTimeout(ctx, n.Clock, opts.Timeout)
+		defer cancel()
+	}
+
+	// other nodes may return this node as one of the closest. It does not need a STORE_RPC from itself
+	var result StoreResult
+	cs, err := n.LookupWithOptions(ctx, r.Key, LookupOptions{Exclude: []gokad.ID{n.ID()}})
+	if err != nil {
+		return result, err
+	}
+
+	result.Required = opts.required(len(cs))
+	client := n.NewClient()
+	tried := make(map[string]bool)
+	acks := 0
+	for batch := cs; len(batch) > 0; batch = n.nextReplicas(r.Key, tried, result.Required-acks) {
+		for _, replica := range n.storeBatch(ctx, client, batch, r, ttl) {
+			tried[replica.Contact.ID.String()] = true
+			if replica.Acknowledged() {
+				acks++
+			}
+			result.Replicas = append(result.Replicas, replica)
+		}
+
+		if acks >= result.Required || ctx.Err() != nil {
+			break
+		}
+	}
+
+	if acks >= result.Required {
+		return result, nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+
+	return result, errors.New(ErrStoreQuorum)
+}
+
+// nextReplicas returns up to count contacts closest to key that are not in tried
+func (n *Node) nextReplicas(key gokad.ID, tried map[string]bool, count int) []gokad.Contact {
+	out := make([]gokad.Contact, 0, count)
+	for _, c := range n.dht.getAlphaNodes(len(tried)+count, key) {
+		if len(out) == count {
+			break
+		}
+		if tried[c.ID.String()] {
+			continue
+		}
+		out = append(out, c)
+	}
+
+	return out
+}
+
+// storeBatch sends a STORE_RPC to every contact at once and waits for all acknowledgements
+func (n *Node) storeBatch(ctx context.Context, client *Client, cs []gokad.Contact, r storage.Record, ttl time.Duration) []ReplicaResult {
+	out := make([]ReplicaResult, len(cs))
+	var wg sync.WaitGroup
+	wg.Add(len(cs))
+	for i, c := range cs {
+		go func(i int, contact gokad.Contact) {
+			defer wg.Done()
+			out[i] = ReplicaResult{Contact: contact, Err: n.storeAt(ctx, client, contact, r, ttl)}
+		}(i, c)
+	}
+
+	wg.Wait()
+	return out
+}
+
+// storeAt sends a single STORE_RPC, STORE_DATA_RPC or STORE_MUTABLE_RPC to contact and waits for its acknowledgement
+func (n *Node) storeAt(ctx context.Context, client *Client, contact gokad.Contact, r storage.Record, ttl time.Duration) error {
+	var res *response.Response
+	var err error
+	if r.Mutable != nil {
+		res, err = client.StoreMutableContext(ctx, contact, *r.Mutable, ttl)
+	} else if r.IsData() {
+		res, err = client.StoreDataContext(ctx, contact, r.Key, r.Data, ttl)
+	} else {
+		res, err = client.StoreContext(ctx, contact, r.Key, r.Value, ttl)
+	}
+	if err != nil {
+		return err
+	}
+
+	res.ReadTimeout(n.RoundTimeout)
+	var ack messages.StoreResponse
+	if _, err := res.Read(&ack); err != nil {
+		return err
+	}
+
+	if ack.EchoRandomID != res.RandomID() {
+		return errors.New("store acknowledgement does not match the request")
+	}
+
+	return nil
+}
@@ -0,0 +1,220 @@
+package transaction
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/alabianca/gokad"
+	"github.com/alabianca/kadnet/kadclock"
+	"github.com/alabianca/kadnet/messages"
+	"sync"
+	"time"
+)
+
+const TimeoutErr = "timeout error"
+
+// DefaultExpiry is how long a transaction without a deadline stays in the table
+const DefaultExpiry = time.Second * 30
+
+// sweepInterval is the minimum time between two sweeps for expired transactions
+const sweepInterval = time.Second
+
+// Table holds the pending transactions of a node. Every outgoing RPC registers its RandomID,
+// and every incoming response is matched to it by its EchoRandomID
+type Table struct {
+	mtx       sync.Mutex
+	pending   map[string]*Transaction
+	unmatched uint64
+	lastSweep time.Time
+	clock     kadclock.Clock
+}
+
+func NewTable() *Table {
+	return NewTableWithClock(kadclock.Real())
+}
+
+// NewTableWithClock returns a table whose deadlines and timeouts pass on clock
+func NewTableWithClock(clock kadclock.Clock) *Table {
+	return &Table{
+		pending:   make(map[string]*Transaction),
+		lastSweep: clock.Now(),
+		clock:     clock,
+	}
+}
+
+// Now returns the time on the clock of the table. Deadlines passed to Register are compared to it
+func (t *Table) Now() time.Time {
+	return t.clock.Now()
+}
+
+// Transaction is a single pending RPC waiting for its response
+type Transaction struct {
+	id       string
+	sender   gokad.ID
+	deadline time.Time
+	result   chan messages.Message
+	table    *Table
+	failed   chan struct{}
+	fail     sync.Once
+	err      error
+}
+
+// Register adds a transaction for randomID. Only responses sent by sender match it.
+// If sender is nil the first response echoing randomID matches.
+// The transaction is dropped at deadline if no response arrived. A zero deadline means DefaultExpiry from now
+func (t *Table) Register(randomID string, sender gokad.ID, deadline time.Time) *Transaction {
+	now := t.clock.Now()
+	if deadline.IsZero() {
+		deadline = now.Add(DefaultExpiry)
+	}
+
+	tx := &Transaction{
+		id:       randomID,
+		sender:   sender,
+		deadline: deadline,
+		result:   make(chan messages.Message, 1),
+		table:    t,
+		failed:   make(chan struct{}),
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if now.Sub(t.lastSweep) >= sweepInterval {
+		t.sweep(now)
+	}
+	t.pending[randomID] = tx
+
+	return tx
+}
+
+// Deliver hands msg to the transaction its EchoRandomID belongs to and removes the transaction.
+// It returns false and counts msg as unmatched if no transaction is waiting for it
+func (t *Table) Deliver(msg messages.Message) bool {
+	tx, ok := t.match(msg)
+	if !ok {
+		return false
+	}
+
+	tx.result <- msg
+	return true
+}
+
+func (t *Table) match(msg messages.Message) (*Transaction, bool) {
+	echo, err := msg.EchoRandomID()
+	if err != nil {
+		t.countUnmatched()
+		return nil, false
+	}
+	sender, err := msg.SenderID()
+	if err != nil {
+		t.countUnmatched()
+		return nil, false
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	id := gokad.ID(echo).String()
+	tx, ok := t.pending[id]
+	if !ok || (tx.sender != nil && !bytes.Equal(tx.sender, sender)) {
+		t.unmatched++
+		return nil, false
+	}
+
+	delete(t.pending, id)
+	return tx, true
+}
+
+// Unmatched returns the number of responses that did not match a pending transaction
+func (t *Table) Unmatched() uint64 {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return t.unmatched
+}
+
+// Len returns the number of pending transactions
+func (t *Table) Len() int {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return len(t.pending)
+}
+
+func (t *Table) countUnmatched() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.unmatched++
+}
+
+func (t *Table) remove(tx *Transaction) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.pending[tx.id] == tx {
+		delete(t.pending, tx.id)
+	}
+}
+
+// sweep drops all transactions past their deadline. The caller must hold t.mtx
+func (t *Table) sweep(now time.Time) {
+	t.lastSweep = now
+	for id, tx := range t.pending {
+		if now.After(tx.deadline) {
+			delete(t.pending, id)
+		}
+	}
+}
+
+// ID returns the RandomID of the request the transaction waits for a response to
+func (tx *Transaction) ID() string {
+	return tx.id
+}
+
+// Wait blocks until the response arrives, timeout passes or ctx is done.
+// A timeout leaves the transaction pending, so a late response can still be read with another Wait.
+// The transaction is removed once ctx is done or its deadline passed. A timeout of 0 waits until the deadline
+func (tx *Transaction) Wait(ctx context.Context, timeout time.Duration) (messages.Message, error) {
+	expired := false
+	clock := tx.table.clock
+	wait := tx.deadline.Sub(clock.Now())
+	if timeout > 0 && timeout < wait {
+		wait = timeout
+	} else {
+		expired = true
+	}
+
+	// if ctx ends no later than the transaction let ctx end the wait, so the caller sees ctx.Err()
+	var fired <-chan time.Time
+	if d, ok := ctx.Deadline(); !expired || !ok || d.After(tx.deadline) {
+		timer := clock.NewTimer(wait)
+		defer timer.Stop()
+		fired = timer.C()
+	}
+
+	select {
+	case msg := <-tx.result:
+		return msg, nil
+	case <-tx.failed:
+		return nil, tx.err
+	case <-fired:
+		if expired {
+			tx.Cancel()
+		}
+		return nil, errors.New(TimeoutErr)
+	case <-ctx.Done():
+		tx.Cancel()
+		return nil, ctx.Err()
+	}
+}
+
+// Fail removes the transaction from its table and lets every Wait return err right away.
+// It is called if the request could not be sent, so nobody waits for a response that cannot come
+func (tx *Transaction) Fail(err error) {
+	tx.fail.Do(func() {
+		tx.err = err
+		tx.Cancel()
+		close(tx.failed)
+	})
+}
+
+// Cancel removes the transaction from its table. A response that arrives afterwards is unmatched
+func (tx *Transaction) Cancel() {
+	tx.table.remove(tx)
+}
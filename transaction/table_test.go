@@ -0,0 +1,193 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+	"github.com/alabianca/gokad"
+	"github.com/alabianca/kadnet/kadclock"
+	"github.com/alabianca/kadnet/messages"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTable_Deliver(t *testing.T) {
+	table := NewTable()
+	sender := gokad.GenerateRandomID()
+	randomID := gokad.GenerateRandomID().String()
+	tx := table.Register(randomID, sender, time.Time{})
+
+	msg := findNodeResponse(t, sender, randomID)
+	if !table.Deliver(msg) {
+		t.Fatalf("Expected response to match the pending transaction\n")
+	}
+
+	res, err := tx.Wait(context.Background(), time.Second)
+	if err != nil {
+		t.Fatalf("Expected err to be nil, but got %s\n", err)
+	}
+
+	if !reflect.DeepEqual(res, msg) {
+		t.Fatalf("Expected the delivered response, but got %v\n", res)
+	}
+
+	if table.Len() != 0 {
+		t.Fatalf("Expected the transaction to be removed after delivery, but %d are pending\n", table.Len())
+	}
+}
+
+func TestTable_Unmatched(t *testing.T) {
+	table := NewTable()
+	sender := gokad.GenerateRandomID()
+	randomID := gokad.GenerateRandomID().String()
+	table.Register(randomID, sender, time.Time{})
+
+	// unknown random id
+	if table.Deliver(findNodeResponse(t, sender, gokad.GenerateRandomID().String())) {
+		t.Fatalf("Expected response with an unknown echo random id to be dropped\n")
+	}
+
+	// right random id but the wrong sender
+	if table.Deliver(findNodeResponse(t, gokad.GenerateRandomID(), randomID)) {
+		t.Fatalf("Expected response from the wrong sender to be dropped\n")
+	}
+
+	if n := table.Unmatched(); n != 2 {
+		t.Fatalf("Expected %d unmatched responses, but got %d\n", 2, n)
+	}
+
+	if table.Len() != 1 {
+		t.Fatalf("Expected the transaction to still be pending\n")
+	}
+}
+
+func TestTable_AnySender(t *testing.T) {
+	table := NewTable()
+	randomID := gokad.GenerateRandomID().String()
+	table.Register(randomID, nil, time.Time{})
+
+	if !table.Deliver(findNodeResponse(t, gokad.GenerateRandomID(), randomID)) {
+		t.Fatalf("Expected a transaction without a sender to match any sender\n")
+	}
+}
+
+func TestTransaction_WaitTimeout(t *testing.T) {
+	table := NewTable()
+	sender := gokad.GenerateRandomID()
+	randomID := gokad.GenerateRandomID().String()
+	tx := table.Register(randomID, sender, time.Time{})
+
+	_, err := tx.Wait(context.Background(), time.Millisecond*100)
+	if err == nil || err.Error() != TimeoutErr {
+		t.Fatalf("Expected %s, but got %v\n", TimeoutErr, err)
+	}
+
+	// a late response can still be read
+	table.Deliver(findNodeResponse(t, sender, randomID))
+	if _, err := tx.Wait(context.Background(), time.Second); err != nil {
+		t.Fatalf("Expected late response to be read, but got %s\n", err)
+	}
+}
+
+func TestTransaction_WaitContext(t *testing.T) {
+	table := NewTable()
+	tx := table.Register(gokad.GenerateRandomID().String(), nil, time.Time{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		cancel()
+	}()
+
+	if _, err := tx.Wait(ctx, 0); err != context.Canceled {
+		t.Fatalf("Expected %s, but got %v\n", context.Canceled, err)
+	}
+
+	if table.Len() != 0 {
+		t.Fatalf("Expected the transaction to be removed once its context is done\n")
+	}
+}
+
+func TestTransaction_WaitContextDeadline(t *testing.T) {
+	table := NewTable()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+
+	deadline, _ := ctx.Deadline()
+	for i := 0; i < 20; i++ {
+		tx := table.Register(gokad.GenerateRandomID().String(), nil, deadline)
+		if _, err := tx.Wait(ctx, time.Second); err != context.DeadlineExceeded {
+			t.Fatalf("Expected %s when ctx and transaction share a deadline, but got %v\n", context.DeadlineExceeded, err)
+		}
+	}
+}
+
+func TestTransaction_Fail(t *testing.T) {
+	table := NewTable()
+	tx := table.Register(gokad.GenerateRandomID().String(), nil, time.Time{})
+	failure := errors.New("address family not supported")
+	tx.Fail(failure)
+
+	// every wait returns the failure at once, without waiting for the deadline
+	for i := 0; i < 2; i++ {
+		if _, err := tx.Wait(context.Background(), 0); err != failure {
+			t.Fatalf("Expected err to be %s, but got %v\n", failure, err)
+		}
+	}
+	if table.Len() != 0 {
+		t.Fatalf("Expected the failed transaction to be removed, but %d are pending\n", table.Len())
+	}
+}
+
+func TestTable_Expire(t *testing.T) {
+	clock := kadclock.NewManual(time.Now())
+	table := NewTableWithClock(clock)
+	tx := table.Register(gokad.GenerateRandomID().String(), nil, clock.Now().Add(time.Millisecond*100))
+
+	res := make(chan error)
+	go func() {
+		_, err := tx.Wait(context.Background(), 0)
+		res <- err
+	}()
+	waitPending(t, clock, 1)
+	clock.Advance(time.Millisecond * 100)
+
+	if err := <-res; err == nil || err.Error() != TimeoutErr {
+		t.Fatalf("Expected %s at the deadline, but got %v\n", TimeoutErr, err)
+	}
+
+	// transactions nobody waits for are swept on a later Register
+	table.Register(gokad.GenerateRandomID().String(), nil, clock.Now().Add(time.Millisecond*100))
+	clock.Advance(sweepInterval + time.Millisecond*100)
+	table.Register(gokad.GenerateRandomID().String(), nil, time.Time{})
+
+	if table.Len() != 1 {
+		t.Fatalf("Expected expired transactions to be swept, but %d are pending\n", table.Len())
+	}
+}
+
+// waitPending waits until n timers wait for clock
+func waitPending(t *testing.T, clock *kadclock.Manual, n int) {
+	for i := 0; clock.Pending() < n; i++ {
+		if i == 100 {
+			t.Fatalf("Expected %d timers to wait for the clock, but got %d\n", n, clock.Pending())
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func findNodeResponse(t *testing.T, sender gokad.ID, echoRandomID string) messages.Message {
+	fnr := messages.FindNodeResponse{
+		SenderID:     sender.String(),
+		EchoRandomID: echoRandomID,
+		Payload:      []gokad.Contact{},
+		RandomID:     gokad.GenerateRandomID().String(),
+	}
+
+	b, err := fnr.Bytes()
+	if err != nil {
+		t.Fatalf("Could not encode response %s\n", err)
+	}
+
+	return b
+}